@@ -5,7 +5,7 @@ import (
 	"internal-transfers/internal/service"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"net/url"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -26,11 +26,8 @@ func (h *AccountHandler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateAccountRequest
 
 	if err := validateJSON(r, &req); err != nil {
-		h.logger.Warn("invalid JSON in create account request", slog.String("error", err.Error()))
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error: "Invalid JSON",
-			Code:  "INVALID_JSON",
-		})
+		h.logger.WarnContext(r.Context(), "invalid create account request", slog.String("error", err.Error()))
+		writeRequestError(w, err)
 		return
 	}
 
@@ -40,38 +37,106 @@ func (h *AccountHandler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return account response
-	response := models.AccountResponse{
-		AccountID: account.AccountID,
-		Balance:   account.Balance.String(),
+	writeJSON(w, http.StatusCreated, models.NewAccountResponse(account))
+}
+
+func (h *AccountHandler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := h.accountIDParam(w, r)
+	if !ok {
+		return
 	}
 
-	writeJSON(w, http.StatusCreated, response)
+	account, err := h.service.GetAccount(r.Context(), accountID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.NewAccountResponse(account))
 }
 
-func (h *AccountHandler) GetAccount(w http.ResponseWriter, r *http.Request) {
-	accountIDStr := chi.URLParam(r, "account_id")
+// handle POST /accounts/{account_id}/delegates
+func (h *AccountHandler) AddDelegate(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := h.accountIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.AddDelegateRequest
+	if err := validateJSON(r, &req); err != nil {
+		h.logger.WarnContext(r.Context(), "invalid add delegate request", slog.String("error", err.Error()))
+		writeRequestError(w, err)
+		return
+	}
+
+	if err := h.service.AddDelegate(r.Context(), accountID, req.PrincipalID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+// handle DELETE /accounts/{account_id}/delegates/{principal_id}
+func (h *AccountHandler) RemoveDelegate(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := h.accountIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// principal IDs may contain characters that are escaped in the path
+	principalID, err := url.PathUnescape(chi.URLParam(r, "principal_id"))
 	if err != nil {
-		h.logger.Warn("invalid account ID format", slog.String("account_id", accountIDStr))
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error: "Invalid account ID format",
+			Error: "Invalid principal ID",
 			Code:  "INVALID_ID_FORMAT",
 		})
 		return
 	}
 
-	account, err := h.service.GetAccount(r.Context(), accountID)
+	if err := h.service.RemoveDelegate(r.Context(), accountID, principalID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handle PUT /admin/accounts/{account_id}/shards
+func (h *AccountHandler) SetShards(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := h.accountIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.SetShardsRequest
+	if err := validateJSON(r, &req); err != nil {
+		h.logger.WarnContext(r.Context(), "invalid set shards request", slog.String("error", err.Error()))
+		writeRequestError(w, err)
+		return
+	}
+
+	account, err := h.service.SetShards(r.Context(), accountID, req.Shards)
 	if err != nil {
 		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	response := models.AccountResponse{
-		AccountID: account.AccountID,
-		Balance:   account.Balance.String(),
+	writeJSON(w, http.StatusOK, models.NewAccountResponse(account))
+}
+
+// handle GET /admin/reconciliation
+func (h *AccountHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := h.service.Reconcile(r.Context())
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	writeJSON(w, http.StatusOK, response)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// parse the account_id URL parameter, writing the error response if invalid
+func (h *AccountHandler) accountIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return idParam(w, r, "account_id", h.logger)
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/service"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// header carrying the api key
+const apiKeyHeader = "X-API-Key"
+
+type APIKeyHandler struct {
+	service service.APIKeyService
+	logger  *slog.Logger
+}
+
+func NewAPIKeyHandler(service service.APIKeyService, logger *slog.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// handle POST /admin/api-keys
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+
+	if err := validateJSON(r, &req); err != nil {
+		h.logger.WarnContext(r.Context(), "invalid create api key request", slog.String("error", err.Error()))
+		writeRequestError(w, err)
+		return
+	}
+
+	key, rawKey, err := h.service.IssueKey(r.Context(), &req)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := models.APIKeyResponse{
+		ID:        key.ID,
+		TenantID:  key.TenantID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		Key:       rawKey,
+	}
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// handle DELETE /admin/api-keys/{key_id}
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyIDStr := chi.URLParam(r, "key_id")
+
+	keyID, err := strconv.ParseInt(keyIDStr, 10, 64)
+	if err != nil {
+		h.logger.WarnContext(r.Context(), "invalid api key ID format", slog.String("key_id", keyIDStr))
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid api key ID format",
+			Code:  "INVALID_ID_FORMAT",
+		})
+		return
+	}
+
+	if err := h.service.RevokeKey(r.Context(), keyID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticates requests carrying an X-API-Key header
+type apiKeyAuthenticator struct {
+	service service.APIKeyService
+}
+
+func NewAPIKeyAuthenticator(service service.APIKeyService) auth.Authenticator {
+	return &apiKeyAuthenticator{service: service}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	rawKey := r.Header.Get(apiKeyHeader)
+	if rawKey == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	return a.service.Authenticate(r.Context(), rawKey)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed swagger.html
+var swaggerUIPage []byte
+
+// Swagger UI itself, served by the binary so that the docs work without
+// internet access and load no third-party script
+//
+//go:embed static/swagger-ui
+var staticFiles embed.FS
+
+var swaggerUIFiles, _ = fs.Sub(staticFiles, "static/swagger-ui")
+
+// serve the OpenAPI document
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// serve the Swagger UI page for the OpenAPI document
+func SwaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(swaggerUIPage)
+}
+
+// serve the scripts and styles of the Swagger UI page
+func SwaggerUIAssetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFileFS(w, r, swaggerUIFiles, chi.URLParam(r, "file"))
+}
+
+// check that every route registered on the router is described in the
+// OpenAPI document and that the document has no entries without a route.
+// Entries listed in unmounted, as "METHOD /path", may lack a route since
+// they are only mounted when the matching feature is enabled.
+func CheckSpecCoverage(routes chi.Routes, unmounted ...string) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var problems []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + normalizeRoute(route)
+		if !documented[key] {
+			problems = append(problems, "missing from spec: "+key)
+		}
+		delete(documented, key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk routes: %w", err)
+	}
+
+	for _, key := range unmounted {
+		delete(documented, key)
+	}
+	for key := range documented {
+		problems = append(problems, "no matching route: "+key)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("OpenAPI spec out of date: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// chi reports routes mounted with Route("/x", ...) and Post("/") as "/x/"
+func normalizeRoute(route string) string {
+	route = strings.ReplaceAll(route, "/*/", "/")
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSwaggerUIServedByBinary(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/docs", SwaggerUIHandler)
+	router.Get("/docs/{file}", SwaggerUIAssetHandler)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	page := get("/docs")
+	if page.Code != http.StatusOK {
+		t.Fatalf("GET /docs = %d", page.Code)
+	}
+	if external := regexp.MustCompile(`(src|href)="(https?:)?//`).FindString(page.Body.String()); external != "" {
+		t.Errorf("page loads from another origin: %s", external)
+	}
+
+	// every asset the page references is embedded
+	for _, match := range regexp.MustCompile(`(?:src|href)="(/docs/[^"]+)"`).FindAllStringSubmatch(page.Body.String(), -1) {
+		if w := get(match[1]); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET %s = %d with %d bytes", match[1], w.Code, w.Body.Len())
+		}
+	}
+
+	if w := get("/docs/missing.js"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /docs/missing.js = %d, want 404", w.Code)
+	}
+}
+
+// every error code a handler can write is listed in the ErrorCode enum of
+// the spec, and the enum lists no code that is never written
+func TestErrorCodesInSpec(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas struct {
+				ErrorCode struct {
+					Enum []string `json:"enum"`
+				} `json:"ErrorCode"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	documented := spec.Components.Schemas.ErrorCode.Enum
+
+	// codes are string literals assigned to code or a Code/code field
+	emitted := make(map[string]bool)
+	files, _ := filepath.Glob("*.go")
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			var key, value ast.Expr
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if len(n.Lhs) == 1 && len(n.Rhs) == 1 {
+					key, value = n.Lhs[0], n.Rhs[0]
+				}
+			case *ast.KeyValueExpr:
+				key, value = n.Key, n.Value
+			}
+			ident, ok := key.(*ast.Ident)
+			lit, isLit := value.(*ast.BasicLit)
+			if ok && isLit && strings.EqualFold(ident.Name, "code") && lit.Kind == token.STRING {
+				emitted[strings.Trim(lit.Value, `"`)] = true
+			}
+			return true
+		})
+	}
+	if len(emitted) < 10 {
+		t.Fatalf("found only %d error codes, the search no longer matches the handlers", len(emitted))
+	}
+
+	for _, code := range slices.Sorted(maps.Keys(emitted)) {
+		if !slices.Contains(documented, code) {
+			t.Errorf("error code %s is missing from the ErrorCode enum", code)
+		}
+	}
+	for _, code := range documented {
+		if !emitted[code] {
+			t.Errorf("ErrorCode enum lists %s, which no handler writes", code)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"internal-transfers/internal/health"
+	"log/slog"
+	"net/http"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+	logger  *slog.Logger
+}
+
+func NewHealthHandler(checker *health.Checker, logger *slog.Logger) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+// handle GET /livez, the process is up and serving
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// handle GET /health, same as /livez with the body existing probes expect
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+}
+
+// handle GET /readyz, the server can handle traffic
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.checker.Ready(r.Context())
+	if !report.Ready {
+		h.logger.WarnContext(r.Context(), "readiness check failed", slog.Any("checks", report.Checks))
+		writeJSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"internal-transfers/internal/health"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	draining := health.NewChecker(time.Second)
+	draining.SetDraining()
+
+	tests := []struct {
+		name    string
+		checker *health.Checker
+		handler func(h *HealthHandler) http.HandlerFunc
+		status  int
+		body    string
+	}{
+		{"livez", health.NewChecker(time.Second), func(h *HealthHandler) http.HandlerFunc { return h.Live }, http.StatusOK, `{"status":"alive"}`},
+		{"health keeps its body", health.NewChecker(time.Second), func(h *HealthHandler) http.HandlerFunc { return h.Health }, http.StatusOK, `{"status":"healthy"}`},
+		{"health while draining", draining, func(h *HealthHandler) http.HandlerFunc { return h.Health }, http.StatusOK, `{"status":"healthy"}`},
+		{"ready", health.NewChecker(time.Second), func(h *HealthHandler) http.HandlerFunc { return h.Ready }, http.StatusOK, `"status":"ready"`},
+		{"ready while draining", draining, func(h *HealthHandler) http.HandlerFunc { return h.Ready }, http.StatusServiceUnavailable, `"status":"not_ready"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealthHandler(tt.checker, discardLogger)
+			w := httptest.NewRecorder()
+			tt.handler(h)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if body := strings.TrimSpace(w.Body.String()); !strings.Contains(body, tt.body) {
+				t.Errorf("body = %s, want it to contain %s", body, tt.body)
+			}
+		})
+	}
+}
@@ -3,16 +3,24 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"internal-transfers/internal/models"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // API error response structure
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    string `json:"code,omitempty"`
-	Details string `json:"details,omitempty"`
+	Error   string       `json:"error"`
+	Code    string       `json:"code,omitempty"`
+	Details string       `json:"details,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
 }
 
 // generate a JSON response format
@@ -25,10 +33,37 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 }
 
 func writeError(w http.ResponseWriter, err error, defaultStatus int) {
-	var status int
-	var code string
-	var details string
+	status, code, details := errorStatus(err, defaultStatus)
+	if code == "INTERNAL_ERROR" {
+		slog.Error("unhandled error", slog.String("error", err.Error()))
+	}
+
+	writeJSON(w, status, ErrorResponse{
+		Error:   err.Error(),
+		Code:    code,
+		Details: details,
+	})
+}
 
+// parse a numeric ID URL parameter, writing the error response if invalid
+func idParam(w http.ResponseWriter, r *http.Request, name string, logger *slog.Logger) (int64, bool) {
+	value := chi.URLParam(r, name)
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.WarnContext(r.Context(), "invalid ID format", slog.String(name, value))
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid " + strings.ReplaceAll(strings.TrimSuffix(name, "_id"), "_", " ") + " ID format",
+			Code:  "INVALID_ID_FORMAT",
+		})
+		return 0, false
+	}
+
+	return id, true
+}
+
+// map model errors to an HTTP status and error code
+func errorStatus(err error, defaultStatus int) (status int, code string, details string) {
 	// Map model errors to HTTP status codes
 	switch {
 	case errors.Is(err, models.ErrAccountNotFound):
@@ -47,6 +82,18 @@ func writeError(w http.ResponseWriter, err error, defaultStatus int) {
 		status = http.StatusBadRequest
 		code = "INVALID_ACCOUNT_ID"
 		details = err.Error()
+	case errors.Is(err, models.ErrAccessDenied):
+		status = http.StatusForbidden
+		code = "ACCOUNT_ACCESS_DENIED"
+		details = err.Error()
+	case errors.Is(err, models.ErrDelegateNotFound):
+		status = http.StatusNotFound
+		code = "DELEGATE_NOT_FOUND"
+		details = err.Error()
+	case errors.Is(err, models.ErrInvalidShards):
+		status = http.StatusBadRequest
+		code = "INVALID_SHARDS"
+		details = err.Error()
 	case errors.Is(err, models.ErrInsufficientBalance):
 		status = http.StatusUnprocessableEntity
 		code = "INSUFFICIENT_BALANCE"
@@ -59,27 +106,211 @@ func writeError(w http.ResponseWriter, err error, defaultStatus int) {
 		status = http.StatusBadRequest
 		code = "INVALID_AMOUNT"
 		details = err.Error()
+	case errors.Is(err, models.ErrCrossTenantTransfer):
+		status = http.StatusForbidden
+		code = "CROSS_TENANT_FORBIDDEN"
+		details = err.Error()
+	case errors.Is(err, models.ErrTransactionNotFound):
+		status = http.StatusNotFound
+		code = "TRANSACTION_NOT_FOUND"
+		details = err.Error()
+	case errors.Is(err, models.ErrAlreadyReversed):
+		status = http.StatusConflict
+		code = "ALREADY_REVERSED"
+		details = err.Error()
+	case errors.Is(err, models.ErrNotReversible):
+		status = http.StatusConflict
+		code = "NOT_REVERSIBLE"
+		details = err.Error()
+	case errors.Is(err, models.ErrTransferConflict):
+		status = http.StatusServiceUnavailable
+		code = "TRANSFER_CONFLICT"
+		details = err.Error()
+	case errors.Is(err, models.ErrInvalidTenantID):
+		status = http.StatusBadRequest
+		code = "INVALID_TENANT_ID"
+		details = err.Error()
 	case errors.Is(err, models.ErrAccountsNotFound):
 		status = http.StatusBadRequest
 		code = "ACCOUNTS_NOT_FOUND"
 		details = err.Error()
+	case errors.Is(err, models.ErrUnauthenticated):
+		status = http.StatusUnauthorized
+		code = "UNAUTHENTICATED"
+		details = err.Error()
+	case errors.Is(err, models.ErrNoTenant):
+		status = http.StatusForbidden
+		code = "TENANT_REQUIRED"
+		details = err.Error()
+	case errors.Is(err, models.ErrInsufficientScope):
+		status = http.StatusForbidden
+		code = "INSUFFICIENT_SCOPE"
+		details = err.Error()
+	case errors.Is(err, models.ErrAPIKeyNotFound):
+		status = http.StatusNotFound
+		code = "API_KEY_NOT_FOUND"
+		details = err.Error()
+	case errors.Is(err, models.ErrInvalidScope):
+		status = http.StatusBadRequest
+		code = "INVALID_SCOPE"
+		details = err.Error()
+	case errors.Is(err, models.ErrRateLimited):
+		status = http.StatusTooManyRequests
+		code = "RATE_LIMITED"
+		details = err.Error()
 	default:
 		status = defaultStatus
 		code = "INTERNAL_ERROR"
 		details = "An internal error occurred"
-		slog.Error("unhandled error", slog.String("error", err.Error()))
 	}
 
-	writeJSON(w, status, ErrorResponse{
-		Error:   err.Error(),
-		Code:    code,
-		Details: details,
-	})
+	return status, code, details
+}
+
+// request body rejected before it reached validation
+type decodeError struct {
+	status  int
+	code    string
+	message string
 }
 
+func (e *decodeError) Error() string {
+	return e.message
+}
+
+// strictly decode a single JSON object from the request body and check it
+// against the validate tags. Unknown fields and trailing data are rejected.
+// The body size limit is enforced by BodyLimitMiddleware.
 func validateJSON(r *http.Request, v interface{}) error {
-	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+	if err := checkContentType(r); err != nil {
 		return err
 	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return decodeErrorFor(err)
+	}
+
+	// the body must contain exactly one JSON value
+	end := dec.InputOffset()
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeErrorFor(err)
+		}
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			message: fmt.Sprintf("request body must contain a single JSON object (unexpected data after offset %d)", end),
+		}
+	}
+
+	return validateStruct(v)
+}
+
+func checkContentType(r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return &decodeError{
+			status:  http.StatusUnsupportedMediaType,
+			code:    "UNSUPPORTED_MEDIA_TYPE",
+			message: "Content-Type header must be application/json",
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/json" {
+		return &decodeError{
+			status:  http.StatusUnsupportedMediaType,
+			code:    "UNSUPPORTED_MEDIA_TYPE",
+			message: fmt.Sprintf("Content-Type %q is not supported, use application/json", contentType),
+		}
+	}
+
 	return nil
 }
+
+// translate a json decoding error into a client-facing message
+func decodeErrorFor(err error) *decodeError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			message: fmt.Sprintf("request body contains malformed JSON at offset %d", syntaxErr.Offset),
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			message: "request body contains truncated JSON",
+		}
+	case errors.As(err, &typeErr):
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_FIELD_TYPE",
+			message: fmt.Sprintf("field %q must be of type %s (offset %d)", typeErr.Field, typeErr.Type, typeErr.Offset),
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json does not export a type for unknown fields
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "UNKNOWN_FIELD",
+			message: fmt.Sprintf("request body contains unknown field %s", field),
+		}
+	case errors.Is(err, io.EOF):
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "EMPTY_BODY",
+			message: "request body must not be empty",
+		}
+	case errors.As(err, &maxBytesErr):
+		return &decodeError{
+			status:  http.StatusRequestEntityTooLarge,
+			code:    "BODY_TOO_LARGE",
+			message: fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	default:
+		// errors returned by custom unmarshalers, e.g. decimal.Decimal
+		return &decodeError{
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			message: err.Error(),
+		}
+	}
+}
+
+// write the response for a request rejected by validateJSON
+func writeRequestError(w http.ResponseWriter, err error) {
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:  "Validation failed",
+			Code:   "VALIDATION_FAILED",
+			Fields: validationErrs,
+		})
+		return
+	}
+
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		writeJSON(w, decodeErr.status, ErrorResponse{
+			Error:   "Invalid request body",
+			Code:    decodeErr.code,
+			Details: decodeErr.message,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusBadRequest, ErrorResponse{
+		Error: "Invalid JSON",
+		Code:  "INVALID_JSON",
+	})
+}
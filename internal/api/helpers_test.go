@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"internal-transfers/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int64
+		status      int
+		code        string
+		details     string
+		fields      []FieldError
+	}{
+		{
+			name:   "valid",
+			body:   `{"source_account_id":1,"destination_account_id":2,"amount":"10.5"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:        "content type with charset",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"source_account_id":1,"destination_account_id":2,"amount":"1"}`,
+			status:      http.StatusOK,
+		},
+		{
+			name:        "missing content type",
+			contentType: "-",
+			body:        `{}`,
+			status:      http.StatusUnsupportedMediaType,
+			code:        "UNSUPPORTED_MEDIA_TYPE",
+			details:     "Content-Type header must be application/json",
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			body:        `{}`,
+			status:      http.StatusUnsupportedMediaType,
+			code:        "UNSUPPORTED_MEDIA_TYPE",
+			details:     `Content-Type "text/plain" is not supported, use application/json`,
+		},
+		{
+			name:    "empty body",
+			body:    ``,
+			status:  http.StatusBadRequest,
+			code:    "EMPTY_BODY",
+			details: "request body must not be empty",
+		},
+		{
+			name:    "malformed",
+			body:    `{"source_account_id":1,}`,
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			details: "request body contains malformed JSON at offset 24",
+		},
+		{
+			name:    "truncated",
+			body:    `{"source_account_id":1`,
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			details: "request body contains truncated JSON",
+		},
+		{
+			name:    "unknown field",
+			body:    `{"source_account_id":1,"destination_account_id":2,"amount":"1","memo":"x"}`,
+			status:  http.StatusBadRequest,
+			code:    "UNKNOWN_FIELD",
+			details: `request body contains unknown field "memo"`,
+		},
+		{
+			name:    "type mismatch",
+			body:    `{"source_account_id":"1","destination_account_id":2,"amount":"1"}`,
+			status:  http.StatusBadRequest,
+			code:    "INVALID_FIELD_TYPE",
+			details: `field "source_account_id" must be of type int64 (offset 24)`,
+		},
+		{
+			name:   "invalid decimal",
+			body:   `{"source_account_id":1,"destination_account_id":2,"amount":"ten"}`,
+			status: http.StatusBadRequest,
+			code:   "INVALID_JSON",
+		},
+		{
+			name:    "trailing object",
+			body:    `{"source_account_id":1,"destination_account_id":2,"amount":"1"}{}`,
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			details: "request body must contain a single JSON object (unexpected data after offset 63)",
+		},
+		{
+			name:    "trailing garbage",
+			body:    `{"source_account_id":1,"destination_account_id":2,"amount":"1"} x`,
+			status:  http.StatusBadRequest,
+			code:    "INVALID_JSON",
+			details: "request body must contain a single JSON object (unexpected data after offset 63)",
+		},
+		{
+			name:   "trailing whitespace",
+			body:   "{\"source_account_id\":1,\"destination_account_id\":2,\"amount\":\"1\"}\n\t ",
+			status: http.StatusOK,
+		},
+		{
+			name:     "oversized",
+			body:     `{"source_account_id":1,"destination_account_id":2,"amount":"1"}`,
+			maxBytes: 32,
+			status:   http.StatusRequestEntityTooLarge,
+			code:     "BODY_TOO_LARGE",
+			details:  "request body must not be larger than 32 bytes",
+		},
+		{
+			name:     "oversized after the object",
+			body:     `{"source_account_id":1,"destination_account_id":2,"amount":"1"}` + strings.Repeat(" ", 64),
+			maxBytes: 80,
+			status:   http.StatusRequestEntityTooLarge,
+			code:     "BODY_TOO_LARGE",
+			details:  "request body must not be larger than 80 bytes",
+		},
+		{
+			name:   "validation",
+			body:   `{"source_account_id":0,"destination_account_id":2,"amount":"-1"}`,
+			status: http.StatusBadRequest,
+			code:   "VALIDATION_FAILED",
+			fields: []FieldError{
+				{Field: "source_account_id", Rule: "required"},
+				{Field: "amount", Rule: "gt", Param: "0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/transactions", strings.NewReader(tt.body))
+			switch tt.contentType {
+			case "":
+				r.Header.Set("Content-Type", "application/json")
+			case "-":
+			default:
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			if tt.maxBytes > 0 {
+				// unknown length, so that the limit is hit while decoding
+				r.ContentLength = -1
+				r.Body = http.MaxBytesReader(w, r.Body, tt.maxBytes)
+			}
+
+			var req models.CreateTransactionRequest
+			err := validateJSON(r, &req)
+			if tt.status == http.StatusOK {
+				if err != nil {
+					t.Fatalf("validateJSON() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateJSON() = nil, want an error")
+			}
+
+			writeRequestError(w, err)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Code, tt.code)
+			}
+			if tt.details != "" && resp.Details != tt.details {
+				t.Errorf("details = %q, want %q", resp.Details, tt.details)
+			}
+			if len(resp.Fields) != len(tt.fields) {
+				t.Fatalf("fields = %+v, want %+v", resp.Fields, tt.fields)
+			}
+			for i := range tt.fields {
+				if resp.Fields[i] != tt.fields[i] {
+					t.Errorf("fields[%d] = %+v, want %+v", i, resp.Fields[i], tt.fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	handler := BodyLimitMiddleware(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"within the limit", `{"a":1}`, http.StatusNoContent},
+		{"declared length over the limit", `{"account_id":123456}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
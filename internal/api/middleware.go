@@ -1,9 +1,24 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/requestid"
+	"internal-transfers/internal/tenant"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // wrapper for http.ResponseWriter to capture status code
@@ -28,6 +43,16 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// assign every request an ID, taken from X-Request-ID or traceparent when the
+// caller sends one, and echo it in the X-Request-ID response header
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.FromRequest(r)
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.WithID(r.Context(), id)))
+	})
+}
+
 // log all HTTP requests
 func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -44,7 +69,7 @@ func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 
 			duration := time.Since(start)
 
-			logger.Info("HTTP request",
+			logger.InfoContext(r.Context(), "HTTP request",
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.Int("status", wrapped.statusCode),
@@ -55,13 +80,71 @@ func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// start a server span per request, continuing the caller's trace when a
+// traceparent header is sent
+func TracingMiddleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer("internal-transfers/internal/api")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request_id", requestid.FromContext(r.Context())),
+			),
+		)
+		defer span.End()
+
+		wrapped := &responseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+
+		// the route pattern is only known once chi has matched the request
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(attribute.String("http.route", rctx.RoutePattern()))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", wrapped.statusCode))
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+		}
+	})
+}
+
+// record request counts and latency per route pattern, so path parameters
+// don't create a series per account
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		wrapped := &responseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		next.ServeHTTP(wrapped, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(wrapped.statusCode)).Inc()
+		metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
 // recover from panics and returns 500
 func RecoveryMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					logger.Error("panic recovered",
+					logger.ErrorContext(r.Context(), "panic recovered",
 						slog.Any("error", err),
 						slog.String("method", r.Method),
 						slog.String("path", r.URL.Path),
@@ -75,3 +158,106 @@ func RecoveryMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// limit the size of request bodies, decoding fails once maxBytes is exceeded
+func BodyLimitMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+					Error:   "Invalid request body",
+					Code:    "BODY_TOO_LARGE",
+					Details: fmt.Sprintf("request body must not be larger than %d bytes", maxBytes),
+				})
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// expose the verified client certificate of mutual TLS connections through
+// the request context
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only verified chains are trusted, peer certificates alone are not
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			r = r.WithContext(auth.WithClientCertificate(r.Context(), r.TLS.VerifiedChains[0][0]))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate the caller with the first authenticator that recognises the
+// request credentials, rejecting requests without valid credentials
+func AuthMiddleware(logger *slog.Logger, authenticators ...auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if errors.Is(err, auth.ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidCredentials) {
+						logger.WarnContext(r.Context(), "rejected credentials",
+							slog.String("path", r.URL.Path),
+							slog.String("error", err.Error()),
+						)
+					} else {
+						logger.ErrorContext(r.Context(), "authentication failed",
+							slog.String("path", r.URL.Path),
+							slog.String("error", err.Error()),
+						)
+					}
+					writeError(w, models.ErrUnauthenticated, http.StatusInternalServerError)
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+				return
+			}
+
+			writeError(w, models.ErrUnauthenticated, http.StatusInternalServerError)
+		})
+	}
+}
+
+// resolve the tenant of the request from the authenticated caller. Without
+// authentication, and for the bootstrap key, requests act in the default
+// tenant, other callers must be bound to a tenant.
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := tenant.Default
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok && !principal.AllTenants {
+			if !tenant.ValidID(principal.TenantID) {
+				writeError(w, fmt.Errorf("%w: %s", models.ErrNoTenant, principal.ID), http.StatusInternalServerError)
+				return
+			}
+			tenantID = principal.TenantID
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), tenantID)))
+	})
+}
+
+// reject callers that were not granted the scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				writeError(w, models.ErrUnauthenticated, http.StatusInternalServerError)
+				return
+			}
+
+			if !principal.HasScope(scope) {
+				writeError(w, fmt.Errorf("%w: %s required", models.ErrInsufficientScope, scope), http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/requestid"
+	"internal-transfers/internal/tenant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		tenant    string // empty when rejected
+	}{
+		{"authentication disabled", nil, tenant.Default},
+		{"bootstrap key", &auth.Principal{ID: "apikey:bootstrap", AllTenants: true}, tenant.Default},
+		{"caller of a tenant", &auth.Principal{ID: "apikey:1", TenantID: "acme"}, "acme"},
+		{"caller without a tenant", &auth.Principal{ID: "cert:CN=batch"}, ""},
+		{"caller of several tenants", &auth.Principal{ID: "jwt:x", TenantID: "acme,other"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = tenant.FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.tenant != "" {
+				if w.Code != http.StatusOK || got != tt.tenant {
+					t.Errorf("status %d, tenant %q, want tenant %q", w.Code, got, tt.tenant)
+				}
+				return
+			}
+			var body ErrorResponse
+			json.NewDecoder(w.Body).Decode(&body)
+			if w.Code != http.StatusForbidden || body.Code != "TENANT_REQUIRED" || got != "" {
+				t.Errorf("status %d, code %q, tenant %q, want 403 TENANT_REQUIRED", w.Code, body.Code, got)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		echoed  bool // whether the inbound ID is kept rather than replaced
+	}{
+		{"no inbound ID", "", false},
+		{"valid inbound ID", "req-42", true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"invalid characters", "req 42\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestid.FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+			if tt.inbound != "" {
+				r.Header.Set(requestid.Header, tt.inbound)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			header := w.Header().Get(requestid.Header)
+			if got == "" || header != got {
+				t.Errorf("response header %q, request context %q, want the same ID", header, got)
+			}
+			if (got == tt.inbound) != tt.echoed {
+				t.Errorf("request ID = %q for inbound %q, want it kept: %v", got, tt.inbound, tt.echoed)
+			}
+		})
+	}
+}
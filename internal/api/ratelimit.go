@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/ratelimit"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rate limit requests per API client, keyed by the authenticated principal or
+// the remote address when authentication is disabled
+func RateLimitMiddleware(limiter *ratelimit.Limiter, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowRequest(r.Context(), w, limiter, "client:"+clientKey(r), logger) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// take a token for key, writing the RateLimit-* headers and a 429 response
+// when the bucket is empty. Limiter failures let the request through.
+func allowRequest(ctx context.Context, w http.ResponseWriter, limiter *ratelimit.Limiter, key string, logger *slog.Logger) bool {
+	res, err := limiter.Allow(ctx, key)
+	if err != nil {
+		logger.ErrorContext(ctx, "rate limiter unavailable", slog.String("key", key), slog.String("error", err.Error()))
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		logger.WarnContext(ctx, "rate limit exceeded", slog.String("key", key))
+		retryAfter := ceilSeconds(res.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(w, fmt.Errorf("%w: retry after %ds", models.ErrRateLimited, retryAfter), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/ratelimit"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/service"
+	"internal-transfers/internal/tenant"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// store that cannot be reached
+type unavailableStore struct{}
+
+func (unavailableStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		store ratelimit.Store
+		codes []int
+	}{
+		{"limits after the burst", ratelimit.NewMemoryStore(), []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{"lets requests through when the store fails", unavailableStore{}, []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := ratelimit.New(tt.store, ratelimit.Limit{Rate: 0.001, Burst: 2})
+			handler := RateLimitMiddleware(limiter, discardLogger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for i, want := range tt.codes {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transactions", nil))
+				if w.Code != want {
+					t.Fatalf("request %d = %d, want %d", i+1, w.Code, want)
+				}
+				if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Error("429 without Retry-After")
+				}
+			}
+		})
+	}
+}
+
+// callers who may not debit an account cannot use up the bucket of its owner
+func TestAccountLimitPerCaller(t *testing.T) {
+	store := memory.NewStore()
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	owner := "apikey:1"
+	for id, ownerID := range map[int64]*string{1: &owner, 2: nil} {
+		err := store.Accounts.Create(ctx, &models.Account{TenantID: "acme", AccountID: id, OwnerID: ownerID, Balance: decimal.NewFromInt(100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	transfers := service.NewTransferService(store.UnitOfWork, store.Accounts, store.Transactions,
+		service.NewOwnershipAuthorizer(store.Accounts), service.CrossTenantPolicy{}, service.ConcurrencyLocking, false,
+		service.RetryPolicy{Attempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		service.BatchPolicy{}, service.AsyncPolicy{}, discardLogger)
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limit{Rate: 0.001, Burst: 2})
+	handler := NewTransactionHandler(transfers, limiter, discardLogger)
+
+	post := func(principalID string) int {
+		r := httptest.NewRequest(http.MethodPost, "/transactions", strings.NewReader(`{"source_account_id":1,"destination_account_id":2,"amount":"1"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(auth.WithPrincipal(tenant.WithTenant(r.Context(), "acme"), &auth.Principal{ID: principalID, TenantID: "acme"}))
+		w := httptest.NewRecorder()
+		handler.CreateTransaction(w, r)
+		return w.Code
+	}
+
+	for range 5 {
+		if code := post("apikey:2"); code != http.StatusForbidden && code != http.StatusTooManyRequests {
+			t.Fatalf("transfer of another caller = %d", code)
+		}
+	}
+	for i, want := range []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		if code := post(owner); code != want {
+			t.Errorf("transfer %d of the owner = %d, want %d", i+1, code, want)
+		}
+	}
+}
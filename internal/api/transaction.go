@@ -1,50 +1,192 @@
 package api
 
 import (
+	"fmt"
 	"internal-transfers/internal/models"
+	"internal-transfers/internal/ratelimit"
 	"internal-transfers/internal/service"
+	"internal-transfers/internal/tenant"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// page size of transaction listings
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// longest wait for a pending transfer, below the server's write timeout
+const maxWait = 10 * time.Second
+
 type TransactionHandler struct {
-	service service.TransferService
-	logger  *slog.Logger
+	service        service.TransferService
+	accountLimiter *ratelimit.Limiter // per source account and client, nil when disabled
+	logger         *slog.Logger
 }
 
-func NewTransactionHandler(service service.TransferService, logger *slog.Logger) *TransactionHandler {
+func NewTransactionHandler(service service.TransferService, accountLimiter *ratelimit.Limiter, logger *slog.Logger) *TransactionHandler {
 	return &TransactionHandler{
-		service: service,
-		logger:  logger,
+		service:        service,
+		accountLimiter: accountLimiter,
+		logger:         logger,
 	}
 }
 
-// handle POST /transactions
+// handle POST /transactions, queueing the transfer with async=true
 func (h *TransactionHandler) CreateTransaction(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateTransactionRequest
 
+	async := false
+	if v := r.URL.Query().Get("async"); v != "" {
+		var err error
+		async, err = strconv.ParseBool(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid query parameter",
+				Code:    "INVALID_QUERY_PARAMETER",
+				Details: "async must be true or false",
+			})
+			return
+		}
+	}
+
 	if err := validateJSON(r, &req); err != nil {
-		h.logger.Warn("invalid JSON in create transaction request", slog.String("error", err.Error()))
+		h.logger.WarnContext(r.Context(), "invalid create transaction request", slog.String("error", err.Error()))
+		writeRequestError(w, err)
+		return
+	}
+
+	// the bucket is the caller's own, so callers that may not debit the
+	// account cannot use up its owner's transfers
+	if h.accountLimiter != nil {
+		key := fmt.Sprintf("account:%s:%d:%s", tenant.FromContext(r.Context()), req.SourceAccountID, clientKey(r))
+		if !allowRequest(r.Context(), w, h.accountLimiter, key, h.logger) {
+			return
+		}
+	}
+
+	if async {
+		h.submitTransaction(w, r, &req)
+		return
+	}
+
+	transaction, err := h.service.ExecuteTransfer(r.Context(), &req)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, models.NewTransactionResponse(transaction))
+}
+
+// queue the transfer and answer with where to poll for its outcome
+func (h *TransactionHandler) submitTransaction(w http.ResponseWriter, r *http.Request, req *models.CreateTransactionRequest) {
+	transaction, err := h.service.SubmitTransfer(r.Context(), req)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := models.NewTransactionResponse(transaction)
+	response.StatusURL = fmt.Sprintf("/transactions/%d", transaction.TransactionID)
+	w.Header().Set("Location", response.StatusURL)
+	writeJSON(w, http.StatusAccepted, response)
+}
+
+// handle GET /transactions/{transaction_id}, waiting up to the wait query
+// parameter for a pending transfer to finish
+func (h *TransactionHandler) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	transactionID, ok := idParam(w, r, "transaction_id", h.logger)
+	if !ok {
+		return
+	}
+
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		wait, err = time.ParseDuration(v)
+		if err != nil || wait < 0 || wait > maxWait {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid query parameter",
+				Code:    "INVALID_QUERY_PARAMETER",
+				Details: fmt.Sprintf("wait must be a duration between 0s and %s", maxWait),
+			})
+			return
+		}
+	}
+
+	transaction, err := h.service.GetTransaction(r.Context(), transactionID, wait)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.NewTransactionResponse(transaction))
+}
+
+// handle POST /transactions/{transaction_id}/reversal
+func (h *TransactionHandler) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	transactionID, ok := idParam(w, r, "transaction_id", h.logger)
+	if !ok {
+		return
+	}
+
+	reversal, err := h.service.ReverseTransfer(r.Context(), transactionID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, models.NewTransactionResponse(reversal))
+}
+
+// handle GET /accounts/{account_id}/transactions
+func (h *TransactionHandler) ListAccountTransactions(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := idParam(w, r, "account_id", h.logger)
+	if !ok {
+		return
+	}
+
+	limit, before, err := pageParams(r)
+	if err != nil {
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{
-			Error: "Invalid JSON",
-			Code:  "INVALID_JSON",
+			Error:   "Invalid query parameter",
+			Code:    "INVALID_QUERY_PARAMETER",
+			Details: err.Error(),
 		})
 		return
 	}
 
-	transaction, err := h.service.ExecuteTransfer(r.Context(), &req)
+	// fetch one extra row to know whether there is another page
+	transactions, err := h.service.ListTransactions(r.Context(), accountID, limit+1, before)
 	if err != nil {
 		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	response := models.TransactionResponse{
-		TransactionID:        transaction.TransactionID,
-		SourceAccountID:      transaction.SourceAccountID,
-		DestinationAccountID: transaction.DestinationAccountID,
-		Amount:               transaction.Amount.String(),
-		Status:               string(transaction.Status),
+	writeJSON(w, http.StatusOK, models.NewTransactionListResponse(transactions, limit))
+}
+
+// parse the limit and before query parameters of a listing
+func pageParams(r *http.Request) (limit int, before int64, err error) {
+	limit = defaultPageLimit
+	query := r.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	if v := query.Get("before"); v != "" {
+		before, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			return 0, 0, fmt.Errorf("before must be a positive transaction ID")
+		}
 	}
 
-	writeJSON(w, http.StatusCreated, response)
+	return limit, before, nil
 }
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var decimalType = reflect.TypeOf(decimal.Decimal{})
+
+// single failed validation rule on a request field
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// all failed validation rules for a request
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fmt.Sprintf("%s failed on %q", fe.Field, fe.Rule)
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+// validate a struct against its `validate` tags
+//
+// Supported rules are required, gt, gte, lt, lte, eqfield and nefield.
+// Numeric comparisons work on integers, floats and decimal.Decimal; on strings
+// they compare the length. For decimal.Decimal, required means the field was
+// present in the request, so an explicit "0" satisfies it.
+func validateStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get("validate")
+		if tag == "" || tag == "-" || !sf.IsExported() {
+			continue
+		}
+
+		for _, rule := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(rule, "=")
+			if !checkRule(rv, rv.Field(i), name, param) {
+				errs = append(errs, FieldError{
+					Field: jsonFieldName(sf),
+					Rule:  name,
+					Param: param,
+				})
+				// report only the first failed rule per field
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkRule(parent, field reflect.Value, name, param string) bool {
+	switch name {
+	case "required":
+		return !field.IsZero()
+	case "gt", "gte", "lt", "lte":
+		limit, err := decimal.NewFromString(param)
+		if err != nil {
+			panic(fmt.Sprintf("validate: invalid parameter %q for rule %q", param, name))
+		}
+		value, ok := numericValue(field)
+		if !ok {
+			panic(fmt.Sprintf("validate: rule %q not supported on %s", name, field.Type()))
+		}
+		switch name {
+		case "gt":
+			return value.GreaterThan(limit)
+		case "gte":
+			return value.GreaterThanOrEqual(limit)
+		case "lt":
+			return value.LessThan(limit)
+		default:
+			return value.LessThanOrEqual(limit)
+		}
+	case "eqfield", "nefield":
+		other := parent.FieldByName(param)
+		if !other.IsValid() {
+			panic(fmt.Sprintf("validate: unknown field %q in rule %q", param, name))
+		}
+		equal := fieldsEqual(field, other)
+		if name == "eqfield" {
+			return equal
+		}
+		return !equal
+	default:
+		panic(fmt.Sprintf("validate: unsupported rule %q", name))
+	}
+}
+
+// numeric representation of a field, using the length for strings
+func numericValue(v reflect.Value) (decimal.Decimal, bool) {
+	if v.Type() == decimalType {
+		return v.Interface().(decimal.Decimal), true
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decimal.NewFromInt(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return decimal.NewFromUint64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return decimal.NewFromFloat(v.Float()), true
+	case reflect.String, reflect.Slice, reflect.Map:
+		return decimal.NewFromInt(int64(v.Len())), true
+	}
+	return decimal.Decimal{}, false
+}
+
+func fieldsEqual(a, b reflect.Value) bool {
+	if a.Type() == b.Type() && a.Type() != decimalType && a.Type().Comparable() {
+		return a.Interface() == b.Interface()
+	}
+	x, okA := numericValue(a)
+	y, okB := numericValue(b)
+	return okA && okB && x.Equal(y)
+}
+
+// name of the field as it appears in the JSON request
+func jsonFieldName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"internal-transfers/internal/models"
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValidateStruct(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want ValidationErrors
+	}{
+		{
+			name: "valid transfer",
+			v: &models.CreateTransactionRequest{
+				SourceAccountID:      1,
+				DestinationAccountID: 2,
+				Amount:               decimal.RequireFromString("10.5"),
+			},
+		},
+		{
+			name: "missing fields",
+			v:    &models.CreateTransactionRequest{},
+			want: ValidationErrors{
+				{Field: "source_account_id", Rule: "required"},
+				{Field: "destination_account_id", Rule: "required"},
+				{Field: "amount", Rule: "required"},
+			},
+		},
+		{
+			name: "only the first failed rule per field",
+			v: &models.CreateTransactionRequest{
+				SourceAccountID:      -1,
+				DestinationAccountID: 2,
+				Amount:               decimal.RequireFromString("-5"),
+			},
+			want: ValidationErrors{
+				{Field: "source_account_id", Rule: "gt", Param: "0"},
+				{Field: "amount", Rule: "gt", Param: "0"},
+			},
+		},
+		{
+			name: "explicit zero satisfies required on decimals",
+			v: &models.CreateAccountRequest{
+				AccountID:      1,
+				InitialBalance: decimal.NewFromInt(0),
+			},
+		},
+		{
+			name: "absent decimal is required",
+			v:    &models.CreateAccountRequest{AccountID: 1},
+			want: ValidationErrors{{Field: "initial_balance", Rule: "required"}},
+		},
+		{
+			name: "upper bound",
+			v:    &models.SetShardsRequest{Shards: 257},
+			want: ValidationErrors{{Field: "shards", Rule: "lte", Param: "256"}},
+		},
+		{
+			name: "missing slice",
+			v:    &models.CreateAPIKeyRequest{Name: "ci"},
+			want: ValidationErrors{{Field: "scopes", Rule: "required"}},
+		},
+		{
+			name: "nil pointer",
+			v:    (*models.CreateTransactionRequest)(nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStruct(tt.v)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("validateStruct() = %v, want nil", err)
+				}
+				return
+			}
+			got, ok := err.(ValidationErrors)
+			if !ok {
+				t.Fatalf("validateStruct() = %v, want ValidationErrors", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateStruct() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStructRules(t *testing.T) {
+	type request struct {
+		Count    int             `json:"count" validate:"gte=1,lt=10"`
+		Ratio    float64         `json:"ratio" validate:"lte=1"`
+		Name     string          `json:"name" validate:"gt=2"`
+		Amount   decimal.Decimal `json:"amount" validate:"gte=0.01"`
+		Confirm  decimal.Decimal `json:"confirm" validate:"eqfield=Amount"`
+		Other    string          `json:"other" validate:"nefield=Name"`
+		Internal string          `validate:"required"`
+		ignored  string          `validate:"required"`
+	}
+	valid := request{
+		Count:    5,
+		Ratio:    0.5,
+		Name:     "abc",
+		Amount:   decimal.RequireFromString("1.00"),
+		Confirm:  decimal.RequireFromString("1"),
+		Other:    "xyz",
+		Internal: "set",
+	}
+
+	tiny := decimal.RequireFromString("0.001")
+
+	tests := []struct {
+		name   string
+		modify func(*request)
+		want   ValidationErrors
+	}{
+		{name: "valid", modify: func(*request) {}},
+		{name: "gte", modify: func(r *request) { r.Count = 0 }, want: ValidationErrors{{Field: "count", Rule: "gte", Param: "1"}}},
+		{name: "lt", modify: func(r *request) { r.Count = 10 }, want: ValidationErrors{{Field: "count", Rule: "lt", Param: "10"}}},
+		{name: "lte on floats", modify: func(r *request) { r.Ratio = 1.5 }, want: ValidationErrors{{Field: "ratio", Rule: "lte", Param: "1"}}},
+		{name: "gt on string length", modify: func(r *request) { r.Name, r.Other = "ab", "xy" }, want: ValidationErrors{{Field: "name", Rule: "gt", Param: "2"}}},
+		{name: "gte on decimals", modify: func(r *request) { r.Amount, r.Confirm = tiny, tiny }, want: ValidationErrors{{Field: "amount", Rule: "gte", Param: "0.01"}}},
+		{name: "eqfield", modify: func(r *request) { r.Confirm = decimal.NewFromInt(2) }, want: ValidationErrors{{Field: "confirm", Rule: "eqfield", Param: "Amount"}}},
+		{name: "nefield", modify: func(r *request) { r.Other = r.Name }, want: ValidationErrors{{Field: "other", Rule: "nefield", Param: "Name"}}},
+		{name: "field without json tag", modify: func(r *request) { r.Internal = "" }, want: ValidationErrors{{Field: "Internal", Rule: "required"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.modify(&r)
+			err := validateStruct(&r)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("validateStruct() = %v, want nil", err)
+				}
+				return
+			}
+			if got, _ := err.(ValidationErrors); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateStruct() = %v, want %+v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsJSON(t *testing.T) {
+	errs := ValidationErrors{{Field: "amount", Rule: "gt", Param: "0"}, {Field: "source_account_id", Rule: "required"}}
+
+	if got, want := errs.Error(), `validation failed: amount failed on "gt", source_account_id failed on "required"`; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	body, err := json.Marshal(errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), `[{"field":"amount","rule":"gt","param":"0"},{"field":"source_account_id","rule":"required"}]`; got != want {
+		t.Errorf("json = %s, want %s", got, want)
+	}
+}
+
+func TestValidateStructPanicsOnBadTags(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"unknown rule", &struct {
+			A int `validate:"email"`
+		}{}},
+		{"invalid parameter", &struct {
+			A int `validate:"gt=x"`
+		}{}},
+		{"unknown field", &struct {
+			A int `validate:"eqfield=B"`
+		}{}},
+		{"unsupported type", &struct {
+			A bool `validate:"gt=0"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("validateStruct() did not panic")
+				}
+			}()
+			validateStruct(tt.v)
+		})
+	}
+}
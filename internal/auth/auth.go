@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// API scopes
+const (
+	ScopeAccountsRead   = "accounts:read"
+	ScopeAccountsWrite  = "accounts:write"
+	ScopeTransfersWrite = "transfers:write"
+	ScopeAdmin          = "admin"
+)
+
+// all scopes that can be granted to a caller
+var Scopes = []string{
+	ScopeAccountsRead,
+	ScopeAccountsWrite,
+	ScopeTransfersWrite,
+	ScopeAdmin,
+}
+
+// roles
+const (
+	// may act on any account of its tenant, see Principal.IsAdminOf
+	RoleAdmin = "admin"
+)
+
+// principal types
+const (
+	PrincipalAPIKey     = "api_key"
+	PrincipalJWT        = "jwt"
+	PrincipalClientCert = "client_cert"
+)
+
+var (
+	// request carries no credentials this authenticator understands
+	ErrNoCredentials = errors.New("no credentials")
+	// request carries credentials that are not valid
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// authenticated caller of the API
+type Principal struct {
+	ID         string // api key identity or token subject
+	TenantID   string // empty for callers not assigned to a tenant
+	AllTenants bool   // acts in every tenant, only the bootstrap key
+	Type       string
+	Name       string
+	Scopes     []string
+	Roles      []string
+	Claims     map[string]interface{} // token claims, nil for api keys
+}
+
+// check whether the principal was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// check whether the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// check whether the principal is an admin of the tenant, admins bound to
+// another tenant are not
+func (p *Principal) IsAdminOf(tenantID string) bool {
+	return p.HasRole(RoleAdmin) && (p.AllTenants || p.TenantID == tenantID)
+}
+
+// resolves the caller of an HTTP request
+type Authenticator interface {
+	// return ErrNoCredentials if the request carries no credentials for this
+	// authenticator, so the next one can be tried
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type principalKey struct{}
+
+// attach the authenticated principal to the context
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// get the authenticated principal, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// check that every scope is known
+func ValidScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
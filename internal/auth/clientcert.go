@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+type clientCertKey struct{}
+
+// attach a verified client certificate to the context
+func WithClientCertificate(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertKey{}, cert)
+}
+
+// get the verified client certificate of the connection, if any
+func ClientCertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertKey{}).(*x509.Certificate)
+	return cert, ok && cert != nil
+}
+
+// authenticates callers presenting a client certificate verified against the
+// configured CA bundle, granting every such caller the same tenant and scopes
+type clientCertAuthenticator struct {
+	tenantID string
+	scopes   []string
+}
+
+func NewClientCertAuthenticator(tenantID string, scopes []string) Authenticator {
+	return &clientCertAuthenticator{tenantID: tenantID, scopes: scopes}
+}
+
+func (a *clientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	cert, ok := ClientCertificateFromContext(r.Context())
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	return &Principal{
+		ID:       "cert:" + cert.Subject.String(),
+		TenantID: a.tenantID,
+		Type:     PrincipalClientCert,
+		Name:     cert.Subject.CommonName,
+		Scopes:   a.scopes,
+	}, nil
+}
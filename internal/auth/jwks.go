@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minimum time between loads triggered by an unknown key ID, failed or not
+const jwksMinRefreshInterval = time.Minute
+
+// JSON Web Key Set loaded from a local file or an URL
+type KeySet struct {
+	load   func(ctx context.Context) ([]byte, error)
+	logger *slog.Logger
+
+	// reloads for unknown key IDs, one at a time
+	reloads singleflight.Group
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastAttempt time.Time
+}
+
+// load a key set from a local file
+func NewFileKeySet(path string, logger *slog.Logger) (*KeySet, error) {
+	ks := &KeySet{
+		load: func(context.Context) ([]byte, error) {
+			return os.ReadFile(path)
+		},
+		logger: logger,
+	}
+	if err := ks.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// load a key set from an URL and refresh it every interval until ctx is done
+func NewRemoteKeySet(ctx context.Context, url string, interval time.Duration, logger *slog.Logger) (*KeySet, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ks := &KeySet{
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+		logger: logger,
+	}
+	if err := ks.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := ks.Refresh(ctx); err != nil {
+						logger.Error("failed to refresh JWKS", slog.String("error", err.Error()))
+					}
+				}
+			}
+		}()
+	}
+
+	return ks, nil
+}
+
+// reload the key set from its source
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+
+	data, err := ks.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data, ks.logger)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	ks.logger.Info("JWKS loaded", slog.Int("keys", len(keys)))
+	return nil
+}
+
+// get the public key for a key ID, reloading the set if it is unknown so
+// rotated keys are picked up without waiting for the next refresh. Unknown
+// key IDs are free to send, so reloads are shared by concurrent callers and
+// happen at most once per jwksMinRefreshInterval, also when they fail.
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	_, err, shared := ks.reloads.Do("", func() (interface{}, error) {
+		ks.mu.RLock()
+		due := time.Since(ks.lastAttempt) >= jwksMinRefreshInterval
+		ks.mu.RUnlock()
+		if !due {
+			return nil, nil
+		}
+		// shared with other callers, so one going away must not cancel it
+		return nil, ks.Refresh(context.WithoutCancel(ctx))
+	})
+	if err != nil && !shared {
+		ks.logger.WarnContext(ctx, "failed to reload JWKS for unknown key ID", slog.String("error", err.Error()))
+	}
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+func (ks *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parse the signing keys of a key set. Keys that cannot be used, like those
+// of a type added by the provider later, are skipped so that the others
+// keep working.
+func parseJWKS(data []byte, logger *slog.Logger) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warn("skipping unusable JWK",
+				slog.String("kid", jwk.Kid),
+				slog.String("kty", jwk.Kty),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// JWK of a public key, encoded the way identity providers publish them
+func testJWK(t *testing.T, kid string, key any) map[string]string {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kid": kid, "kty": "EC", "crv": k.Curve.Params().Name, "x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes())}
+	case ed25519.PublicKey:
+		return map[string]string{"kid": kid, "kty": "OKP", "crv": "Ed25519", "x": b64(k)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+func testJWKS(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	encryption := testJWK(t, "enc", &rsaKey.PublicKey)
+	encryption["use"] = "enc"
+	keys, err := parseJWKS(testJWKS(t,
+		testJWK(t, "rsa", &rsaKey.PublicKey),
+		testJWK(t, "ec", &ecKey.PublicKey),
+		testJWK(t, "ed", edKey),
+		encryption,
+		map[string]string{"kid": "oct", "kty": "oct"},
+		map[string]string{"kid": "x25519", "kty": "OKP", "crv": "X25519", "x": "AA"},
+	), discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("got %d keys, want the 3 usable signing keys", len(keys))
+	}
+	if !rsaKey.PublicKey.Equal(keys["rsa"]) || !ecKey.PublicKey.Equal(keys["ec"]) || !edKey.Equal(keys["ed"]) {
+		t.Error("parsed keys differ from the published ones")
+	}
+
+	offCurve := testJWK(t, "ec", &ecKey.PublicKey)
+	offCurve["y"] = offCurve["x"]
+	for name, data := range map[string][]byte{
+		"not json":        []byte("{"),
+		"no signing keys": testJWKS(t, encryption),
+		"point off curve": testJWKS(t, offCurve),
+		"only unknown":    testJWKS(t, map[string]string{"kid": "x", "kty": "oct"}),
+	} {
+		if _, err := parseJWKS(data, discardLogger); err == nil {
+			t.Errorf("%s: parseJWKS() = nil, want an error", name)
+		}
+	}
+}
+
+// source of a key set counting its loads, which return its current data
+type countingSource struct {
+	loads atomic.Int32
+	mu    sync.Mutex
+	data  []byte
+	err   error
+	gate  chan struct{} // blocks loads until closed, when set
+}
+
+func (s *countingSource) load(context.Context) ([]byte, error) {
+	s.loads.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data, s.err
+}
+
+func (s *countingSource) set(data []byte, err error) {
+	s.mu.Lock()
+	s.data, s.err = data, err
+	s.mu.Unlock()
+}
+
+func newCountingKeySet(t *testing.T, data []byte) (*KeySet, *countingSource) {
+	t.Helper()
+	source := &countingSource{data: data}
+	ks := &KeySet{load: source.load, logger: discardLogger}
+	if err := ks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return ks, source
+}
+
+// pretend the last load was long enough ago for the next one
+func (ks *KeySet) expireAttempt() {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now().Add(-jwksMinRefreshInterval)
+	ks.mu.Unlock()
+}
+
+func TestKeySetReloadsForRotatedKeys(t *testing.T) {
+	old, _, _ := ed25519.GenerateKey(rand.Reader)
+	rotated, _, _ := ed25519.GenerateKey(rand.Reader)
+	ks, source := newCountingKeySet(t, testJWKS(t, testJWK(t, "old", old)))
+
+	if _, err := ks.Key(context.Background(), "old"); err != nil {
+		t.Fatal(err)
+	}
+	source.set(testJWKS(t, testJWK(t, "old", old), testJWK(t, "new", rotated)), nil)
+
+	// a key ID seen right after a load waits for the next interval
+	if _, err := ks.Key(context.Background(), "new"); err == nil {
+		t.Fatal("Key() found the rotated key without a reload")
+	}
+	if got := source.loads.Load(); got != 1 {
+		t.Fatalf("loads = %d, want 1", got)
+	}
+
+	ks.expireAttempt()
+	key, err := ks.Key(context.Background(), "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.Equal(key) {
+		t.Error("Key() returned another key than the rotated one")
+	}
+	if got := source.loads.Load(); got != 2 {
+		t.Errorf("loads = %d, want 2", got)
+	}
+}
+
+func TestKeySetThrottlesFailedReloads(t *testing.T) {
+	key, _, _ := ed25519.GenerateKey(rand.Reader)
+	ks, source := newCountingKeySet(t, testJWKS(t, testJWK(t, "a", key)))
+	source.set(nil, errors.New("identity provider down"))
+	ks.expireAttempt()
+
+	for range 10 {
+		if _, err := ks.Key(context.Background(), "random"); err == nil {
+			t.Fatal("Key() = nil error for an unknown key ID")
+		}
+	}
+	if got := source.loads.Load(); got != 2 {
+		t.Errorf("loads = %d, want the initial one and a single failed reload", got)
+	}
+
+	// the keys loaded before keep working
+	if _, err := ks.Key(context.Background(), "a"); err != nil {
+		t.Errorf("Key() for a known key = %v", err)
+	}
+}
+
+func TestKeySetSharesConcurrentReloads(t *testing.T) {
+	key, _, _ := ed25519.GenerateKey(rand.Reader)
+	ks, source := newCountingKeySet(t, testJWKS(t, testJWK(t, "a", key)))
+	source.gate = make(chan struct{})
+	ks.expireAttempt()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ks.Key(context.Background(), "random")
+		}()
+	}
+	// let the callers pile up behind the reload in flight
+	for source.loads.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(source.gate)
+	wg.Wait()
+
+	if got := source.loads.Load(); got != 2 {
+		t.Errorf("loads = %d, want the initial one and a single shared reload", got)
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"internal-transfers/internal/tenant"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signing algorithms accepted for bearer tokens
+var jwtAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// token validation settings
+type JWTOptions struct {
+	Issuer      string
+	Audience    string
+	ClockSkew   time.Duration
+	TenantClaim string // claim holding the caller's tenant
+}
+
+// authenticates requests carrying a JWT bearer token
+type jwtAuthenticator struct {
+	keys        *KeySet
+	parser      *jwt.Parser
+	tenantClaim string
+}
+
+func NewJWTAuthenticator(keys *KeySet, opts JWTOptions) Authenticator {
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtAlgorithms),
+		jwt.WithLeeway(opts.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	return &jwtAuthenticator{
+		keys:        keys,
+		parser:      jwt.NewParser(parserOpts...),
+		tenantClaim: opts.TenantClaim,
+	}
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(strings.TrimSpace(token), claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.Key(r.Context(), kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, errors.New("token has no subject"))
+	}
+
+	// subjects are only unique per issuer, and must not pass for the ID of
+	// an API key or certificate, which may own accounts as well
+	issuer, _ := claims.GetIssuer()
+	id := "jwt:" + issuer + ":" + subject
+
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = subject
+	}
+
+	// the tenant ends up in the row level security scope, a list of IDs
+	var tenantID string
+	if claim, ok := claims[a.tenantClaim]; ok && a.tenantClaim != "" {
+		tenantID, _ = claim.(string)
+		if !tenant.ValidID(tenantID) {
+			return nil, fmt.Errorf("%w: invalid %s claim", ErrInvalidCredentials, a.tenantClaim)
+		}
+	}
+
+	return &Principal{
+		ID:       id,
+		TenantID: tenantID,
+		Type:     PrincipalJWT,
+		Name:     name,
+		Scopes:   tokenScopes(claims),
+		Roles:    stringList(claims["roles"]),
+		Claims:   claims,
+	}, nil
+}
+
+// scopes from the "scope" claim or the "scp" claim
+func tokenScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	return stringList(claims["scp"])
+}
+
+// claim holding either a space separated string or an array of strings
+func stringList(claim interface{}) []string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://idp.example.com/"
+	testAudience = "internal-transfers"
+)
+
+// signing keys published in a JWKS file, and an authenticator trusting them
+type jwtFixture struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	ed   ed25519.PrivateKey
+	auth Authenticator
+}
+
+func newJWTFixture(t *testing.T) *jwtFixture {
+	t.Helper()
+	f := &jwtFixture{}
+	f.rsa, _ = rsa.GenerateKey(rand.Reader, 2048)
+	f.ec, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, f.ed, _ = ed25519.GenerateKey(rand.Reader)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := testJWKS(t,
+		testJWK(t, "rsa", &f.rsa.PublicKey),
+		testJWK(t, "ec", &f.ec.PublicKey),
+		testJWK(t, "ed", f.ed.Public()),
+	)
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewFileKeySet(path, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.auth = NewJWTAuthenticator(keys, JWTOptions{
+		Issuer:      testIssuer,
+		Audience:    testAudience,
+		ClockSkew:   30 * time.Second,
+		TenantClaim: "tenant_id",
+	})
+	return f
+}
+
+// claims of a valid token, which tests change to make it invalid
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":       testIssuer,
+		"aud":       testAudience,
+		"sub":       "user-1",
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Hour).Unix(),
+		"tenant_id": "acme",
+	}
+}
+
+func (f *jwtFixture) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	var key any
+	switch method {
+	case jwt.SigningMethodRS256, jwt.SigningMethodRS384:
+		key = f.rsa
+	case jwt.SigningMethodES256:
+		key = f.ec
+	case jwt.SigningMethodEdDSA:
+		key = f.ed
+	case jwt.SigningMethodHS256:
+		// the public key as HMAC secret, the classic algorithm confusion
+		key = []byte(testJWKS(t, testJWK(t, "rsa", &f.rsa.PublicKey)))
+	case jwt.SigningMethodNone:
+		key = jwt.UnsafeAllowNoneSignatureType
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (f *jwtFixture) authenticate(header string) (*Principal, error) {
+	r := httptest.NewRequest("GET", "/accounts/1", nil)
+	if header != "" {
+		r.Header.Set("Authorization", header)
+	}
+	return f.auth.Authenticate(r)
+}
+
+func TestJWTAuthenticatorAlgorithms(t *testing.T) {
+	f := newJWTFixture(t)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		valid  bool
+	}{
+		{"RS256", jwt.SigningMethodRS256, "rsa", true},
+		{"ES256", jwt.SigningMethodES256, "ec", true},
+		{"EdDSA", jwt.SigningMethodEdDSA, "ed", true},
+		{"RS384 is not accepted", jwt.SigningMethodRS384, "rsa", false},
+		{"HS256 with the public key", jwt.SigningMethodHS256, "rsa", false},
+		{"none", jwt.SigningMethodNone, "rsa", false},
+		{"key of another algorithm", jwt.SigningMethodES256, "rsa", false},
+		{"unknown key ID", jwt.SigningMethodEdDSA, "rotated", false},
+		{"no key ID", jwt.SigningMethodEdDSA, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.authenticate("Bearer " + f.sign(t, tt.method, tt.kid, validClaims()))
+			if tt.valid && err != nil {
+				t.Fatalf("Authenticate() = %v, want a principal", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate() = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorClaims(t *testing.T) {
+	f := newJWTFixture(t)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		valid  bool
+	}{
+		{"valid", func(jwt.MapClaims) {}, true},
+		{"expired within the clock skew", func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }, true},
+		{"expired beyond the clock skew", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, false},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"not yet valid within the clock skew", func(c jwt.MapClaims) { c["nbf"] = now.Add(10 * time.Second).Unix() }, true},
+		{"not yet valid beyond the clock skew", func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }, false},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = now.Add(time.Minute).Unix() }, false},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com/" }, false},
+		{"no issuer", func(c jwt.MapClaims) { delete(c, "iss") }, false},
+		{"audience among others", func(c jwt.MapClaims) { c["aud"] = []string{"other", testAudience} }, true},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other" }, false},
+		{"no audience", func(c jwt.MapClaims) { delete(c, "aud") }, false},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+		{"tenant claim of another type", func(c jwt.MapClaims) { c["tenant_id"] = 42 }, false},
+		{"tenant claim listing tenants", func(c jwt.MapClaims) { c["tenant_id"] = "acme,other" }, false},
+		{"empty tenant claim", func(c jwt.MapClaims) { c["tenant_id"] = "" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			_, err := f.authenticate("Bearer " + f.sign(t, jwt.SigningMethodEdDSA, "ed", claims))
+			if tt.valid && err != nil {
+				t.Fatalf("Authenticate() = %v, want a principal", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate() = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorPrincipal(t *testing.T) {
+	f := newJWTFixture(t)
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		want   Principal
+	}{
+		{
+			name: "scope claim",
+			modify: func(c jwt.MapClaims) {
+				c["name"] = "Ada"
+				c["scope"] = "accounts:read transfers:write"
+				c["roles"] = []string{"treasury"}
+			},
+			want: Principal{ID: "jwt:" + testIssuer + ":user-1", TenantID: "acme", Name: "Ada", Scopes: []string{"accounts:read", "transfers:write"}, Roles: []string{"treasury"}},
+		},
+		{
+			name:   "scp claim",
+			modify: func(c jwt.MapClaims) { c["scp"] = []string{"accounts:read"} },
+			want:   Principal{ID: "jwt:" + testIssuer + ":user-1", TenantID: "acme", Name: "user-1", Scopes: []string{"accounts:read"}},
+		},
+		{
+			name:   "no tenant claim",
+			modify: func(c jwt.MapClaims) { delete(c, "tenant_id") },
+			want:   Principal{ID: "jwt:" + testIssuer + ":user-1", Name: "user-1"},
+		},
+		{
+			name:   "subject of another principal type",
+			modify: func(c jwt.MapClaims) { c["sub"] = "apikey:1" },
+			want:   Principal{ID: "jwt:" + testIssuer + ":apikey:1", TenantID: "acme", Name: "apikey:1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			p, err := f.authenticate("Bearer " + f.sign(t, jwt.SigningMethodES256, "ec", claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.ID != tt.want.ID || p.TenantID != tt.want.TenantID || p.Name != tt.want.Name || p.Type != PrincipalJWT ||
+				!slices.Equal(p.Scopes, tt.want.Scopes) || !slices.Equal(p.Roles, tt.want.Roles) {
+				t.Errorf("principal = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorCredentials(t *testing.T) {
+	f := newJWTFixture(t)
+	token := f.sign(t, jwt.SigningMethodEdDSA, "ed", validClaims())
+
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"no header", "", ErrNoCredentials},
+		{"other scheme", "ApiKey abc", ErrNoCredentials},
+		{"scheme without token", "Bearer", ErrNoCredentials},
+		{"lower case scheme", "bearer " + token, nil},
+		{"malformed token", "Bearer not.a.token", ErrInvalidCredentials},
+		{"tampered signature", "Bearer " + token[:len(token)-4] + "AAAA", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.authenticate(tt.header)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("Authenticate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,93 +2,247 @@ package config
 
 import (
 	"fmt"
-	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"strings"
+	"time"
 )
 
 // configuration for the application
+//
+// Every setting has a `config` key, used in config files as a nested path
+// (database.host) and as the flag name (-database.host), and an `env`
+// variable. Settings tagged `secret` are redacted when printed.
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Log      LogConfig
+	Server    ServerConfig    `config:"server"`
+	Storage   StorageConfig   `config:"storage"`
+	Database  DatabaseConfig  `config:"database"`
+	Log       LogConfig       `config:"log"`
+	Auth      AuthConfig      `config:"auth"`
+	TLS       TLSConfig       `config:"tls"`
+	Tenancy   TenancyConfig   `config:"tenancy"`
+	Transfers TransfersConfig `config:"transfers"`
+	RateLimit RateLimitConfig `config:"rate_limit"`
+	Tracing   TracingConfig   `config:"tracing"`
+	Health    HealthConfig    `config:"health"`
 }
 
 // HTTP server configuration
 type ServerConfig struct {
-	Port string
-	Host string
+	Port         string        `config:"port" env:"SERVER_PORT"`
+	Host         string        `config:"host" env:"SERVER_HOST"`
+	MaxBodyBytes int64         `config:"max_body_bytes" env:"SERVER_MAX_BODY_BYTES"`
+	DrainDelay   time.Duration `config:"drain_delay" env:"SERVER_DRAIN_DELAY"` // time between failing readiness and closing listeners on SIGTERM
+}
+
+// Where accounts and transfers are kept
+type StorageConfig struct {
+	Backend string `config:"backend" env:"STORAGE_BACKEND"` // "postgres" or "memory", which loses all data on exit
 }
 
 // Database connection configuration
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
-	MaxConns int32
-	MinConns int32
+	Host     string `config:"host" env:"DB_HOST"`
+	Port     int    `config:"port" env:"DB_PORT"`
+	User     string `config:"user" env:"DB_USER"`
+	Password string `config:"password" env:"DB_PASSWORD" secret:"true"`
+	DBName   string `config:"name" env:"DB_NAME"`
+	SSLMode  string `config:"ssl_mode" env:"DB_SSL_MODE"`
+	MaxConns int32  `config:"max_conns" env:"DB_MAX_CONNS"`
+	MinConns int32  `config:"min_conns" env:"DB_MIN_CONNS"`
+
+	// default isolation of transactions: "read_committed", "repeatable_read"
+	// or "serializable"
+	Isolation string `config:"isolation" env:"DB_ISOLATION"`
 }
 
 // Logging configuration
 type LogConfig struct {
-	Level string
+	Level string `config:"level" env:"LOG_LEVEL"`
 }
 
-// load configuration from environment variables
-func Load() (*Config, error) {
-	if err := godotenv.Load(); err != nil {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
-	}
-	cfg := &Config{
+// Authentication configuration
+type AuthConfig struct {
+	Enabled  bool      `config:"enabled" env:"AUTH_ENABLED"`
+	AdminKey string    `config:"admin_key" env:"AUTH_ADMIN_KEY" secret:"true"` // bootstrap key with the admin scope, used to issue api keys
+	JWT      JWTConfig `config:"jwt"`
+}
+
+// JWT bearer token configuration, enabled when a JWKS file or URL is set
+type JWTConfig struct {
+	JWKSFile    string        `config:"jwks_file" env:"AUTH_JWT_JWKS_FILE"`
+	JWKSURL     string        `config:"jwks_url" env:"AUTH_JWT_JWKS_URL"`
+	JWKSRefresh time.Duration `config:"jwks_refresh" env:"AUTH_JWT_JWKS_REFRESH"`
+	Issuer      string        `config:"issuer" env:"AUTH_JWT_ISSUER"`
+	Audience    string        `config:"audience" env:"AUTH_JWT_AUDIENCE"`
+	ClockSkew   time.Duration `config:"clock_skew" env:"AUTH_JWT_CLOCK_SKEW"`
+	TenantClaim string        `config:"tenant_claim" env:"AUTH_JWT_TENANT_CLAIM"`
+}
+
+func (c *JWTConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// HTTPS configuration, enabled when a certificate and key are set
+type TLSConfig struct {
+	CertFile         string        `config:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile          string        `config:"key_file" env:"TLS_KEY_FILE"`
+	MinVersion       string        `config:"min_version" env:"TLS_MIN_VERSION"`
+	CipherPolicy     string        `config:"cipher_policy" env:"TLS_CIPHER_POLICY"`
+	ClientCAFile     string        `config:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	ClientAuth       string        `config:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientCertTenant string        `config:"client_cert_tenant" env:"TLS_CLIENT_CERT_TENANT"` // tenant of callers with a verified client certificate
+	ClientCertScopes []string      `config:"client_cert_scopes" env:"TLS_CLIENT_CERT_SCOPES"` // scopes granted to verified client certificates
+	ReloadInterval   time.Duration `config:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+}
+
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Multi-tenancy configuration
+type TenancyConfig struct {
+	// "source->destination" tenant pairs allowed to transfer across tenants
+	CrossTenantTransfers []string `config:"cross_tenant_transfers" env:"TENANCY_CROSS_TENANT_TRANSFERS"`
+}
+
+// Transfer execution configuration
+type TransfersConfig struct {
+	// how concurrent transfers of the same accounts are kept apart:
+	// "locking", "optimistic" or "serializable"
+	Concurrency string `config:"concurrency" env:"TRANSFERS_CONCURRENCY"`
+
+	// run transfers of plain accounts as a single statement, one round trip
+	// to the database, before falling back to the concurrency mode above
+	SingleStatement bool `config:"single_statement" env:"TRANSFERS_SINGLE_STATEMENT"`
+
+	// attempts of a transfer the database aborted for a conflict with
+	// concurrent ones, including the first
+	RetryAttempts  int           `config:"retry_attempts" env:"TRANSFERS_RETRY_ATTEMPTS"`
+	RetryBaseDelay time.Duration `config:"retry_base_delay" env:"TRANSFERS_RETRY_BASE_DELAY"` // backoff before the first retry, doubled for each further one
+	RetryMaxDelay  time.Duration `config:"retry_max_delay" env:"TRANSFERS_RETRY_MAX_DELAY"`
+
+	// collect transfers arriving within the window and commit them together,
+	// each in a savepoint of its own, 0 commits every transfer on its own
+	BatchWindow  time.Duration `config:"batch_window" env:"TRANSFERS_BATCH_WINDOW"`
+	BatchMaxSize int           `config:"batch_max_size" env:"TRANSFERS_BATCH_MAX_SIZE"` // a full batch is committed without waiting for the window
+	BatchTimeout time.Duration `config:"batch_timeout" env:"TRANSFERS_BATCH_TIMEOUT"`   // a batch still running then is rolled back
+
+	// transfers queued with POST /transactions?async=true this instance
+	// executes at once, 0 leaves them to other instances
+	AsyncWorkers      int           `config:"async_workers" env:"TRANSFERS_ASYNC_WORKERS"`
+	AsyncPollInterval time.Duration `config:"async_poll_interval" env:"TRANSFERS_ASYNC_POLL_INTERVAL"` // wait before looking again when the queue is empty
+	// how long a claimed transfer is hidden from other workers, it runs
+	// again afterwards if its worker died, so keep it well above the
+	// duration of a transfer
+	AsyncLease       time.Duration `config:"async_lease" env:"TRANSFERS_ASYNC_LEASE"`
+	AsyncMaxAttempts int           `config:"async_max_attempts" env:"TRANSFERS_ASYNC_MAX_ATTEMPTS"`
+}
+
+// Rate limiting configuration for POST /transactions, a rate of 0 disables the limit
+type RateLimitConfig struct {
+	Backend      string  `config:"backend" env:"RATE_LIMIT_BACKEND"` // "memory" or "postgres"
+	ClientRate   float64 `config:"client_rate" env:"RATE_LIMIT_CLIENT_RATE"`
+	ClientBurst  int     `config:"client_burst" env:"RATE_LIMIT_CLIENT_BURST"`
+	AccountRate  float64 `config:"account_rate" env:"RATE_LIMIT_ACCOUNT_RATE"`
+	AccountBurst int     `config:"account_burst" env:"RATE_LIMIT_ACCOUNT_BURST"`
+}
+
+// OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Exporter     string  `config:"exporter" env:"TRACING_EXPORTER"` // "none", "otlp" or "file"
+	File         string  `config:"file" env:"TRACING_FILE"`
+	OTLPEndpoint string  `config:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool    `config:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+	ServiceName  string  `config:"service_name" env:"TRACING_SERVICE_NAME"`
+	SampleRatio  float64 `config:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// Readiness check configuration
+type HealthConfig struct {
+	CheckTimeout   time.Duration `config:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	PoolSaturation float64       `config:"pool_saturation" env:"HEALTH_POOL_SATURATION"` // share of connections in use at which the pool counts as saturated
+}
+
+// configuration used when nothing else is set
+func Default() *Config {
+	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:         "8080",
+			Host:         "0.0.0.0",
+			MaxBodyBytes: 1 << 20,
+			DrainDelay:   5 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend: "postgres",
 		},
 		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvAsInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "postgres"),
-			DBName:   getEnv("DB_NAME", "transfers"),
-			SSLMode:  getEnv("DB_SSL_MODE", "disable"),
-			MaxConns: int32(getEnvAsInt("DB_MAX_CONNS", 25)),
-			MinConns: int32(getEnvAsInt("DB_MIN_CONNS", 5)),
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "postgres",
+			DBName:   "transfers",
+			SSLMode:  "disable",
+			MaxConns: 25,
+			MinConns: 5,
+
+			Isolation: "read_committed",
 		},
 		Log: LogConfig{
-			Level: getEnv("LOG_LEVEL", "info"),
+			Level: "info",
 		},
-	}
+		Auth: AuthConfig{
+			JWT: JWTConfig{
+				JWKSRefresh: 15 * time.Minute,
+				ClockSkew:   30 * time.Second,
+				TenantClaim: "tenant_id",
+			},
+		},
+		TLS: TLSConfig{
+			MinVersion:       "1.2",
+			CipherPolicy:     "intermediate",
+			ClientAuth:       "none",
+			ClientCertTenant: "default",
+			ReloadInterval:   30 * time.Second,
+		},
+		Transfers: TransfersConfig{
+			Concurrency:    "locking",
+			RetryAttempts:  3,
+			RetryBaseDelay: 10 * time.Millisecond,
+			RetryMaxDelay:  200 * time.Millisecond,
+			BatchMaxSize:   100,
+			BatchTimeout:   5 * time.Second,
 
-	return cfg, nil
+			AsyncWorkers:      0,
+			AsyncPollInterval: 200 * time.Millisecond,
+			AsyncLease:        30 * time.Second,
+			AsyncMaxAttempts:  5,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:      "memory",
+			ClientBurst:  20,
+			AccountBurst: 10,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			ServiceName: "internal-transfers",
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{
+			CheckTimeout:   2 * time.Second,
+			PoolSaturation: 1,
+		},
+	}
 }
 
 // PostgreSQL connection string
 func (c *DatabaseConfig) ConnectionString() string {
 	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode,
+		quote(c.Host), c.Port, quote(c.User), quote(c.Password), quote(c.DBName), quote(c.SSLMode),
 	)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
-	}
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
-	}
-	return value
+// quote a connection string value, secrets read from files may contain spaces or quotes
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
 }
@@ -0,0 +1,303 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// environment variable naming the config file when -config is not given
+const fileEnv = "CONFIG_FILE"
+
+// suffix of environment variables naming a file that holds the value
+const secretFileSuffix = "_FILE"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// loads the configuration from defaults, a YAML or JSON file, environment
+// variables and command line flags, each layer overriding the previous one
+type Loader struct {
+	fs    *flag.FlagSet
+	file  *string
+	flags map[string]*flagValue
+}
+
+// register the -config flag and one flag per setting on fs, Load must be
+// called after fs has been parsed
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		fs:    fs,
+		file:  fs.String("config", "", "YAML or JSON config file (env "+fileEnv+")"),
+		flags: make(map[string]*flagValue),
+	}
+	for _, f := range fields(Default()) {
+		fv := &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		l.flags[f.key] = fv
+		fs.Var(fv, f.key, "env "+f.env)
+	}
+	return l
+}
+
+// build the configuration, reporting every invalid setting at once
+func (l *Loader) Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	cfg := Default()
+	byKey := make(map[string]field)
+	for _, f := range fields(cfg) {
+		byKey[f.key] = f
+	}
+
+	var errs []error
+
+	file := *l.file
+	if file == "" {
+		file = os.Getenv(fileEnv)
+	}
+	if file != "" {
+		errs = append(errs, loadFile(file, byKey)...)
+	}
+
+	for _, f := range fields(cfg) {
+		if err := loadEnv(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	l.fs.Visit(func(fl *flag.Flag) {
+		fv, ok := l.flags[fl.Name]
+		if !ok {
+			return
+		}
+		if err := byKey[fl.Name].set(fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", fl.Name, err))
+		}
+	})
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, &Errors{Errs: errs}
+	}
+	return cfg, nil
+}
+
+// all problems found while loading the configuration
+type Errors struct {
+	Errs []error
+}
+
+func (e *Errors) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid settings: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+func (e *Errors) Unwrap() []error {
+	return e.Errs
+}
+
+// apply a YAML or JSON config file
+func loadFile(path string, byKey map[string]field) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return []error{fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .json", path)}
+	}
+	if err != nil {
+		return []error{fmt.Errorf("config file %s: %w", path, err)}
+	}
+
+	var errs []error
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for _, name := range slices.Sorted(maps.Keys(m)) {
+			key, value := prefix+name, m[name]
+			if section, ok := value.(map[string]any); ok {
+				walk(key+".", section)
+				continue
+			}
+			f, ok := byKey[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, key))
+				continue
+			}
+			if err := f.setValue(value); err != nil {
+				errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, key, err))
+			}
+		}
+	}
+	walk("", doc)
+	return errs
+}
+
+// apply the environment variable of a setting, or the file named by its
+// _FILE variable
+func loadEnv(f field) error {
+	value := os.Getenv(f.env)
+	path := os.Getenv(f.env + secretFileSuffix)
+	switch {
+	case value != "" && path != "":
+		return fmt.Errorf("env %s: both %s and %s%s are set", f.env, f.env, f.env, secretFileSuffix)
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("env %s%s: %w", f.env, secretFileSuffix, err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+		if err := f.set(value); err != nil {
+			return fmt.Errorf("env %s%s: %w", f.env, secretFileSuffix, err)
+		}
+		return nil
+	case value != "":
+		if err := f.set(value); err != nil {
+			return fmt.Errorf("env %s: %w", f.env, err)
+		}
+	}
+	return nil
+}
+
+// a single setting of the Config struct
+type field struct {
+	key    string // dotted path, e.g. database.host
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// all settings of cfg in declaration order
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			key := sf.Tag.Get("config")
+			if key == "" {
+				continue
+			}
+			if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+				walk(prefix+key+".", v.Field(i))
+				continue
+			}
+			out = append(out, field{
+				key:    prefix + key,
+				env:    sf.Tag.Get("env"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return out
+}
+
+// set the setting from its string form, lists are comma separated
+func (f field) set(s string) error {
+	v := f.value
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %s", v.Type()))
+	}
+	return nil
+}
+
+// set the setting from a decoded YAML or JSON value
+func (f field) setValue(value any) error {
+	switch value := value.(type) {
+	case nil:
+		return errors.New("null is not a valid value")
+	case []any:
+		if f.value.Kind() != reflect.Slice {
+			return errors.New("unexpected list")
+		}
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		f.value.Set(reflect.ValueOf(items))
+		return nil
+	case float64:
+		return f.set(strconv.FormatFloat(value, 'f', -1, 64))
+	default:
+		return f.set(fmt.Sprint(value))
+	}
+}
+
+// flag recording whether and to what it was set
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string { return v.value }
+
+func (v *flagValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
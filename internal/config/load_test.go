@@ -0,0 +1,378 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unset every variable the loader reads, so the tests do not depend on the
+// environment they run in
+func clearEnv(t *testing.T) {
+	t.Helper()
+	names := []string{fileEnv}
+	for _, f := range fields(Default()) {
+		names = append(names, f.env, f.env+secretFileSuffix)
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load the configuration with the given environment and command line
+func load(t *testing.T, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	clearEnv(t)
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	loader := NewLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return loader.Load()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load() without settings = %+v, want the defaults", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  port: \"9000\"\nlog:\n  level: debug\n")
+	other := writeFile(t, "other.yaml", "server:\n  port: \"9500\"\n")
+	secret := writeFile(t, "password", "s3cret pass\n")
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(*Config) (got, want any)
+	}{
+		{
+			name:  "default",
+			check: func(c *Config) (any, any) { return c.Server.Port, "8080" },
+		},
+		{
+			name:  "file over default",
+			args:  []string{"-config", file},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9000" },
+		},
+		{
+			name:  "file named by the environment",
+			env:   map[string]string{"CONFIG_FILE": file},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9000" },
+		},
+		{
+			name:  "-config over CONFIG_FILE",
+			env:   map[string]string{"CONFIG_FILE": other},
+			args:  []string{"-config", file},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9000" },
+		},
+		{
+			name:  "env over file",
+			env:   map[string]string{"SERVER_PORT": "9100"},
+			args:  []string{"-config", file},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9100" },
+		},
+		{
+			name:  "settings the env leaves alone keep the file value",
+			env:   map[string]string{"SERVER_PORT": "9100"},
+			args:  []string{"-config", file},
+			check: func(c *Config) (any, any) { return c.Log.Level, "debug" },
+		},
+		{
+			name:  "flag over env",
+			env:   map[string]string{"SERVER_PORT": "9100"},
+			args:  []string{"-config", file, "-server.port", "9200"},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9200" },
+		},
+		{
+			name:  "flag set to the default over env",
+			env:   map[string]string{"SERVER_PORT": "9100"},
+			args:  []string{"-server.port=8080"},
+			check: func(c *Config) (any, any) { return c.Server.Port, "8080" },
+		},
+		{
+			name:  "empty env leaves the setting alone",
+			env:   map[string]string{"SERVER_PORT": ""},
+			args:  []string{"-config", file},
+			check: func(c *Config) (any, any) { return c.Server.Port, "9000" },
+		},
+		{
+			name:  "_FILE indirection without the trailing newline",
+			env:   map[string]string{"DB_PASSWORD_FILE": secret},
+			check: func(c *Config) (any, any) { return c.Database.Password, "s3cret pass" },
+		},
+		{
+			name:  "flag over _FILE",
+			env:   map[string]string{"DB_PASSWORD_FILE": secret},
+			args:  []string{"-database.password", "from-flag"},
+			check: func(c *Config) (any, any) { return c.Database.Password, "from-flag" },
+		},
+		{
+			name:  "boolean flag without value",
+			args:  []string{"-auth.enabled"},
+			check: func(c *Config) (any, any) { return c.Auth.Enabled, true },
+		},
+		{
+			name:  "duration",
+			env:   map[string]string{"TRANSFERS_BATCH_WINDOW": "2ms"},
+			check: func(c *Config) (any, any) { return c.Transfers.BatchWindow, 2 * time.Millisecond },
+		},
+		{
+			name: "comma separated list",
+			env:  map[string]string{"TLS_CLIENT_CERT_SCOPES": "accounts:read, transfers:write,"},
+			check: func(c *Config) (any, any) {
+				return c.TLS.ClientCertScopes, []string{"accounts:read", "transfers:write"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tt.check(cfg); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		check   func(*Config) (got, want any)
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "database:\n  port: 6543\n  max_conns: 10\ntls:\n  client_cert_scopes: [accounts:read, admin]\ntracing:\n  sample_ratio: 0.25\n",
+			check: func(c *Config) (any, any) {
+				return []any{c.Database.Port, c.Database.MaxConns, c.TLS.ClientCertScopes, c.Tracing.SampleRatio},
+					[]any{6543, int32(10), []string{"accounts:read", "admin"}, 0.25}
+			},
+		},
+		{
+			name:    "yml",
+			file:    "config.yml",
+			content: "transfers:\n  retry_max_delay: 1s\n  single_statement: true\n",
+			check: func(c *Config) (any, any) {
+				return []any{c.Transfers.RetryMaxDelay, c.Transfers.SingleStatement}, []any{time.Second, true}
+			},
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"rate_limit": {"client_rate": 12.5, "client_burst": 30}, "tenancy": {"cross_tenant_transfers": ["a->b"]}}`,
+			check: func(c *Config) (any, any) {
+				return []any{c.RateLimit.ClientRate, c.RateLimit.ClientBurst, c.Tenancy.CrossTenantTransfers},
+					[]any{12.5, 30, []string{"a->b"}}
+			},
+		},
+		{
+			name:    "unknown setting",
+			file:    "config.yaml",
+			content: "server:\n  prot: \"9000\"\n",
+			wantErr: `unknown setting "server.prot"`,
+		},
+		{
+			name:    "wrong type",
+			file:    "config.yaml",
+			content: "database:\n  port: many\n",
+			wantErr: `database.port: invalid integer "many"`,
+		},
+		{
+			name:    "null",
+			file:    "config.yaml",
+			content: "log:\n  level: null\n",
+			wantErr: "log.level: null is not a valid value",
+		},
+		{
+			name:    "list for a single value",
+			file:    "config.yaml",
+			content: "log:\n  level: [debug]\n",
+			wantErr: "log.level: unexpected list",
+		},
+		{
+			name:    "unsupported format",
+			file:    "config.toml",
+			content: "",
+			wantErr: "unsupported format",
+		},
+		{
+			name:    "malformed",
+			file:    "config.json",
+			content: "{",
+			wantErr: "config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, nil, "-config", writeFile(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tt.check(cfg); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := load(t, nil, "-config", filepath.Join(t.TempDir(), "missing.yaml"))
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load() = %v, want os.ErrNotExist", err)
+		}
+	})
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	secret := writeFile(t, "port", "9000")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"invalid integer", map[string]string{"DB_PORT": "five"}, `env DB_PORT: invalid integer "five"`},
+		{"integer out of range", map[string]string{"DB_MAX_CONNS": "3000000000"}, `env DB_MAX_CONNS: invalid integer`},
+		{"invalid boolean", map[string]string{"AUTH_ENABLED": "yes please"}, `env AUTH_ENABLED: invalid boolean`},
+		{"invalid duration", map[string]string{"SERVER_DRAIN_DELAY": "5"}, `env SERVER_DRAIN_DELAY: invalid duration "5"`},
+		{"invalid number", map[string]string{"TRACING_SAMPLE_RATIO": "half"}, `env TRACING_SAMPLE_RATIO: invalid number`},
+		{"value and _FILE", map[string]string{"SERVER_PORT": "9000", "SERVER_PORT_FILE": secret}, "both SERVER_PORT and SERVER_PORT_FILE are set"},
+		{"missing _FILE", map[string]string{"DB_PASSWORD_FILE": "/nonexistent/password"}, "env DB_PASSWORD_FILE:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.env)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  prot: \"1\"\n")
+	_, err := load(t,
+		map[string]string{"DB_PORT": "five", "LOG_LEVEL": "loud"},
+		"-config", file, "-transfers.retry_attempts", "many", "-storage.backend", "disk",
+	)
+
+	var errs *Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Load() = %v, want *Errors", err)
+	}
+	want := []string{
+		`unknown setting "server.prot"`,
+		"env DB_PORT",
+		"flag -transfers.retry_attempts",
+		"storage.backend: must be one of",
+		"log.level: must be one of",
+	}
+	if len(errs.Errs) != len(want) {
+		t.Errorf("got %d errors, want %d: %v", len(errs.Errs), len(want), err)
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("errors do not mention %q: %v", w, err)
+		}
+	}
+}
+
+// every setting must be reachable through its environment variable and flag,
+// the reflection over the struct tags breaks silently otherwise
+func TestEverySettingIsLoadable(t *testing.T) {
+	keys := make(map[string]bool)
+	envs := make(map[string]bool)
+	for _, f := range fields(Default()) {
+		if f.env == "" {
+			t.Errorf("%s has no env tag", f.key)
+		}
+		if keys[f.key] || envs[f.env] {
+			t.Errorf("%s (%s) is declared twice", f.key, f.env)
+		}
+		keys[f.key], envs[f.env] = true, true
+	}
+
+	// a value of each setting's type that differs from its default
+	sample := func(f field) string {
+		v := f.value
+		if v.Type() == durationType {
+			return (time.Duration(v.Int()) + time.Second).String()
+		}
+		switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				return "false"
+			}
+			return "true"
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+			return "7"
+		case reflect.Slice:
+			return "x,y"
+		default:
+			return v.String() + "x"
+		}
+	}
+
+	defaults := fields(Default())
+	cfg := Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader := NewLoader(fs)
+	for i, f := range fields(cfg) {
+		clearEnv(t)
+		value := sample(f)
+		t.Setenv(f.env, value)
+		if err := loadEnv(f); err != nil {
+			t.Errorf("env %s: %v", f.env, err)
+		}
+		if reflect.DeepEqual(f.value.Interface(), defaults[i].value.Interface()) {
+			t.Errorf("env %s=%s left %s at its default", f.env, value, f.key)
+		}
+
+		if err := fs.Set(f.key, value); err != nil {
+			t.Errorf("flag -%s: %v", f.key, err)
+		} else if got := loader.flags[f.key].value; got != value {
+			t.Errorf("flag -%s = %q, want %q", f.key, got, value)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholder for secret settings in printed configurations
+const redacted = "REDACTED"
+
+// encode the configuration as YAML or JSON in the config file layout, so the
+// output can be used as a config file. Secrets are replaced when redact is set.
+func (c *Config) Marshal(format string, redact bool) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range fields(c) {
+		parent := root
+		path := strings.Split(f.key, ".")
+		for _, name := range path[:len(path)-1] {
+			parent = section(parent, name)
+		}
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: path[len(path)-1]},
+			valueNode(f, redact),
+		)
+	}
+
+	switch format {
+	case "yaml":
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(root); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		var out bytes.Buffer
+		if err := json.Indent(&out, nodeJSON(root), "", "  "); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use yaml or json", format)
+	}
+}
+
+// child mapping called name of parent, created on first use
+func section(parent *yaml.Node, name string) *yaml.Node {
+	for i := 0; i < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == name {
+			return parent.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+	return child
+}
+
+func valueNode(f field, redact bool) *yaml.Node {
+	v := f.value
+	str := func(s string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s, Style: yaml.DoubleQuotedStyle}
+	}
+
+	if f.secret && redact && !v.IsZero() {
+		return str(redacted)
+	}
+	if v.Type() == durationType {
+		return str(time.Duration(v.Int()).String())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v.Bool())}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v.Int(), 10)}
+	case reflect.Float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v.Float(), 'f', -1, 64)}
+	case reflect.Slice:
+		list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := 0; i < v.Len(); i++ {
+			list.Content = append(list.Content, str(v.Index(i).String()))
+		}
+		return list
+	default:
+		return str(v.String())
+	}
+}
+
+// JSON for a node tree built by Marshal, keeping the key order
+func nodeJSON(n *yaml.Node) []byte {
+	var buf bytes.Buffer
+	switch n.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(jsonString(n.Content[i].Value))
+			buf.WriteByte(':')
+			buf.Write(nodeJSON(n.Content[i+1]))
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(nodeJSON(item))
+		}
+		buf.WriteByte(']')
+	default:
+		if n.Tag == "!!str" {
+			buf.Write(jsonString(n.Value))
+		} else {
+			buf.WriteString(n.Value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// JSON string literal without HTML escaping, so "a->b" stays readable
+func jsonString(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshalRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "hunter2"
+	cfg.Auth.AdminKey = ""
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := cfg.Marshal(format, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(out), "hunter2") {
+				t.Errorf("redacted output contains the database password:\n%s", out)
+			}
+			if got := strings.Count(string(out), redacted); got != 1 {
+				t.Errorf("output has %d redacted settings, want only the set secret:\n%s", got, out)
+			}
+
+			out, err = cfg.Marshal(format, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(out), "hunter2") || strings.Contains(string(out), redacted) {
+				t.Errorf("unredacted output hides the database password:\n%s", out)
+			}
+		})
+	}
+
+	if _, err := cfg.Marshal("toml", true); err == nil {
+		t.Error("Marshal(toml) = nil error")
+	}
+}
+
+// a printed configuration loads back into the same configuration
+func TestMarshalRoundTrip(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "hunter2"
+	cfg.TLS.ClientCertScopes = []string{"accounts:read", "admin"}
+	cfg.Tracing.SampleRatio = 0.25
+	cfg.Transfers.SingleStatement = true
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := cfg.Marshal(format, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := load(t, nil, "-config", writeFile(t, "config."+format, string(out)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reprinted, err := loaded.Marshal(format, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(reprinted) != string(out) {
+				t.Errorf("loaded configuration prints as\n%s\nwant\n%s", reprinted, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"internal-transfers/internal/tenant"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// check settings that parse but cannot work, returning every problem found
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			fail(key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("server.port", "must be a port number, got %q", c.Server.Port)
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("server.max_body_bytes", "must be positive, got %d", c.Server.MaxBodyBytes)
+	}
+	if c.Server.DrainDelay < 0 {
+		fail("server.drain_delay", "must not be negative, got %s", c.Server.DrainDelay)
+	}
+
+	oneOf("storage.backend", c.Storage.Backend, "postgres", "memory")
+
+	if c.Database.Host == "" {
+		fail("database.host", "must be set")
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		fail("database.port", "must be a port number, got %d", c.Database.Port)
+	}
+	if c.Database.DBName == "" {
+		fail("database.name", "must be set")
+	}
+	oneOf("database.ssl_mode", c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	if c.Database.MaxConns < 1 {
+		fail("database.max_conns", "must be at least 1, got %d", c.Database.MaxConns)
+	}
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.MaxConns {
+		fail("database.min_conns", "must be between 0 and max_conns (%d), got %d", c.Database.MaxConns, c.Database.MinConns)
+	}
+	oneOf("database.isolation", c.Database.Isolation, "read_committed", "repeatable_read", "serializable")
+
+	oneOf("log.level", strings.ToLower(c.Log.Level), "debug", "info", "warn", "error")
+
+	if c.Auth.JWT.JWKSFile != "" && c.Auth.JWT.JWKSURL != "" {
+		fail("auth.jwt.jwks_url", "cannot be combined with auth.jwt.jwks_file")
+	}
+	if c.Auth.JWT.JWKSRefresh <= 0 {
+		fail("auth.jwt.jwks_refresh", "must be positive, got %s", c.Auth.JWT.JWKSRefresh)
+	}
+	if c.Auth.JWT.ClockSkew < 0 {
+		fail("auth.jwt.clock_skew", "must not be negative, got %s", c.Auth.JWT.ClockSkew)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file", "must be set together with tls.key_file")
+	}
+	oneOf("tls.min_version", c.TLS.MinVersion, "1.2", "1.3")
+	oneOf("tls.cipher_policy", c.TLS.CipherPolicy, "default", "intermediate", "modern")
+	oneOf("tls.client_auth", c.TLS.ClientAuth, "none", "request", "verify_if_given", "require")
+	if (c.TLS.ClientAuth == "verify_if_given" || c.TLS.ClientAuth == "require") && c.TLS.ClientCAFile == "" {
+		fail("tls.client_ca_file", "must be set when tls.client_auth is %q", c.TLS.ClientAuth)
+	}
+	if c.TLS.ClientAuth != "none" && !c.TLS.Enabled() {
+		fail("tls.client_auth", "requires tls.cert_file and tls.key_file")
+	}
+	if !tenant.ValidID(c.TLS.ClientCertTenant) {
+		fail("tls.client_cert_tenant", "must be a tenant ID, got %q", c.TLS.ClientCertTenant)
+	}
+	if c.TLS.ReloadInterval <= 0 {
+		fail("tls.reload_interval", "must be positive, got %s", c.TLS.ReloadInterval)
+	}
+
+	oneOf("transfers.concurrency", c.Transfers.Concurrency, "locking", "optimistic", "serializable")
+	if c.Transfers.RetryAttempts < 1 {
+		fail("transfers.retry_attempts", "must be at least 1, got %d", c.Transfers.RetryAttempts)
+	}
+	if c.Transfers.RetryBaseDelay <= 0 {
+		fail("transfers.retry_base_delay", "must be positive, got %s", c.Transfers.RetryBaseDelay)
+	}
+	if c.Transfers.RetryMaxDelay < c.Transfers.RetryBaseDelay {
+		fail("transfers.retry_max_delay", "must be at least retry_base_delay (%s), got %s", c.Transfers.RetryBaseDelay, c.Transfers.RetryMaxDelay)
+	}
+	if c.Transfers.BatchWindow < 0 {
+		fail("transfers.batch_window", "must not be negative, got %s", c.Transfers.BatchWindow)
+	}
+	if c.Transfers.BatchWindow > 0 && c.Transfers.SingleStatement {
+		fail("transfers.batch_window", "cannot be combined with transfers.single_statement")
+	}
+	if c.Transfers.BatchMaxSize < 1 {
+		fail("transfers.batch_max_size", "must be at least 1, got %d", c.Transfers.BatchMaxSize)
+	}
+	if c.Transfers.BatchTimeout <= 0 {
+		fail("transfers.batch_timeout", "must be positive, got %s", c.Transfers.BatchTimeout)
+	}
+	if c.Transfers.AsyncWorkers < 0 {
+		fail("transfers.async_workers", "must not be negative, got %d", c.Transfers.AsyncWorkers)
+	}
+	if c.Transfers.AsyncPollInterval <= 0 {
+		fail("transfers.async_poll_interval", "must be positive, got %s", c.Transfers.AsyncPollInterval)
+	}
+	if c.Transfers.AsyncLease <= 0 {
+		fail("transfers.async_lease", "must be positive, got %s", c.Transfers.AsyncLease)
+	}
+	if c.Transfers.AsyncMaxAttempts < 1 {
+		fail("transfers.async_max_attempts", "must be at least 1, got %d", c.Transfers.AsyncMaxAttempts)
+	}
+
+	oneOf("rate_limit.backend", c.RateLimit.Backend, "memory", "postgres")
+	if c.RateLimit.Backend == "postgres" && c.Storage.Backend == "memory" {
+		fail("rate_limit.backend", "postgres needs storage.backend postgres")
+	}
+	if c.RateLimit.ClientRate < 0 {
+		fail("rate_limit.client_rate", "must not be negative, got %g", c.RateLimit.ClientRate)
+	}
+	if c.RateLimit.ClientRate > 0 && c.RateLimit.ClientBurst < 1 {
+		fail("rate_limit.client_burst", "must be at least 1, got %d", c.RateLimit.ClientBurst)
+	}
+	if c.RateLimit.AccountRate < 0 {
+		fail("rate_limit.account_rate", "must not be negative, got %g", c.RateLimit.AccountRate)
+	}
+	if c.RateLimit.AccountRate > 0 && c.RateLimit.AccountBurst < 1 {
+		fail("rate_limit.account_burst", "must be at least 1, got %d", c.RateLimit.AccountBurst)
+	}
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "file")
+	if c.Tracing.Exporter == "file" && c.Tracing.File == "" {
+		fail("tracing.file", "must be set for the file exporter")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	if c.Health.CheckTimeout <= 0 {
+		fail("health.check_timeout", "must be positive, got %s", c.Health.CheckTimeout)
+	}
+	if c.Health.PoolSaturation <= 0 || c.Health.PoolSaturation > 1 {
+		fail("health.pool_saturation", "must be greater than 0 and at most 1, got %g", c.Health.PoolSaturation)
+	}
+
+	return errs
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // key of the setting reported, empty when valid
+	}{
+		{"defaults", func(*Config) {}, ""},
+		{"port not a number", func(c *Config) { c.Server.Port = "http" }, "server.port"},
+		{"port zero", func(c *Config) { c.Server.Port = "0" }, "server.port"},
+		{"port too large", func(c *Config) { c.Server.Port = "65536" }, "server.port"},
+		{"body limit zero", func(c *Config) { c.Server.MaxBodyBytes = 0 }, "server.max_body_bytes"},
+		{"negative drain delay", func(c *Config) { c.Server.DrainDelay = -time.Second }, "server.drain_delay"},
+		{"unknown storage", func(c *Config) { c.Storage.Backend = "disk" }, "storage.backend"},
+		{"memory storage", func(c *Config) { c.Storage.Backend = "memory" }, ""},
+		{"no database host", func(c *Config) { c.Database.Host = "" }, "database.host"},
+		{"database port zero", func(c *Config) { c.Database.Port = 0 }, "database.port"},
+		{"no database name", func(c *Config) { c.Database.DBName = "" }, "database.name"},
+		{"unknown ssl mode", func(c *Config) { c.Database.SSLMode = "on" }, "database.ssl_mode"},
+		{"no connections", func(c *Config) { c.Database.MaxConns, c.Database.MinConns = 0, 0 }, "database.max_conns"},
+		{"negative min connections", func(c *Config) { c.Database.MinConns = -1 }, "database.min_conns"},
+		{"min above max connections", func(c *Config) { c.Database.MaxConns, c.Database.MinConns = 2, 3 }, "database.min_conns"},
+		{"unknown isolation", func(c *Config) { c.Database.Isolation = "snapshot" }, "database.isolation"},
+		{"unknown log level", func(c *Config) { c.Log.Level = "trace" }, "log.level"},
+		{"upper case log level", func(c *Config) { c.Log.Level = "DEBUG" }, ""},
+		{"JWKS file and URL", func(c *Config) { c.Auth.JWT.JWKSFile, c.Auth.JWT.JWKSURL = "jwks.json", "https://idp/jwks" }, "auth.jwt.jwks_url"},
+		{"JWKS refresh zero", func(c *Config) { c.Auth.JWT.JWKSRefresh = 0 }, "auth.jwt.jwks_refresh"},
+		{"negative clock skew", func(c *Config) { c.Auth.JWT.ClockSkew = -time.Second }, "auth.jwt.clock_skew"},
+		{"certificate without key", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "tls.cert_file"},
+		{"key without certificate", func(c *Config) { c.TLS.KeyFile = "key.pem" }, "tls.cert_file"},
+		{"unknown TLS version", func(c *Config) { c.TLS.MinVersion = "1.1" }, "tls.min_version"},
+		{"unknown cipher policy", func(c *Config) { c.TLS.CipherPolicy = "legacy" }, "tls.cipher_policy"},
+		{"unknown client auth", func(c *Config) {
+			c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientAuth = "cert.pem", "key.pem", "always"
+		}, "tls.client_auth"},
+		{"client auth without TLS", func(c *Config) { c.TLS.ClientAuth = "request" }, "tls.client_auth"},
+		{"verified client auth without CA", func(c *Config) {
+			c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientAuth = "cert.pem", "key.pem", "require"
+		}, "tls.client_ca_file"},
+		{"verified client auth", func(c *Config) {
+			c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientAuth, c.TLS.ClientCAFile = "cert.pem", "key.pem", "require", "ca.pem"
+		}, ""},
+		{"invalid client certificate tenant", func(c *Config) { c.TLS.ClientCertTenant = "acme,other" }, "tls.client_cert_tenant"},
+		{"TLS reload interval zero", func(c *Config) { c.TLS.ReloadInterval = 0 }, "tls.reload_interval"},
+		{"unknown concurrency", func(c *Config) { c.Transfers.Concurrency = "mvcc" }, "transfers.concurrency"},
+		{"no attempts", func(c *Config) { c.Transfers.RetryAttempts = 0 }, "transfers.retry_attempts"},
+		{"retry base delay zero", func(c *Config) { c.Transfers.RetryBaseDelay = 0 }, "transfers.retry_base_delay"},
+		{"retry max below base delay", func(c *Config) {
+			c.Transfers.RetryBaseDelay, c.Transfers.RetryMaxDelay = time.Second, time.Millisecond
+		}, "transfers.retry_max_delay"},
+		{"negative batch window", func(c *Config) { c.Transfers.BatchWindow = -time.Millisecond }, "transfers.batch_window"},
+		{"batch window with single statement", func(c *Config) {
+			c.Transfers.BatchWindow, c.Transfers.SingleStatement = time.Millisecond, true
+		}, "transfers.batch_window"},
+		{"batch size zero", func(c *Config) { c.Transfers.BatchMaxSize = 0 }, "transfers.batch_max_size"},
+		{"batch timeout zero", func(c *Config) { c.Transfers.BatchTimeout = 0 }, "transfers.batch_timeout"},
+		{"negative async workers", func(c *Config) { c.Transfers.AsyncWorkers = -1 }, "transfers.async_workers"},
+		{"async poll interval zero", func(c *Config) { c.Transfers.AsyncPollInterval = 0 }, "transfers.async_poll_interval"},
+		{"async lease zero", func(c *Config) { c.Transfers.AsyncLease = 0 }, "transfers.async_lease"},
+		{"no async attempts", func(c *Config) { c.Transfers.AsyncMaxAttempts = 0 }, "transfers.async_max_attempts"},
+		{"unknown rate limit backend", func(c *Config) { c.RateLimit.Backend = "redis" }, "rate_limit.backend"},
+		{"postgres rate limit with memory storage", func(c *Config) {
+			c.RateLimit.Backend, c.Storage.Backend = "postgres", "memory"
+		}, "rate_limit.backend"},
+		{"negative client rate", func(c *Config) { c.RateLimit.ClientRate = -1 }, "rate_limit.client_rate"},
+		{"client rate without burst", func(c *Config) { c.RateLimit.ClientRate, c.RateLimit.ClientBurst = 1, 0 }, "rate_limit.client_burst"},
+		{"negative account rate", func(c *Config) { c.RateLimit.AccountRate = -1 }, "rate_limit.account_rate"},
+		{"account rate without burst", func(c *Config) { c.RateLimit.AccountRate, c.RateLimit.AccountBurst = 1, 0 }, "rate_limit.account_burst"},
+		{"unknown tracing exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		{"file exporter without file", func(c *Config) { c.Tracing.Exporter, c.Tracing.File = "file", "" }, "tracing.file"},
+		{"sample ratio above 1", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio"},
+		{"negative sample ratio", func(c *Config) { c.Tracing.SampleRatio = -0.1 }, "tracing.sample_ratio"},
+		{"health timeout zero", func(c *Config) { c.Health.CheckTimeout = 0 }, "health.check_timeout"},
+		{"pool saturation zero", func(c *Config) { c.Health.PoolSaturation = 0 }, "health.pool_saturation"},
+		{"pool saturation above 1", func(c *Config) { c.Health.PoolSaturation = 1.1 }, "health.pool_saturation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			errs := cfg.validate()
+			if tt.want == "" {
+				if len(errs) > 0 {
+					t.Fatalf("validate() = %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), tt.want+": ") {
+				t.Fatalf("validate() = %v, want a single error for %s", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = "0"
+	cfg.Database.Host = ""
+	cfg.Tracing.SampleRatio = 2
+	if errs := cfg.validate(); len(errs) != 3 {
+		t.Errorf("validate() = %v, want 3 errors", errs)
+	}
+}
@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// database is reachable
+func DatabaseCheck(db *pgxpool.Pool) Check {
+	return func(ctx context.Context) error {
+		return db.Ping(ctx)
+	}
+}
+
+// share of pool connections in use stays below maxRatio
+func PoolSaturationCheck(db *pgxpool.Pool, maxRatio float64) Check {
+	return func(context.Context) error {
+		stat := db.Stat()
+		ratio := float64(stat.AcquiredConns()) / float64(stat.MaxConns())
+		if ratio >= maxRatio {
+			return fmt.Errorf("%d of %d connections in use", stat.AcquiredConns(), stat.MaxConns())
+		}
+		return nil
+	}
+}
+
+// schema_migrations is clean and at least at the given version
+func MigrationCheck(db *pgxpool.Pool, version uint) Check {
+	return func(ctx context.Context) error {
+		var current uint
+		var dirty bool
+		err := db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("no migrations applied, need version %d", version)
+		}
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("migration %d failed and left the schema dirty", current)
+		}
+		if current < version {
+			return fmt.Errorf("schema at version %d, need %d", current, version)
+		}
+		return nil
+	}
+}
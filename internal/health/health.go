@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// single readiness check, nil means healthy
+type Check func(ctx context.Context) error
+
+// outcome of one check
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// outcome of all readiness checks
+type Report struct {
+	Ready  bool                   `json:"-"`
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// runs the readiness checks and tracks whether the server is draining
+type Checker struct {
+	timeout  time.Duration
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	c := &Checker{timeout: timeout}
+	c.Add("draining", func(context.Context) error {
+		if c.draining.Load() {
+			return errors.New("server is shutting down")
+		}
+		return nil
+	})
+	return c
+}
+
+// register a readiness check, not safe to call while serving
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// mark the server as draining so it reports not ready
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// run all checks concurrently, each bounded by the check timeout
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := nc.check(ctx)
+			results[i] = CheckResult{
+				Status:     StatusOK,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = StatusFail
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, Status: "ready", Checks: make(map[string]CheckResult, len(c.checks))}
+	for i, nc := range c.checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Ready = false
+			report.Status = "not_ready"
+		}
+	}
+	return report
+}
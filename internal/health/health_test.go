@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pool of a database nobody listens for, connecting on first use
+func unreachablePool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	pool, err := pgxpool.New(context.Background(), "postgres://transfers@127.0.0.1:1/transfers?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestCheckerReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, c *Checker)
+		failing  []string
+		notReady bool
+	}{
+		{
+			name:  "all checks pass",
+			setup: func(t *testing.T, c *Checker) { c.Add("ok", func(context.Context) error { return nil }) },
+		},
+		{
+			name: "database ping fails",
+			setup: func(t *testing.T, c *Checker) {
+				c.Add("ok", func(context.Context) error { return nil })
+				c.Add("database", DatabaseCheck(unreachablePool(t)))
+			},
+			failing:  []string{"database"},
+			notReady: true,
+		},
+		{
+			name:     "draining",
+			setup:    func(t *testing.T, c *Checker) { c.SetDraining() },
+			failing:  []string{"draining"},
+			notReady: true,
+		},
+		{
+			name: "check outlasting the timeout",
+			setup: func(t *testing.T, c *Checker) {
+				c.Add("slow", func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			},
+			failing:  []string{"slow"},
+			notReady: true,
+		},
+		{
+			name: "several failures",
+			setup: func(t *testing.T, c *Checker) {
+				c.SetDraining()
+				c.Add("broken", func(context.Context) error { return errors.New("broken") })
+			},
+			failing:  []string{"draining", "broken"},
+			notReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(200 * time.Millisecond)
+			tt.setup(t, c)
+
+			report := c.Ready(context.Background())
+			if report.Ready == tt.notReady {
+				t.Errorf("Ready = %v, want %v", report.Ready, !tt.notReady)
+			}
+			wantStatus := "ready"
+			if tt.notReady {
+				wantStatus = "not_ready"
+			}
+			if report.Status != wantStatus {
+				t.Errorf("Status = %q, want %q", report.Status, wantStatus)
+			}
+
+			failed := 0
+			for name, result := range report.Checks {
+				if result.Status == StatusFail {
+					failed++
+					if result.Error == "" {
+						t.Errorf("check %s failed without an error", name)
+					}
+				}
+			}
+			for _, name := range tt.failing {
+				if report.Checks[name].Status != StatusFail {
+					t.Errorf("check %s = %+v, want it failed", name, report.Checks[name])
+				}
+			}
+			if failed != len(tt.failing) {
+				t.Errorf("%d checks failed, want %d: %+v", failed, len(tt.failing), report.Checks)
+			}
+		})
+	}
+}
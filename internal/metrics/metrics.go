@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "transfers"
+
+// registry exposed on /metrics
+var registry = prometheus.NewRegistry()
+
+// values of the kind label of TransferOutcomes
+const (
+	KindTransfer = "transfer"
+	KindReversal = "reversal"
+)
+
+// values of the outcome label of TransferOutcomes, every transfer is counted
+// once with one of them
+const (
+	OutcomeCompleted    = "completed"            // the amount was moved
+	OutcomeInsufficient = "insufficient_balance" // recorded as failed, the source could not cover it
+	OutcomeRejected     = "rejected"             // invalid request, unknown account or transfer, not reversible
+	OutcomeDenied       = "denied"               // the caller may not debit the source or reach the destination tenant
+	OutcomeConflict     = "conflict"             // gave up on conflicts with concurrent transfers
+	OutcomeError        = "error"                // failed for the database or another unexpected error
+)
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	TransferOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_outcomes_total",
+		Help:      "Transfers and reversals by kind and outcome, queued transfers once a worker executed them.",
+	}, []string{"kind", "outcome"})
+
+	TransferAmount = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transfer_amount",
+		Help:      "Amounts of completed transfers.",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 10),
+	})
+
+	TransferConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_conflicts_total",
+		Help:      "Transfer attempts aborted for a conflict with concurrent transfers, by reason and whether they were retried, exhausted the attempts or ran out of time.",
+	}, []string{"reason", "action"})
+
+	TransferBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transfer_batch_size",
+		Help:      "Transfers committed together by the group-commit batcher.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	TransferBatchAbandoned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_batch_abandoned_total",
+		Help:      "Batched transfers whose caller stopped waiting before their batch finished.",
+	})
+
+	LockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "account_lock_wait_seconds",
+		Help:      "Time spent acquiring account row locks.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		TransferOutcomes,
+		TransferAmount,
+		TransferConflicts,
+		TransferBatchSize,
+		TransferBatchAbandoned,
+		LockWait,
+	)
+}
+
+// register additional collectors, e.g. the connection pool
+func MustRegister(cs ...prometheus.Collector) {
+	registry.MustRegister(cs...)
+}
+
+// handler serving the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector reading pgxpool statistics at scrape time
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired         *prometheus.Desc
+	idle             *prometheus.Desc
+	constructing     *prometheus.Desc
+	total            *prometheus.Desc
+	max              *prometheus.Desc
+	acquires         *prometheus.Desc
+	waits            *prometheus.Desc
+	acquireDuration  *prometheus.Desc
+	canceledAcquires *prometheus.Desc
+}
+
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:             pool,
+		acquired:         desc("acquired_conns", "Connections currently in use."),
+		idle:             desc("idle_conns", "Idle connections."),
+		constructing:     desc("constructing_conns", "Connections being established."),
+		total:            desc("total_conns", "Open connections."),
+		max:              desc("max_conns", "Maximum pool size."),
+		acquires:         desc("acquires_total", "Successful connection acquires."),
+		waits:            desc("waits_total", "Acquires that had to wait for a connection."),
+		acquireDuration:  desc("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+		canceledAcquires: desc("canceled_acquires_total", "Acquires canceled by their context."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.constructing
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.waits
+	ch <- c.acquireDuration
+	ch <- c.canceledAcquires
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructing, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.waits, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
@@ -0,0 +1,335 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// key of the advisory lock held while migrating, so concurrent pods apply
+// each migration once
+const lockKey int64 = 0x7472616e73666572 // "transfer"
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+var ErrDirty = errors.New("schema is dirty")
+
+// single schema version
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// state of the database schema
+type Status struct {
+	Version uint // 0 when no migration was applied
+	Dirty   bool
+	Latest  uint
+	Pending []Migration
+}
+
+// applies migrations and records the version in schema_migrations, using
+// the same table layout as golang-migrate so existing databases carry over
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration // ordered by version
+	logger     *slog.Logger
+}
+
+func New(db *pgxpool.Pool, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// read NNN_name.up.sql and NNN_name.down.sql files from fsys
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		m := fileName.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		version, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[uint(version)]
+		if !ok {
+			mig = &Migration{Version: uint(version), Name: m[2]}
+			byVersion[uint(version)] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(sql)
+		} else {
+			mig.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return int(a.Version) - int(b.Version) })
+	return migrations, nil
+}
+
+// highest known version
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// current version and the migrations not applied yet
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	defer conn.Release()
+
+	version, dirty, err := readVersion(ctx, conn.Conn())
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Version: version, Dirty: dirty, Latest: m.Latest()}
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			status.Pending = append(status.Pending, mig)
+		}
+	}
+	return status, nil
+}
+
+// apply all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// revert the given number of applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *pgx.Conn, current uint) error {
+		target := current
+		for i := 0; i < steps && target > 0; i++ {
+			target = m.previous(target)
+		}
+		return m.migrate(ctx, conn, current, target)
+	})
+}
+
+// migrate up or down to exactly the given version, 0 reverts everything
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.withLock(ctx, func(conn *pgx.Conn, current uint) error {
+		return m.migrate(ctx, conn, current, version)
+	})
+}
+
+// record the version without running migrations and clear the dirty flag,
+// for recovering after a failed migration was fixed by hand
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if err := lock(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	defer unlock(conn.Conn())
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := writeVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	m.logger.Warn("forced schema version", slog.Uint64("version", uint64(version)))
+	return tx.Commit(ctx)
+}
+
+// run fn on a dedicated connection holding the migration lock, with the
+// current version read after the lock was taken
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgx.Conn, current uint) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if err := lock(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	defer unlock(conn.Conn())
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	current, dirty, err := readVersion(ctx, conn.Conn())
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d, fix it by hand and run migrate force", ErrDirty, current)
+	}
+	return fn(conn.Conn(), current)
+}
+
+// step from current to target, one transaction per migration
+func (m *Migrator) migrate(ctx context.Context, conn *pgx.Conn, current, target uint) error {
+	if current == target {
+		m.logger.Info("schema is up to date", slog.Uint64("version", uint64(current)))
+		return nil
+	}
+	if current != 0 && m.index(current) < 0 {
+		return fmt.Errorf("database is at version %d, which this binary does not know", current)
+	}
+
+	for current < target {
+		next := m.migrations[m.index(current)+1]
+		if err := m.apply(ctx, conn, next, next.Up, next.Version, "up"); err != nil {
+			return err
+		}
+		current = next.Version
+	}
+	for current > target {
+		mig := m.migrations[m.index(current)]
+		if mig.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+		previous := m.previous(current)
+		if err := m.apply(ctx, conn, mig, mig.Down, previous, "down"); err != nil {
+			return err
+		}
+		current = previous
+	}
+	return nil
+}
+
+// run the SQL and record the resulting version atomically
+func (m *Migrator) apply(ctx context.Context, conn *pgx.Conn, mig Migration, sql string, version uint, direction string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	if err := writeVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	m.logger.Info("applied migration",
+		slog.Uint64("version", uint64(mig.Version)),
+		slog.String("name", mig.Name),
+		slog.String("direction", direction),
+	)
+	return nil
+}
+
+// position of version in m.migrations, -1 for 0 and unknown versions
+func (m *Migrator) index(version uint) int {
+	return slices.IndexFunc(m.migrations, func(mig Migration) bool { return mig.Version == version })
+}
+
+// version before the given one, 0 for the first
+func (m *Migrator) previous(version uint) uint {
+	if i := m.index(version); i > 0 {
+		return m.migrations[i-1].Version
+	}
+	return 0
+}
+
+func lock(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	return nil
+}
+
+// released with a fresh context so a canceled migration does not leave the
+// lock held on a pooled connection
+func unlock(conn *pgx.Conn) {
+	conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+}
+
+func ensureTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`)
+	return err
+}
+
+// recorded version, 0 before the first migration
+func readVersion(ctx context.Context, conn *pgx.Conn) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == "42P01") { // undefined_table
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(version), dirty, nil
+}
+
+func writeVersion(ctx context.Context, tx pgx.Tx, version uint) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)`, int64(version))
+	return err
+}
@@ -7,8 +7,12 @@ import (
 )
 
 type Account struct {
+	TenantID  string          `json:"tenant_id"`
 	AccountID int64           `json:"account_id"`
 	Balance   decimal.Decimal `json:"balance"`
+	OwnerID   *string         `json:"owner_id,omitempty"`
+	Shards    int             `json:"shards,omitempty"` // sub-balance rows of a hot account, 0 when not sharded
+	Version   int64           `json:"-"`                // incremented by every balance update
 	CreatedAt time.Time       `json:"created_at"`
 	UpdatedAt time.Time       `json:"updated_at"`
 }
@@ -16,9 +20,61 @@ type Account struct {
 type CreateAccountRequest struct {
 	AccountID      int64           `json:"account_id" validate:"required,gt=0"`
 	InitialBalance decimal.Decimal `json:"initial_balance" validate:"required,gte=0"`
+	OwnerID        string          `json:"owner_id,omitempty"` // defaults to the caller, only admins may set another owner
 }
 
 type AccountResponse struct {
+	TenantID  string  `json:"tenant_id"`
+	AccountID int64   `json:"account_id"`
+	Balance   string  `json:"balance"` // String to avoid JSON float precision issues, summed over the shards of a hot account
+	OwnerID   *string `json:"owner_id,omitempty"`
+	Shards    int     `json:"shards,omitempty"`
+}
+
+// most shards a hot account can be split into
+const MaxShards = 256
+
+// split the balance of an account across shards, 0 merges them back
+type SetShardsRequest struct {
+	Shards int `json:"shards" validate:"gte=0,lte=256"`
+}
+
+// sub-balance of a hot account
+type AccountShard struct {
+	Shard   int
+	Balance decimal.Decimal
+}
+
+type AddDelegateRequest struct {
+	PrincipalID string `json:"principal_id" validate:"required"`
+}
+
+func NewAccountResponse(a *Account) AccountResponse {
+	return AccountResponse{
+		TenantID:  a.TenantID,
+		AccountID: a.AccountID,
+		Balance:   a.Balance.String(),
+		OwnerID:   a.OwnerID,
+		Shards:    a.Shards,
+	}
+}
+
+// balance of an account compared with the one implied by its transfers
+type ReconciliationEntry struct {
+	AccountID int64
+	Balance   decimal.Decimal
+	Expected  decimal.Decimal // initial balance plus completed credits minus completed debits
+}
+
+type ReconciliationMismatch struct {
 	AccountID int64  `json:"account_id"`
-	Balance   string `json:"balance"` // String to avoid JSON float precision issues
+	Balance   string `json:"balance"`
+	Expected  string `json:"expected"`
+}
+
+type ReconciliationReport struct {
+	TenantID        string                   `json:"tenant_id"`
+	AccountsChecked int                      `json:"accounts_checked"`
+	TotalBalance    string                   `json:"total_balance"`
+	Mismatches      []ReconciliationMismatch `json:"mismatches"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type APIKey struct {
+	ID        int64      `json:"id"`
+	TenantID  string     `json:"tenant_id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	KeyHash   []byte     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name     string   `json:"name" validate:"required"`
+	Scopes   []string `json:"scopes" validate:"required"`
+	TenantID string   `json:"tenant_id,omitempty"` // defaults to the caller's tenant
+}
+
+type APIKeyResponse struct {
+	ID        int64     `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"` // only returned when the key is issued
+}
@@ -8,11 +8,30 @@ var (
 	ErrAccountExists    = errors.New("account already exists")
 	ErrNegativeBalance  = errors.New("balance cannot be negative")
 	ErrInvalidAccountID = errors.New("invalid account ID")
+	ErrAccessDenied     = errors.New("access to account denied")
+	ErrDelegateNotFound = errors.New("delegate not found")
+	ErrInvalidShards    = errors.New("invalid shard count")
 
 	// Transaction errors
 	ErrInsufficientBalance = errors.New("insufficient balance")
 	ErrSelfTransfer        = errors.New("cannot transfer to same account")
 	ErrInvalidAmount       = errors.New("invalid transaction amount")
 	ErrAccountsNotFound    = errors.New("one or both accounts not found")
+	ErrCrossTenantTransfer = errors.New("cross-tenant transfer not allowed")
 	ErrTransactionFailed   = errors.New("transaction failed")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrAlreadyReversed     = errors.New("transaction already reversed")
+	ErrNotReversible       = errors.New("transaction cannot be reversed")
+	ErrTransferConflict    = errors.New("transfer conflicted with concurrent transfers")
+
+	// Authentication errors
+	ErrUnauthenticated   = errors.New("authentication required")
+	ErrInsufficientScope = errors.New("insufficient scope")
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidScope      = errors.New("invalid scope")
+	ErrInvalidTenantID   = errors.New("invalid tenant ID")
+	ErrNoTenant          = errors.New("caller is not bound to a tenant")
+
+	// Rate limiting errors
+	ErrRateLimited = errors.New("rate limit exceeded")
 )
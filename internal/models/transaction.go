@@ -16,24 +16,81 @@ const (
 
 type Transaction struct {
 	TransactionID        int64             `json:"transaction_id"`
+	TenantID             string            `json:"tenant_id"`
 	SourceAccountID      int64             `json:"source_account_id"`
+	DestinationTenantID  string            `json:"destination_tenant_id"`
 	DestinationAccountID int64             `json:"destination_account_id"`
 	Amount               decimal.Decimal   `json:"amount"`
 	Status               TransactionStatus `json:"status"`
 	CreatedAt            time.Time         `json:"created_at"`
 	ErrorMessage         *string           `json:"error_message,omitempty"`
+	InitiatedBy          *string           `json:"initiated_by,omitempty"`
+	RequestID            *string           `json:"request_id,omitempty"`
+	ReversalOf           *int64            `json:"reversal_of,omitempty"`
 }
 
 type CreateTransactionRequest struct {
 	SourceAccountID      int64           `json:"source_account_id" validate:"required,gt=0"`
-	DestinationAccountID int64           `json:"destination_account_id" validate:"required,gt=0,nefield=SourceAccountID"`
+	DestinationAccountID int64           `json:"destination_account_id" validate:"required,gt=0"`
+	DestinationTenantID  string          `json:"destination_tenant_id,omitempty"` // defaults to the caller's tenant
 	Amount               decimal.Decimal `json:"amount" validate:"required,gt=0"`
 }
 
 type TransactionResponse struct {
-	TransactionID        int64  `json:"transaction_id"`
-	SourceAccountID      int64  `json:"source_account_id"`
-	DestinationAccountID int64  `json:"destination_account_id"`
-	Amount               string `json:"amount"` // String to avoid JSON float precision issues
-	Status               string `json:"status"`
+	TransactionID        int64     `json:"transaction_id"`
+	TenantID             string    `json:"tenant_id"`
+	SourceAccountID      int64     `json:"source_account_id"`
+	DestinationTenantID  string    `json:"destination_tenant_id"`
+	DestinationAccountID int64     `json:"destination_account_id"`
+	Amount               string    `json:"amount"` // String to avoid JSON float precision issues
+	Status               string    `json:"status"`
+	ErrorMessage         *string   `json:"error_message,omitempty"`
+	ReversalOf           *int64    `json:"reversal_of,omitempty"` // the transfer this one reverses
+	CreatedAt            time.Time `json:"created_at"`
+	StatusURL            string    `json:"status_url,omitempty"` // where to poll a queued transfer
+}
+
+// a pending transfer claimed from the queue by an async worker
+type QueuedTransfer struct {
+	TransactionID  int64
+	TenantID       string
+	PrincipalRoles []string // roles of the caller that queued it
+	Attempts       int      // claims so far, including this one
+}
+
+// page of an account's transfers, newest first
+type TransactionListResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+	NextBefore   *int64                `json:"next_before,omitempty"` // pass as before to get the next page
+}
+
+// build a page from up to limit+1 transactions, the extra one only signals
+// that there is a next page
+func NewTransactionListResponse(transactions []Transaction, limit int) TransactionListResponse {
+	page := TransactionListResponse{
+		Transactions: make([]TransactionResponse, 0, min(len(transactions), limit)),
+	}
+	for i := range transactions[:min(len(transactions), limit)] {
+		page.Transactions = append(page.Transactions, NewTransactionResponse(&transactions[i]))
+	}
+	if len(transactions) > limit {
+		next := transactions[limit-1].TransactionID
+		page.NextBefore = &next
+	}
+	return page
+}
+
+func NewTransactionResponse(t *Transaction) TransactionResponse {
+	return TransactionResponse{
+		TransactionID:        t.TransactionID,
+		TenantID:             t.TenantID,
+		SourceAccountID:      t.SourceAccountID,
+		DestinationTenantID:  t.DestinationTenantID,
+		DestinationAccountID: t.DestinationAccountID,
+		Amount:               t.Amount.String(),
+		Status:               string(t.Status),
+		ErrorMessage:         t.ErrorMessage,
+		ReversalOf:           t.ReversalOf,
+		CreatedAt:            t.CreatedAt,
+	}
 }
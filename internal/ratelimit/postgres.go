@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// store shared by all instances through the rate_limit_buckets table
+type postgresStore struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *pgxpool.Pool, logger *slog.Logger) Store {
+	return &postgresStore{db: db, logger: logger}
+}
+
+// refill and take a token atomically, see migration 008
+func (s *postgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.sweep(ctx)
+
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRow(ctx,
+		`SELECT available, granted FROM rate_limit_take($1, $2, $3)`,
+		key, float64(limit.Burst), limit.Rate,
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(limit, tokens, allowed), nil
+}
+
+// drop buckets that have refilled completely, they behave like new ones
+func (s *postgresStore) sweep(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE full_at < now()`); err != nil {
+		s.logger.Warn("failed to sweep rate limit buckets", slog.String("error", err.Error()))
+	}
+}
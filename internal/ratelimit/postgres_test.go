@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"internal-transfers/internal/migrate"
+	"internal-transfers/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := pgxpool.New(t.Context(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	migrator, err := migrate.New(db, migrations.FS, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 6)
+	rand.Read(b)
+	key := "test:" + hex.EncodeToString(b)
+	limiter := New(NewPostgresStore(db, logger), Limit{Rate: 0.001, Burst: 3})
+	if got := drain(t, limiter, key); got != 3 {
+		t.Errorf("granted %d requests, want the burst of 3", got)
+	}
+
+	// buckets live in the database, another store sees them empty
+	res, err := New(NewPostgresStore(db, logger), Limit{Rate: 0.001, Burst: 3}).Allow(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Error("another store was granted a token of the drained bucket")
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// token bucket refilled at Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until the next token is available, zero if allowed
+	Reset      time.Duration // until the bucket is full again
+}
+
+// keeps the token buckets
+type Store interface {
+	// take one token from the bucket for key
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// rate limits requests by key
+type Limiter struct {
+	store Store
+	limit Limit
+}
+
+func New(store Store, limit Limit) *Limiter {
+	return &Limiter{store: store, limit: limit}
+}
+
+// take a token for the key
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.store.Take(ctx, key, l.limit)
+}
+
+// build the result for a bucket holding tokens after the take
+func result(limit Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
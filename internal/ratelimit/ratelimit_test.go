@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// take tokens until the first rejection, returning how many were granted
+func drain(t *testing.T, limiter *Limiter, key string) int {
+	t.Helper()
+	for granted := 0; granted < 1000; granted++ {
+		res, err := limiter.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			if res.RetryAfter <= 0 || res.Remaining != 0 {
+				t.Errorf("rejection %+v, want a retry delay and nothing remaining", res)
+			}
+			return granted
+		}
+	}
+	t.Fatal("bucket never ran out")
+	return 0
+}
+
+func TestLimiterBurstPerKey(t *testing.T) {
+	limiter := New(NewMemoryStore(), Limit{Rate: 0.001, Burst: 5})
+
+	if got := drain(t, limiter, "a"); got != 5 {
+		t.Errorf("granted %d requests, want the burst of 5", got)
+	}
+	if got := drain(t, limiter, "b"); got != 5 {
+		t.Errorf("another key was granted %d requests, want a bucket of its own", got)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	limiter := New(store, Limit{Rate: 10, Burst: 4})
+	drain(t, limiter, "a")
+
+	// let 250ms pass, 2.5 tokens at 10 per second
+	store.buckets["a"].updated = store.buckets["a"].updated.Add(-250 * time.Millisecond)
+	if got := drain(t, limiter, "a"); got != 2 {
+		t.Errorf("granted %d requests after 250ms, want 2", got)
+	}
+
+	// a bucket never refills beyond its burst
+	store.buckets["a"].updated = store.buckets["a"].updated.Add(-time.Hour)
+	if got := drain(t, limiter, "a"); got != 4 {
+		t.Errorf("granted %d requests after an hour, want the burst of 4", got)
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	limiter := New(store, Limit{Rate: 1, Burst: 2})
+	limiter.Allow(context.Background(), "idle")
+	limiter.Allow(context.Background(), "busy")
+
+	store.buckets["idle"].full = time.Now().Add(-time.Second)
+	store.buckets["busy"].full = time.Now().Add(time.Hour)
+	store.lastSweep = time.Now().Add(-sweepInterval)
+	limiter.Allow(context.Background(), "other")
+
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("bucket still refilling was dropped")
+	}
+}
+
+func TestResult(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 10}
+	tests := []struct {
+		name    string
+		tokens  float64
+		allowed bool
+		want    Result
+	}{
+		{"full after the take", 9, true, Result{Allowed: true, Limit: 10, Remaining: 9, Reset: 500 * time.Millisecond}},
+		{"fraction left", 0.5, true, Result{Allowed: true, Limit: 10, Remaining: 0, Reset: 4750 * time.Millisecond}},
+		{"rejected", 0.5, false, Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: 250 * time.Millisecond, Reset: 4750 * time.Millisecond}},
+		{"rejected when empty", 0, false, Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: 5 * time.Second}},
+	}
+	for _, tt := range tests {
+		if got := result(limit, tt.tokens, tt.allowed); got != tt.want {
+			t.Errorf("%s: result() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
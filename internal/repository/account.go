@@ -3,7 +3,9 @@ package repository
 import (
 	"context"
 	"errors"
+	"internal-transfers/internal/metrics"
 	"internal-transfers/internal/models"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -13,9 +15,47 @@ import (
 
 type AccountRepository interface {
 	Create(ctx context.Context, account *models.Account) error
-	GetByID(ctx context.Context, accountID int64) (*models.Account, error)
-	UpdateBalance(ctx context.Context, tx pgx.Tx, accountID int64, newBalance decimal.Decimal) error
-	GetByIDForUpdate(ctx context.Context, tx pgx.Tx, accountID int64) (*models.Account, error)
+	GetByID(ctx context.Context, tenantID string, accountID int64) (*models.Account, error)
+	UpdateBalance(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal) error
+	UpdateBalanceIfVersion(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal, version int64) error
+	GetByIDForUpdate(ctx context.Context, tenantID string, accountID int64) (*models.Account, error)
+	AddDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error
+	RemoveDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error
+	IsDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) (bool, error)
+	Reconcile(ctx context.Context, tenantID string) ([]models.ReconciliationEntry, error)
+
+	GetShardForUpdate(ctx context.Context, tenantID string, accountID int64, shard int) (*models.AccountShard, error)
+	GetFundedShardForUpdate(ctx context.Context, tenantID string, accountID int64, amount decimal.Decimal) (*models.AccountShard, error)
+	ListShardsForUpdate(ctx context.Context, tenantID string, accountID int64) ([]models.AccountShard, error)
+	UpdateShardBalance(ctx context.Context, tenantID string, accountID int64, shard int, newBalance decimal.Decimal) error
+	SetShards(ctx context.Context, tenantID string, accountID int64, shards int) (*models.Account, error)
+}
+
+// returned when a hot account has no shard matching the request, either
+// because it was resharded or because every shard is taken or too small
+var ErrShardNotFound = errors.New("account shard not found")
+
+// columns of an account, with the balance of a hot account summed over its
+// shards
+const accountColumns = `
+	a.tenant_id, a.account_id,
+	a.balance + COALESCE((
+		SELECT SUM(s.balance) FROM account_shards s
+		WHERE s.tenant_id = a.tenant_id AND s.account_id = a.account_id
+	), 0),
+	a.owner_id, a.shards, a.version, a.created_at, a.updated_at`
+
+func scanAccount(row pgx.Row, account *models.Account) error {
+	return row.Scan(
+		&account.TenantID,
+		&account.AccountID,
+		&account.Balance,
+		&account.OwnerID,
+		&account.Shards,
+		&account.Version,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
 }
 
 type accountRepository struct {
@@ -29,11 +69,11 @@ func NewAccountRepository(db *pgxpool.Pool) AccountRepository {
 // create a new account
 func (r *accountRepository) Create(ctx context.Context, account *models.Account) error {
 	query := `
-		INSERT INTO accounts (account_id, balance, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
+		INSERT INTO accounts (tenant_id, account_id, balance, initial_balance, owner_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $3, $4, NOW(), NOW())
 	`
 
-	_, err := r.db.Exec(ctx, query, account.AccountID, account.Balance)
+	_, err := execScoped(ctx, r.db, account.TenantID, query, account.TenantID, account.AccountID, account.Balance, account.OwnerID)
 	if err != nil {
 		// Check for unique constraint violation
 		var pgErr *pgconn.PgError
@@ -49,20 +89,17 @@ func (r *accountRepository) Create(ctx context.Context, account *models.Account)
 }
 
 // get an account by ID
-func (r *accountRepository) GetByID(ctx context.Context, accountID int64) (*models.Account, error) {
+func (r *accountRepository) GetByID(ctx context.Context, tenantID string, accountID int64) (*models.Account, error) {
 	query := `
-		SELECT account_id, balance, created_at, updated_at
-		FROM accounts
-		WHERE account_id = $1
+		SELECT` + accountColumns + `
+		FROM accounts a
+		WHERE a.tenant_id = $1 AND a.account_id = $2
 	`
 
 	var account models.Account
-	err := r.db.QueryRow(ctx, query, accountID).Scan(
-		&account.AccountID,
-		&account.Balance,
-		&account.CreatedAt,
-		&account.UpdatedAt,
-	)
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return scanAccount(row, &account)
+	}, query, tenantID, accountID)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -74,25 +111,32 @@ func (r *accountRepository) GetByID(ctx context.Context, accountID int64) (*mode
 	return &account, nil
 }
 
-// get an account by ID
-func (r *accountRepository) GetByIDForUpdate(ctx context.Context, tx pgx.Tx, accountID int64) (*models.Account, error) {
+// get an account by ID and lock it until the unit of work running in ctx
+// ends. The unit of work must be scoped to the tenant. Hot accounts are
+// returned without a lock, their shards are locked instead.
+func (r *accountRepository) GetByIDForUpdate(ctx context.Context, tenantID string, accountID int64) (*models.Account, error) {
+	// an unsharded account has no shards to sum up
 	query := `
-		SELECT account_id, balance, created_at, updated_at
-		FROM accounts
-		WHERE account_id = $1
+		SELECT a.tenant_id, a.account_id, a.balance, a.owner_id, a.shards, a.version, a.created_at, a.updated_at
+		FROM accounts a
+		WHERE a.tenant_id = $1 AND a.account_id = $2 AND a.shards = 0
 		FOR UPDATE
 	`
 
+	// time spent here is dominated by waiting for the row lock
+	start := time.Now()
+
 	var account models.Account
-	err := tx.QueryRow(ctx, query, accountID).Scan(
-		&account.AccountID,
-		&account.Balance,
-		&account.CreatedAt,
-		&account.UpdatedAt)
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return scanAccount(row, &account)
+	}, query, tenantID, accountID)
+
+	metrics.LockWait.Observe(time.Since(start).Seconds())
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, models.ErrAccountNotFound
+			// either missing or hot
+			return r.GetByID(ctx, tenantID, accountID)
 		}
 		return nil, err
 	}
@@ -101,14 +145,14 @@ func (r *accountRepository) GetByIDForUpdate(ctx context.Context, tx pgx.Tx, acc
 }
 
 // update the balance
-func (r *accountRepository) UpdateBalance(ctx context.Context, tx pgx.Tx, accountID int64, newBalance decimal.Decimal) error {
+func (r *accountRepository) UpdateBalance(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal) error {
 	query := `
 		UPDATE accounts
-		SET balance = $1, updated_at = NOW()
-		WHERE account_id = $2
+		SET balance = $1, version = version + 1, updated_at = NOW()
+		WHERE tenant_id = $2 AND account_id = $3
 	`
 
-	result, err := tx.Exec(ctx, query, newBalance, accountID)
+	result, err := execScoped(ctx, r.db, tenantID, query, newBalance, tenantID, accountID)
 	if err != nil {
 		return err
 	}
@@ -119,3 +163,314 @@ func (r *accountRepository) UpdateBalance(ctx context.Context, tx pgx.Tx, accoun
 
 	return nil
 }
+
+// update the balance only if the account is still at the version it was
+// read at, otherwise return ErrVersionConflict. The account stays locked
+// until the unit of work running in ctx ends.
+func (r *accountRepository) UpdateBalanceIfVersion(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal, version int64) error {
+	query := `
+		UPDATE accounts
+		SET balance = $1, version = version + 1, updated_at = NOW()
+		WHERE tenant_id = $2 AND account_id = $3 AND version = $4
+	`
+
+	result, err := execScoped(ctx, r.db, tenantID, query, newBalance, tenantID, accountID, version)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		// tell a missing account from a changed one
+		if _, err := r.GetByID(ctx, tenantID, accountID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
+// allow a principal to act on an account
+func (r *accountRepository) AddDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error {
+	query := `
+		INSERT INTO account_delegates (tenant_id, account_id, principal_id, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (tenant_id, account_id, principal_id) DO NOTHING
+	`
+
+	_, err := execScoped(ctx, r.db, tenantID, query, tenantID, accountID, principalID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23503" { // foreign_key_violation
+				return models.ErrAccountNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// remove a delegate from an account
+func (r *accountRepository) RemoveDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error {
+	query := `
+		DELETE FROM account_delegates
+		WHERE tenant_id = $1 AND account_id = $2 AND principal_id = $3
+	`
+
+	result, err := execScoped(ctx, r.db, tenantID, query, tenantID, accountID, principalID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return models.ErrDelegateNotFound
+	}
+
+	return nil
+}
+
+// check whether a principal is a delegate of an account
+func (r *accountRepository) IsDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM account_delegates
+			WHERE tenant_id = $1 AND account_id = $2 AND principal_id = $3
+		)
+	`
+
+	var exists bool
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return row.Scan(&exists)
+	}, query, tenantID, accountID, principalID)
+	return exists, err
+}
+
+// compare every account of the tenant with the balance implied by its
+// initial balance and completed transfers
+func (r *accountRepository) Reconcile(ctx context.Context, tenantID string) ([]models.ReconciliationEntry, error) {
+	query := `
+		SELECT
+			a.account_id,
+			a.balance + COALESCE((
+				SELECT SUM(s.balance) FROM account_shards s
+				WHERE s.tenant_id = a.tenant_id AND s.account_id = a.account_id
+			), 0),
+			a.initial_balance + COALESCE(credits.total, 0) - COALESCE(debits.total, 0)
+		FROM accounts a
+		LEFT JOIN (
+			SELECT destination_account_id AS account_id, SUM(amount) AS total
+			FROM transactions
+			WHERE destination_tenant_id = $1 AND status = 'completed'
+			GROUP BY destination_account_id
+		) credits ON credits.account_id = a.account_id
+		LEFT JOIN (
+			SELECT source_account_id AS account_id, SUM(amount) AS total
+			FROM transactions
+			WHERE tenant_id = $1 AND status = 'completed'
+			GROUP BY source_account_id
+		) debits ON debits.account_id = a.account_id
+		WHERE a.tenant_id = $1
+		ORDER BY a.account_id
+	`
+
+	var entries []models.ReconciliationEntry
+	err := queryScoped(ctx, r.db, tenantID, func(rows pgx.Rows) error {
+		var entry models.ReconciliationEntry
+		if err := rows.Scan(&entry.AccountID, &entry.Balance, &entry.Expected); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	}, query, tenantID)
+
+	return entries, err
+}
+
+// lock a shard of a hot account until the unit of work running in ctx ends,
+// returning ErrShardNotFound if the account has no such shard
+func (r *accountRepository) GetShardForUpdate(ctx context.Context, tenantID string, accountID int64, shard int) (*models.AccountShard, error) {
+	query := `
+		SELECT shard, balance
+		FROM account_shards
+		WHERE tenant_id = $1 AND account_id = $2 AND shard = $3
+		FOR UPDATE
+	`
+
+	start := time.Now()
+
+	var found models.AccountShard
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return row.Scan(&found.Shard, &found.Balance)
+	}, query, tenantID, accountID, shard)
+
+	metrics.LockWait.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrShardNotFound
+		}
+		return nil, err
+	}
+
+	return &found, nil
+}
+
+// lock a random shard of a hot account holding at least amount, skipping
+// shards other transactions hold instead of waiting for them. Returns
+// ErrShardNotFound when no free shard holds enough.
+func (r *accountRepository) GetFundedShardForUpdate(ctx context.Context, tenantID string, accountID int64, amount decimal.Decimal) (*models.AccountShard, error) {
+	query := `
+		SELECT shard, balance
+		FROM account_shards
+		WHERE tenant_id = $1 AND account_id = $2 AND balance >= $3
+		ORDER BY random()
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	var found models.AccountShard
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return row.Scan(&found.Shard, &found.Balance)
+	}, query, tenantID, accountID, amount)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrShardNotFound
+		}
+		return nil, err
+	}
+
+	return &found, nil
+}
+
+// lock every shard of a hot account in shard order, waiting for other
+// transactions holding them
+func (r *accountRepository) ListShardsForUpdate(ctx context.Context, tenantID string, accountID int64) ([]models.AccountShard, error) {
+	query := `
+		SELECT shard, balance
+		FROM account_shards
+		WHERE tenant_id = $1 AND account_id = $2
+		ORDER BY shard
+		FOR UPDATE
+	`
+
+	start := time.Now()
+
+	var shards []models.AccountShard
+	err := queryScoped(ctx, r.db, tenantID, func(rows pgx.Rows) error {
+		var shard models.AccountShard
+		if err := rows.Scan(&shard.Shard, &shard.Balance); err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+		return nil
+	}, query, tenantID, accountID)
+
+	metrics.LockWait.Observe(time.Since(start).Seconds())
+
+	return shards, err
+}
+
+// update the balance of a shard
+func (r *accountRepository) UpdateShardBalance(ctx context.Context, tenantID string, accountID int64, shard int, newBalance decimal.Decimal) error {
+	query := `
+		UPDATE account_shards
+		SET balance = $1, updated_at = NOW()
+		WHERE tenant_id = $2 AND account_id = $3 AND shard = $4
+	`
+
+	result, err := execScoped(ctx, r.db, tenantID, query, newBalance, tenantID, accountID, shard)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrShardNotFound
+	}
+
+	return nil
+}
+
+// spread the balance of an account evenly over the given number of shards,
+// or move it back into the account row for 0. The account and all its shards
+// are locked first, so this must run in a unit of work scoped to the tenant.
+func (r *accountRepository) SetShards(ctx context.Context, tenantID string, accountID int64, shards int) (*models.Account, error) {
+	query := `
+		SELECT balance
+		FROM accounts
+		WHERE tenant_id = $1 AND account_id = $2
+		FOR UPDATE
+	`
+
+	// transfers of unsharded accounts hold the account row, those of hot
+	// accounts their shards
+	var total decimal.Decimal
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return row.Scan(&total)
+	}, query, tenantID, accountID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrAccountNotFound
+		}
+		return nil, err
+	}
+
+	current, err := r.ListShardsForUpdate(ctx, tenantID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range current {
+		total = total.Add(shard.Balance)
+	}
+
+	query = `DELETE FROM account_shards WHERE tenant_id = $1 AND account_id = $2`
+	if _, err := execScoped(ctx, r.db, tenantID, query, tenantID, accountID); err != nil {
+		return nil, err
+	}
+
+	balance := total
+	if shards > 0 {
+		balances := make([]string, shards)
+		for i, b := range SplitBalance(total, shards) {
+			balances[i] = b.String()
+		}
+
+		query = `
+			INSERT INTO account_shards (tenant_id, account_id, shard, balance, updated_at)
+			SELECT $1, $2, s.ordinality - 1, s.balance, NOW()
+			FROM unnest($3::numeric[]) WITH ORDINALITY AS s(balance, ordinality)
+		`
+		if _, err := execScoped(ctx, r.db, tenantID, query, tenantID, accountID, balances); err != nil {
+			return nil, err
+		}
+		balance = decimal.Zero
+	}
+
+	query = `
+		UPDATE accounts
+		SET balance = $1, shards = $2, version = version + 1, updated_at = NOW()
+		WHERE tenant_id = $3 AND account_id = $4
+	`
+	if _, err := execScoped(ctx, r.db, tenantID, query, balance, shards, tenantID, accountID); err != nil {
+		return nil, err
+	}
+
+	return r.GetByID(ctx, tenantID, accountID)
+}
+
+// split a balance into n parts, the first taking the rounding remainder
+func SplitBalance(total decimal.Decimal, n int) []decimal.Decimal {
+	if n <= 0 {
+		return nil
+	}
+
+	share := total.Div(decimal.NewFromInt(int64(n))).Truncate(18)
+	parts := make([]decimal.Decimal, n)
+	parts[0] = total.Sub(share.Mul(decimal.NewFromInt(int64(n - 1))))
+	for i := 1; i < n; i++ {
+		parts[i] = share
+	}
+	return parts
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	Revoke(ctx context.Context, tenantID string, id int64) error
+}
+
+type apiKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+// store a new api key, filling in its ID and creation time
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (tenant_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id, created_at
+	`
+
+	return conn(ctx, r.db).QueryRow(ctx, query, key.TenantID, key.Name, key.Prefix, key.KeyHash, key.Scopes).Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+}
+
+// get an api key by its public prefix. This is the only lookup not scoped by
+// tenant, since the tenant is only known once the key is resolved.
+func (r *apiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := `
+		SELECT id, tenant_id, name, prefix, key_hash, scopes, created_at, revoked_at
+		FROM api_keys
+		WHERE prefix = $1
+	`
+
+	var key models.APIKey
+	err := conn(ctx, r.db).QueryRow(ctx, query, prefix).Scan(
+		&key.ID,
+		&key.TenantID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.Scopes,
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// revoke an api key, revoking an already revoked key is a no-op
+func (r *apiKeyRepository) Revoke(ctx context.Context, tenantID string, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE tenant_id = $1 AND id = $2
+	`
+
+	result, err := conn(ctx, r.db).Exec(ctx, query, tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
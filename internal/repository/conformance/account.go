@@ -0,0 +1,208 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+
+	"github.com/shopspring/decimal"
+)
+
+func createAccount(ctx context.Context, store *repository.Store, tenantID string, accountID int64, balance string) error {
+	err := store.Accounts.Create(ctx, &models.Account{
+		TenantID:  tenantID,
+		AccountID: accountID,
+		Balance:   decimal.RequireFromString(balance),
+	})
+	if err != nil {
+		return fmt.Errorf("create account %d: %w", accountID, err)
+	}
+	return nil
+}
+
+// check the committed balance of an account
+func expectBalance(ctx context.Context, store *repository.Store, tenantID string, accountID int64, want string) error {
+	account, err := store.Accounts.GetByID(ctx, tenantID, accountID)
+	if err != nil {
+		return fmt.Errorf("get account %d: %w", accountID, err)
+	}
+	if !account.Balance.Equal(decimal.RequireFromString(want)) {
+		return fmt.Errorf("account %d has balance %s, want %s", accountID, account.Balance, want)
+	}
+	return nil
+}
+
+func testCreateAndGetAccount(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	owner := "user-1"
+
+	err := store.Accounts.Create(ctx, &models.Account{
+		TenantID:  tenantID,
+		AccountID: 1,
+		Balance:   decimal.RequireFromString("100.000000000000000001"),
+		OwnerID:   &owner,
+	})
+	if err != nil {
+		return fmt.Errorf("create account: %w", err)
+	}
+
+	account, err := store.Accounts.GetByID(ctx, tenantID, 1)
+	if err != nil {
+		return fmt.Errorf("get account: %w", err)
+	}
+	if account.TenantID != tenantID || account.AccountID != 1 {
+		return fmt.Errorf("got account %s/%d, want %s/1", account.TenantID, account.AccountID, tenantID)
+	}
+	if !account.Balance.Equal(decimal.RequireFromString("100.000000000000000001")) {
+		return fmt.Errorf("balance lost precision: %s", account.Balance)
+	}
+	if account.OwnerID == nil || *account.OwnerID != owner {
+		return fmt.Errorf("got owner %v, want %s", account.OwnerID, owner)
+	}
+	if account.CreatedAt.IsZero() {
+		return fmt.Errorf("created_at is not set")
+	}
+
+	err = createAccount(ctx, store, tenantID, 1, "5")
+	if err := expectError("duplicate account", err, models.ErrAccountExists); err != nil {
+		return err
+	}
+
+	// account IDs are only unique within a tenant
+	if err := createAccount(ctx, store, newTenant(), 1, "5"); err != nil {
+		return fmt.Errorf("same ID in another tenant: %w", err)
+	}
+
+	_, err = store.Accounts.GetByID(ctx, tenantID, 2)
+	if err := expectError("missing account", err, models.ErrAccountNotFound); err != nil {
+		return err
+	}
+	_, err = store.Accounts.GetByID(ctx, newTenant(), 1)
+	return expectError("account of another tenant", err, models.ErrAccountNotFound)
+}
+
+func testDelegates(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "0"); err != nil {
+		return err
+	}
+
+	if err := store.Accounts.AddDelegate(ctx, tenantID, 1, "user-2"); err != nil {
+		return fmt.Errorf("add delegate: %w", err)
+	}
+	if err := store.Accounts.AddDelegate(ctx, tenantID, 1, "user-2"); err != nil {
+		return fmt.Errorf("adding a delegate twice: %w", err)
+	}
+
+	ok, err := store.Accounts.IsDelegate(ctx, tenantID, 1, "user-2")
+	if err != nil || !ok {
+		return fmt.Errorf("delegate not found after adding it (err %v)", err)
+	}
+	ok, err = store.Accounts.IsDelegate(ctx, tenantID, 1, "user-3")
+	if err != nil || ok {
+		return fmt.Errorf("unknown principal reported as delegate (err %v)", err)
+	}
+
+	if err := store.Accounts.RemoveDelegate(ctx, tenantID, 1, "user-2"); err != nil {
+		return fmt.Errorf("remove delegate: %w", err)
+	}
+	ok, err = store.Accounts.IsDelegate(ctx, tenantID, 1, "user-2")
+	if err != nil || ok {
+		return fmt.Errorf("delegate still present after removing it (err %v)", err)
+	}
+
+	err = store.Accounts.RemoveDelegate(ctx, tenantID, 1, "user-2")
+	if err := expectError("removing a missing delegate", err, models.ErrDelegateNotFound); err != nil {
+		return err
+	}
+	err = store.Accounts.AddDelegate(ctx, tenantID, 2, "user-2")
+	return expectError("delegate of a missing account", err, models.ErrAccountNotFound)
+}
+
+func testReconcile(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	for id, balance := range map[int64]string{1: "100", 2: "0", 3: "7"} {
+		if err := createAccount(ctx, store, tenantID, id, balance); err != nil {
+			return err
+		}
+	}
+
+	if _, err := transfer(ctx, store, tenantID, 1, tenantID, 2, "30", models.TransactionStatusCompleted, nil); err != nil {
+		return err
+	}
+	if _, err := transfer(ctx, store, tenantID, 2, tenantID, 1, "999", models.TransactionStatusFailed, nil); err != nil {
+		return err
+	}
+
+	// a balance change without a transfer record
+	if err := store.Accounts.UpdateBalance(ctx, tenantID, 2, decimal.RequireFromString("35")); err != nil {
+		return err
+	}
+
+	entries, err := store.Accounts.Reconcile(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("reconcile: %w", err)
+	}
+
+	want := []struct {
+		accountID         int64
+		balance, expected string
+	}{
+		{1, "70", "70"},
+		{2, "35", "30"},
+		{3, "7", "7"},
+	}
+	if len(entries) != len(want) {
+		return fmt.Errorf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.AccountID != w.accountID ||
+			!e.Balance.Equal(decimal.RequireFromString(w.balance)) ||
+			!e.Expected.Equal(decimal.RequireFromString(w.expected)) {
+			return fmt.Errorf("entry %d is account %d balance %s expected %s, want account %d balance %s expected %s",
+				i, e.AccountID, e.Balance, e.Expected, w.accountID, w.balance, w.expected)
+		}
+	}
+	return nil
+}
+
+func testVersions(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	account, err := store.Accounts.GetByID(ctx, tenantID, 1)
+	if err != nil {
+		return err
+	}
+	version := account.Version
+
+	if err := store.Accounts.UpdateBalance(ctx, tenantID, 1, decimal.RequireFromString("90")); err != nil {
+		return err
+	}
+	if err := store.Accounts.UpdateBalanceIfVersion(ctx, tenantID, 1, decimal.RequireFromString("80"), version+1); err != nil {
+		return fmt.Errorf("update at the current version: %w", err)
+	}
+
+	err = store.Accounts.UpdateBalanceIfVersion(ctx, tenantID, 1, decimal.RequireFromString("70"), version+1)
+	if err := expectError("update at a stale version", err, repository.ErrVersionConflict); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, tenantID, 1, "80"); err != nil {
+		return err
+	}
+
+	account, err = store.Accounts.GetByID(ctx, tenantID, 1)
+	if err != nil {
+		return err
+	}
+	if account.Version != version+2 {
+		return fmt.Errorf("version is %d after two updates, want %d", account.Version, version+2)
+	}
+
+	err = store.Accounts.UpdateBalanceIfVersion(ctx, tenantID, 2, decimal.Zero, 0)
+	return expectError("conditional update of a missing account", err, models.ErrAccountNotFound)
+}
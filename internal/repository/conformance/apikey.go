@@ -0,0 +1,63 @@
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"slices"
+)
+
+func testAPIKeys(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	b := make([]byte, 8)
+	rand.Read(b)
+	prefix := hex.EncodeToString(b)
+
+	key := &models.APIKey{
+		TenantID: tenantID,
+		Name:     "conformance",
+		Prefix:   prefix,
+		KeyHash:  []byte("hash"),
+		Scopes:   []string{"accounts:read", "transfers:write"},
+	}
+	if err := store.APIKeys.Create(ctx, key); err != nil {
+		return fmt.Errorf("create api key: %w", err)
+	}
+	if key.ID == 0 || key.CreatedAt.IsZero() {
+		return fmt.Errorf("create did not fill in the ID and creation time")
+	}
+
+	found, err := store.APIKeys.GetByPrefix(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("get api key: %w", err)
+	}
+	if found.ID != key.ID || found.TenantID != tenantID || string(found.KeyHash) != "hash" ||
+		!slices.Equal(found.Scopes, key.Scopes) || found.RevokedAt != nil {
+		return fmt.Errorf("got api key %+v, want %+v", found, key)
+	}
+
+	err = store.APIKeys.Revoke(ctx, newTenant(), key.ID)
+	if err := expectError("revoking the key of another tenant", err, models.ErrAPIKeyNotFound); err != nil {
+		return err
+	}
+
+	if err := store.APIKeys.Revoke(ctx, tenantID, key.ID); err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if err := store.APIKeys.Revoke(ctx, tenantID, key.ID); err != nil {
+		return fmt.Errorf("revoking a key twice: %w", err)
+	}
+	found, err = store.APIKeys.GetByPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if found.RevokedAt == nil {
+		return fmt.Errorf("revoked key has no revocation time")
+	}
+
+	_, err = store.APIKeys.GetByPrefix(ctx, prefix+"x")
+	return expectError("unknown prefix", err, models.ErrAPIKeyNotFound)
+}
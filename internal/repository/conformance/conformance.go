@@ -0,0 +1,79 @@
+// Package conformance checks that a repository.Store behaves the way the
+// services rely on: atomic commits, row locks held until the transaction
+// ends, tenant scoping and the error values of the models package. The
+// postgres and memory stores must both pass it, see Test.
+//
+// Every case works in tenants of its own, so the suite can be repeated
+// against a database that holds other data. It does not clean up after
+// itself, so point it at a test database.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/repository"
+	"testing"
+)
+
+// a single check against a store
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, store *repository.Store) error
+}
+
+// every check of the suite
+var Cases = []Case{
+	{"accounts/create and get", testCreateAndGetAccount},
+	{"accounts/delegates", testDelegates},
+	{"accounts/reconcile", testReconcile},
+	{"accounts/version increases with every update", testVersions},
+	{"tx/commit applies every write", testCommit},
+	{"tx/rollback discards every write", testRollback},
+	{"tx/panic rolls back", testPanicRollsBack},
+	{"tx/reads its own writes", testReadOwnWrites},
+	{"tx/savepoint rollback keeps outer writes", testSavepointRollback},
+	{"tx/savepoint is undone with the outer transaction", testSavepointRelease},
+	{"tx/nested unit of work stays in the outer scope", testNestedScope},
+	{"tx/lock blocks other transactions", testLockBlocks},
+	{"tx/lock wait honours the context", testLockWaitCancel},
+	{"tx/conditional update fails after a concurrent commit", testStaleVersion},
+	{"tx/serializable read-modify-write loses no update", testSerializableIncrements},
+	{"shards/split and merge keep the balance", testSplitAndMerge},
+	{"shards/updates apply on commit", testShardUpdates},
+	{"shards/funded shard skips locked shards", testFundedShardSkipsLocked},
+	{"transactions/visible to both tenants", testTransactionVisibility},
+	{"transactions/list newest first in pages", testListByAccount},
+	{"transactions/reversal recorded once", testReversalOnce},
+	{"transactions/execute as a single statement", testExecute},
+	{"transactions/queue claims and finishes pending transfers", testQueue},
+	{"api keys/create, look up and revoke", testAPIKeys},
+}
+
+// run every case against the store as a subtest of t
+func Test(t *testing.T, store *repository.Store) {
+	for _, c := range Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Run(t.Context(), store); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// a tenant no other case or run uses
+func newTenant() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "conformance-" + hex.EncodeToString(b)
+}
+
+// check err matches target
+func expectError(what string, err, target error) error {
+	if !errors.Is(err, target) {
+		return fmt.Errorf("%s: got error %v, want %v", what, err, target)
+	}
+	return nil
+}
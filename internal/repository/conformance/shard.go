@@ -0,0 +1,174 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+
+	"github.com/shopspring/decimal"
+)
+
+// shard an account in a unit of work of its own
+func setShards(ctx context.Context, store *repository.Store, tenantID string, accountID int64, shards int) (*models.Account, error) {
+	var account *models.Account
+	err := store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		var err error
+		account, err = store.Accounts.SetShards(ctx, tenantID, accountID, shards)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("set %d shards of account %d: %w", shards, accountID, err)
+	}
+	return account, nil
+}
+
+func testSplitAndMerge(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100.000000000000000001"); err != nil {
+		return err
+	}
+
+	account, err := setShards(ctx, store, tenantID, 1, 3)
+	if err != nil {
+		return err
+	}
+	if account.Shards != 3 {
+		return fmt.Errorf("account has %d shards, want 3", account.Shards)
+	}
+	if err := expectBalance(ctx, store, tenantID, 1, "100.000000000000000001"); err != nil {
+		return fmt.Errorf("after sharding: %w", err)
+	}
+
+	var shards []models.AccountShard
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		shards, err = store.Accounts.ListShardsForUpdate(ctx, tenantID, 1)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("list shards: %w", err)
+	}
+	total := decimal.Zero
+	for i, shard := range shards {
+		if shard.Shard != i {
+			return fmt.Errorf("shard %d listed at position %d", shard.Shard, i)
+		}
+		total = total.Add(shard.Balance)
+	}
+	if len(shards) != 3 || !total.Equal(decimal.RequireFromString("100.000000000000000001")) {
+		return fmt.Errorf("got %d shards holding %s, want 3 holding the whole balance", len(shards), total)
+	}
+
+	// the shards take part in reconciliation
+	entries, err := store.Accounts.Reconcile(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("reconcile: %w", err)
+	}
+	if len(entries) != 1 || !entries[0].Balance.Equal(entries[0].Expected) {
+		return fmt.Errorf("reconciliation of the sharded account reports %+v", entries)
+	}
+
+	account, err = setShards(ctx, store, tenantID, 1, 0)
+	if err != nil {
+		return err
+	}
+	if account.Shards != 0 {
+		return fmt.Errorf("account still has %d shards after merging them", account.Shards)
+	}
+	return expectBalance(ctx, store, tenantID, 1, "100.000000000000000001")
+}
+
+func testShardUpdates(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	if _, err := setShards(ctx, store, tenantID, 1, 2); err != nil {
+		return err
+	}
+
+	err := store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		shard, err := store.Accounts.GetShardForUpdate(ctx, tenantID, 1, 1)
+		if err != nil {
+			return err
+		}
+		if err := store.Accounts.UpdateShardBalance(ctx, tenantID, 1, 1, shard.Balance.Add(decimal.NewFromInt(10))); err != nil {
+			return err
+		}
+		if err := expectBalance(ctx, store, tenantID, 1, "110"); err != nil {
+			return fmt.Errorf("inside the unit of work: %w", err)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		return fmt.Errorf("rolled back shard update: %w", err)
+	}
+	if err := expectBalance(ctx, store, tenantID, 1, "100"); err != nil {
+		return fmt.Errorf("after rollback: %w", err)
+	}
+
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		shard, err := store.Accounts.GetShardForUpdate(ctx, tenantID, 1, 0)
+		if err != nil {
+			return err
+		}
+		return store.Accounts.UpdateShardBalance(ctx, tenantID, 1, 0, shard.Balance.Sub(decimal.NewFromInt(50)))
+	})
+	if err != nil {
+		return fmt.Errorf("committed shard update: %w", err)
+	}
+	if err := expectBalance(ctx, store, tenantID, 1, "50"); err != nil {
+		return fmt.Errorf("after commit: %w", err)
+	}
+
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		_, err := store.Accounts.GetShardForUpdate(ctx, tenantID, 1, 2)
+		return err
+	})
+	return expectError("shard beyond the shard count", err, repository.ErrShardNotFound)
+}
+
+func testFundedShardSkipsLocked(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	if _, err := setShards(ctx, store, tenantID, 1, 2); err != nil {
+		return err
+	}
+
+	// none of this may wait for the holder, it would wait forever
+	outer, cancel := context.WithTimeout(ctx, 10*blockedFor)
+	defer cancel()
+
+	return store.UnitOfWork.Do(outer, scope(tenantID), func(ctx context.Context) error {
+		// hot accounts are not locked as a whole
+		if _, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1); err != nil {
+			return fmt.Errorf("lock a hot account: %w", err)
+		}
+		held, err := store.Accounts.GetFundedShardForUpdate(ctx, tenantID, 1, decimal.NewFromInt(30))
+		if err != nil {
+			return fmt.Errorf("first funded shard: %w", err)
+		}
+
+		// a concurrent transaction gets the other shard, and none once
+		// that one does not hold enough either
+		return store.UnitOfWork.Do(outer, scope(tenantID), func(ctx context.Context) error {
+			if _, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1); err != nil {
+				return fmt.Errorf("lock a hot account: %w", err)
+			}
+
+			other, err := store.Accounts.GetFundedShardForUpdate(ctx, tenantID, 1, decimal.NewFromInt(30))
+			if err != nil {
+				return fmt.Errorf("second funded shard: %w", err)
+			}
+			if other.Shard == held.Shard {
+				return fmt.Errorf("both transactions got shard %d", held.Shard)
+			}
+
+			_, err = store.Accounts.GetFundedShardForUpdate(ctx, tenantID, 1, decimal.NewFromInt(60))
+			return expectError("funded shard with every shard held or too small", err, repository.ErrShardNotFound)
+		})
+	})
+}
@@ -0,0 +1,331 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testTransactionVisibility(ctx context.Context, store *repository.Store) error {
+	source, destination := newTenant(), newTenant()
+	if err := createAccount(ctx, store, source, 1, "100"); err != nil {
+		return err
+	}
+	if err := createAccount(ctx, store, destination, 1, "0"); err != nil {
+		return err
+	}
+
+	id, err := transfer(ctx, store, source, 1, destination, 1, "10", models.TransactionStatusCompleted, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, tenantID := range []string{source, destination} {
+		recorded, err := store.Transactions.GetByID(ctx, tenantID, id)
+		if err != nil {
+			return fmt.Errorf("get transfer as tenant %s: %w", tenantID, err)
+		}
+		if recorded.TenantID != source || recorded.DestinationTenantID != destination {
+			return fmt.Errorf("transfer is from %s to %s, want %s to %s", recorded.TenantID, recorded.DestinationTenantID, source, destination)
+		}
+	}
+
+	_, err = store.Transactions.GetByID(ctx, newTenant(), id)
+	if err := expectError("transfer of other tenants", err, models.ErrTransactionNotFound); err != nil {
+		return err
+	}
+
+	// both tenants list it for their own account
+	for _, tenantID := range []string{source, destination} {
+		listed, err := store.Transactions.ListByAccount(ctx, tenantID, 1, 10, 0)
+		if err != nil {
+			return fmt.Errorf("list as tenant %s: %w", tenantID, err)
+		}
+		if len(listed) != 1 || listed[0].TransactionID != id {
+			return fmt.Errorf("tenant %s lists %d transfers, want only %d", tenantID, len(listed), id)
+		}
+	}
+	return nil
+}
+
+func testListByAccount(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	for id := int64(1); id <= 3; id++ {
+		if err := createAccount(ctx, store, tenantID, id, "100"); err != nil {
+			return err
+		}
+	}
+
+	// transfers of account 1 in both directions, including a failed one
+	var ids []int64
+	for _, t := range []struct {
+		source, destination int64
+		status              models.TransactionStatus
+	}{
+		{1, 2, models.TransactionStatusCompleted},
+		{2, 1, models.TransactionStatusCompleted},
+		{2, 3, models.TransactionStatusCompleted},
+		{1, 3, models.TransactionStatusFailed},
+		{3, 1, models.TransactionStatusCompleted},
+	} {
+		id, err := transfer(ctx, store, tenantID, t.source, tenantID, t.destination, "1", t.status, nil)
+		if err != nil {
+			return err
+		}
+		if t.source == 1 || t.destination == 1 {
+			ids = append(ids, id)
+		}
+	}
+	slices.Reverse(ids)
+
+	first, err := store.Transactions.ListByAccount(ctx, tenantID, 1, 2, 0)
+	if err != nil {
+		return fmt.Errorf("list first page: %w", err)
+	}
+	second, err := store.Transactions.ListByAccount(ctx, tenantID, 1, 10, ids[1])
+	if err != nil {
+		return fmt.Errorf("list second page: %w", err)
+	}
+
+	var got []int64
+	for _, t := range append(first, second...) {
+		got = append(got, t.TransactionID)
+	}
+	if len(first) != 2 || !slices.Equal(got, ids) {
+		return fmt.Errorf("pages list %v (first page %d), want %v", got, len(first), ids)
+	}
+
+	empty, err := store.Transactions.ListByAccount(ctx, tenantID, 99, 10, 0)
+	if err != nil {
+		return fmt.Errorf("list unknown account: %w", err)
+	}
+	if empty == nil || len(empty) != 0 {
+		return fmt.Errorf("unknown account lists %v, want an empty slice", empty)
+	}
+	return nil
+}
+
+func testReversalOnce(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	if err := createAccount(ctx, store, tenantID, 2, "0"); err != nil {
+		return err
+	}
+
+	original, err := transfer(ctx, store, tenantID, 1, tenantID, 2, "10", models.TransactionStatusCompleted, nil)
+	if err != nil {
+		return err
+	}
+
+	// failed attempts do not count
+	if _, err := transfer(ctx, store, tenantID, 2, tenantID, 1, "10", models.TransactionStatusFailed, &original); err != nil {
+		return fmt.Errorf("failed reversal: %w", err)
+	}
+
+	reversal, err := transfer(ctx, store, tenantID, 2, tenantID, 1, "10", models.TransactionStatusCompleted, &original)
+	if err != nil {
+		return fmt.Errorf("reversal: %w", err)
+	}
+	recorded, err := store.Transactions.GetByID(ctx, tenantID, reversal)
+	if err != nil {
+		return err
+	}
+	if recorded.ReversalOf == nil || *recorded.ReversalOf != original {
+		return fmt.Errorf("reversal points at %v, want %d", recorded.ReversalOf, original)
+	}
+
+	_, err = transfer(ctx, store, tenantID, 2, tenantID, 1, "10", models.TransactionStatusCompleted, &original)
+	if err := expectError("second reversal", err, models.ErrAlreadyReversed); err != nil {
+		return err
+	}
+
+	// the rejected reversal must not have moved any money
+	if err := expectBalance(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	return expectBalance(ctx, store, tenantID, 2, "0")
+}
+
+func testExecute(ctx context.Context, store *repository.Store) error {
+	source, destination := newTenant(), newTenant()
+	owner := "user-1"
+	err := store.Accounts.Create(ctx, &models.Account{TenantID: source, AccountID: 1, Balance: decimal.NewFromInt(100), OwnerID: &owner})
+	if err != nil {
+		return fmt.Errorf("create account 1: %w", err)
+	}
+	if err := createAccount(ctx, store, destination, 1, "0"); err != nil {
+		return err
+	}
+
+	message := "insufficient balance!"
+	newTransfer := func(amount int64) *models.Transaction {
+		return &models.Transaction{
+			TenantID:             source,
+			SourceAccountID:      1,
+			DestinationTenantID:  destination,
+			DestinationAccountID: 1,
+			Amount:               decimal.NewFromInt(amount),
+			ErrorMessage:         &message,
+		}
+	}
+
+	completed := newTransfer(30)
+	if err := store.Transactions.Execute(ctx, completed, &owner); err != nil {
+		return fmt.Errorf("execute a covered transfer: %w", err)
+	}
+	if completed.TransactionID == 0 || completed.Status != models.TransactionStatusCompleted || completed.ErrorMessage != nil {
+		return fmt.Errorf("executed transfer is %+v, want a completed record", completed)
+	}
+	if err := expectBalance(ctx, store, source, 1, "70"); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, destination, 1, "30"); err != nil {
+		return err
+	}
+
+	failed := newTransfer(71)
+	err = store.Transactions.Execute(ctx, failed, nil)
+	if err := expectError("transfer the source cannot cover", err, models.ErrInsufficientBalance); err != nil {
+		return err
+	}
+	recorded, err := store.Transactions.GetByID(ctx, source, failed.TransactionID)
+	if err != nil {
+		return fmt.Errorf("get failed transfer: %w", err)
+	}
+	if recorded.Status != models.TransactionStatusFailed || recorded.ErrorMessage == nil || *recorded.ErrorMessage != message {
+		return fmt.Errorf("failed transfer recorded as %+v", recorded)
+	}
+	if err := expectBalance(ctx, store, source, 1, "70"); err != nil {
+		return err
+	}
+
+	// nothing is written for transfers it leaves to the transfer service
+	other := "user-2"
+	err = store.Transactions.Execute(ctx, newTransfer(10), &other)
+	if err := expectError("debit by a principal without access", err, repository.ErrNotExecuted); err != nil {
+		return err
+	}
+	missing := newTransfer(10)
+	missing.DestinationAccountID = 2
+	err = store.Transactions.Execute(ctx, missing, nil)
+	if err := expectError("transfer to a missing account", err, repository.ErrNotExecuted); err != nil {
+		return err
+	}
+	if _, err := setShards(ctx, store, source, 1, 2); err != nil {
+		return err
+	}
+	err = store.Transactions.Execute(ctx, newTransfer(10), nil)
+	if err := expectError("transfer from a hot account", err, repository.ErrNotExecuted); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, source, 1, "70"); err != nil {
+		return err
+	}
+
+	listed, err := store.Transactions.ListByAccount(ctx, source, 1, 10, 0)
+	if err != nil {
+		return fmt.Errorf("list transfers: %w", err)
+	}
+	if len(listed) != 2 {
+		return fmt.Errorf("%d transfers recorded, want the completed and the failed one", len(listed))
+	}
+	return nil
+}
+
+func testQueue(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	if err := createAccount(ctx, store, tenantID, 2, "0"); err != nil {
+		return err
+	}
+
+	principal := "user-1"
+	queued := &models.Transaction{
+		TenantID:             tenantID,
+		SourceAccountID:      1,
+		DestinationTenantID:  tenantID,
+		DestinationAccountID: 2,
+		Amount:               decimal.NewFromInt(10),
+		InitiatedBy:          &principal,
+	}
+	if err := store.Transactions.Enqueue(ctx, queued, []string{"admin"}); err != nil {
+		return fmt.Errorf("enqueue: %w", err)
+	}
+	recorded, err := store.Transactions.GetByID(ctx, tenantID, queued.TransactionID)
+	if err != nil {
+		return fmt.Errorf("get queued transfer: %w", err)
+	}
+	if recorded.Status != models.TransactionStatusPending || recorded.InitiatedBy == nil || *recorded.InitiatedBy != principal {
+		return fmt.Errorf("queued transfer recorded as %+v, want pending and initiated by %s", recorded, principal)
+	}
+
+	missing := *queued
+	missing.DestinationAccountID = 3
+	err = store.Transactions.Enqueue(ctx, &missing, nil)
+	if err := expectError("queueing a transfer to a missing account", err, models.ErrAccountNotFound); err != nil {
+		return err
+	}
+
+	// the queue is shared with other cases and runs, so claim until ours
+	// comes up; a zero lease leaves the others due for their own workers
+	claim := func(lease time.Duration) (*models.QueuedTransfer, error) {
+		for {
+			job, err := store.Transactions.Claim(ctx, lease)
+			if err != nil {
+				return nil, err
+			}
+			if job.TransactionID == queued.TransactionID {
+				return job, nil
+			}
+		}
+	}
+	job, err := claim(0)
+	if err != nil {
+		return fmt.Errorf("claim: %w", err)
+	}
+	if job.TenantID != tenantID || !slices.Equal(job.PrincipalRoles, []string{"admin"}) || job.Attempts != 1 {
+		return fmt.Errorf("claimed %+v, want the job of tenant %s with role admin at attempt 1", job, tenantID)
+	}
+	if job, err = claim(time.Hour); err != nil || job.Attempts != 2 {
+		return fmt.Errorf("claiming again after the lease: got %+v, err %v", job, err)
+	}
+
+	// finishing rolls back with the unit of work, and the job stays queued
+	finished := *recorded
+	finished.Status = models.TransactionStatusCompleted
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		if err := store.Transactions.Finish(ctx, &finished); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		return fmt.Errorf("rolled back finish: %w", err)
+	}
+	if recorded, err = store.Transactions.GetByID(ctx, tenantID, queued.TransactionID); err != nil || recorded.Status != models.TransactionStatusPending {
+		return fmt.Errorf("transfer after a rolled back finish: got %+v, err %v", recorded, err)
+	}
+
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		return store.Transactions.Finish(ctx, &finished)
+	})
+	if err != nil {
+		return fmt.Errorf("finish: %w", err)
+	}
+	if recorded, err = store.Transactions.GetByID(ctx, tenantID, queued.TransactionID); err != nil || recorded.Status != models.TransactionStatusCompleted {
+		return fmt.Errorf("transfer after finishing it: got %+v, err %v", recorded, err)
+	}
+
+	err = store.Transactions.Finish(ctx, &finished)
+	return expectError("finishing a transfer twice", err, repository.ErrNotPending)
+}
@@ -0,0 +1,467 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// how long a blocked lock wait is observed before it is assumed to block
+const blockedFor = 200 * time.Millisecond
+
+// returned by callbacks to make their unit of work roll back
+var errAbort = errors.New("abort")
+
+// options of a unit of work over the tenants
+func scope(tenantIDs ...string) repository.TxOptions {
+	return repository.TxOptions{TenantIDs: tenantIDs}
+}
+
+// move amount between two accounts the way the transfer service does and
+// record it. Failed transfers are only recorded.
+func transfer(ctx context.Context, store *repository.Store, sourceTenant string, sourceID int64, destTenant string, destID int64, amount string, status models.TransactionStatus, reversalOf *int64) (int64, error) {
+	var id int64
+	err := store.UnitOfWork.Do(ctx, scope(sourceTenant, destTenant), func(ctx context.Context) error {
+		var err error
+		id, err = recordTransfer(ctx, store, sourceTenant, sourceID, destTenant, destID, amount, status, reversalOf)
+		return err
+	})
+	return id, err
+}
+
+// the body of transfer, for use inside a unit of work
+func recordTransfer(ctx context.Context, store *repository.Store, sourceTenant string, sourceID int64, destTenant string, destID int64, amount string, status models.TransactionStatus, reversalOf *int64) (int64, error) {
+	value := decimal.RequireFromString(amount)
+	if status == models.TransactionStatusCompleted {
+		if err := moveFunds(ctx, store, sourceTenant, sourceID, destTenant, destID, value); err != nil {
+			return 0, err
+		}
+	}
+
+	id, err := store.Transactions.Create(ctx, &models.Transaction{
+		TenantID:             sourceTenant,
+		SourceAccountID:      sourceID,
+		DestinationTenantID:  destTenant,
+		DestinationAccountID: destID,
+		Amount:               value,
+		Status:               status,
+		ReversalOf:           reversalOf,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("record transfer: %w", err)
+	}
+	return id, nil
+}
+
+func moveFunds(ctx context.Context, store *repository.Store, sourceTenant string, sourceID int64, destTenant string, destID int64, amount decimal.Decimal) error {
+	source, err := store.Accounts.GetByIDForUpdate(ctx, sourceTenant, sourceID)
+	if err != nil {
+		return fmt.Errorf("lock source: %w", err)
+	}
+	destination, err := store.Accounts.GetByIDForUpdate(ctx, destTenant, destID)
+	if err != nil {
+		return fmt.Errorf("lock destination: %w", err)
+	}
+	if err := store.Accounts.UpdateBalance(ctx, sourceTenant, sourceID, source.Balance.Sub(amount)); err != nil {
+		return fmt.Errorf("update source: %w", err)
+	}
+	if err := store.Accounts.UpdateBalance(ctx, destTenant, destID, destination.Balance.Add(amount)); err != nil {
+		return fmt.Errorf("update destination: %w", err)
+	}
+	return nil
+}
+
+// create accounts 1 and 2 of a new tenant, holding 100 and 0
+func twoAccounts(ctx context.Context, store *repository.Store) (string, error) {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return "", err
+	}
+	return tenantID, createAccount(ctx, store, tenantID, 2, "0")
+}
+
+func testCommit(ctx context.Context, store *repository.Store) error {
+	tenantID, err := twoAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	id, err := transfer(ctx, store, tenantID, 1, tenantID, 2, "40.5", models.TransactionStatusCompleted, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := expectBalance(ctx, store, tenantID, 1, "59.5"); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, tenantID, 2, "40.5"); err != nil {
+		return err
+	}
+
+	recorded, err := store.Transactions.GetByID(ctx, tenantID, id)
+	if err != nil {
+		return fmt.Errorf("get committed transfer: %w", err)
+	}
+	if recorded.Status != models.TransactionStatusCompleted || !recorded.Amount.Equal(decimal.RequireFromString("40.5")) {
+		return fmt.Errorf("recorded transfer is %s of %s, want completed of 40.5", recorded.Status, recorded.Amount)
+	}
+	if recorded.CreatedAt.IsZero() {
+		return fmt.Errorf("created_at is not set")
+	}
+	return nil
+}
+
+func testRollback(ctx context.Context, store *repository.Store) error {
+	tenantID, err := twoAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(txCtx context.Context) error {
+		var err error
+		id, err = recordTransfer(txCtx, store, tenantID, 1, tenantID, 2, "10", models.TransactionStatusCompleted, nil)
+		if err != nil {
+			return err
+		}
+
+		// nothing is visible outside the unit of work before the commit
+		if err := expectBalance(ctx, store, tenantID, 1, "100"); err != nil {
+			return fmt.Errorf("uncommitted write visible: %w", err)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		return fmt.Errorf("unit of work returned %v, want the error of the callback", err)
+	}
+
+	if err := expectBalance(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, tenantID, 2, "0"); err != nil {
+		return err
+	}
+	_, err = store.Transactions.GetByID(ctx, tenantID, id)
+	return expectError("rolled back transfer", err, models.ErrTransactionNotFound)
+}
+
+func testPanicRollsBack(ctx context.Context, store *repository.Store) (err error) {
+	tenantID, err := twoAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				err = errors.New("the panic of the callback was swallowed")
+			}
+		}()
+		store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+			if _, err := recordTransfer(ctx, store, tenantID, 1, tenantID, 2, "10", models.TransactionStatusCompleted, nil); err != nil {
+				return err
+			}
+			panic("conformance")
+		})
+	}()
+	if err != nil {
+		return err
+	}
+
+	if err := expectBalance(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	// the row locks must be gone too
+	_, err = transfer(ctx, store, tenantID, 1, tenantID, 2, "10", models.TransactionStatusCompleted, nil)
+	return err
+}
+
+func testReadOwnWrites(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	return store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		if _, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1); err != nil {
+			return err
+		}
+		if err := store.Accounts.UpdateBalance(ctx, tenantID, 1, decimal.RequireFromString("75")); err != nil {
+			return err
+		}
+
+		// locking again in the same transaction must not block
+		account, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1)
+		if err != nil {
+			return fmt.Errorf("lock account again: %w", err)
+		}
+		if !account.Balance.Equal(decimal.RequireFromString("75")) {
+			return fmt.Errorf("transaction sees balance %s, want its own update 75", account.Balance)
+		}
+		if err := expectBalance(ctx, store, tenantID, 1, "75"); err != nil {
+			return fmt.Errorf("plain read in the transaction: %w", err)
+		}
+
+		err = store.Accounts.UpdateBalance(ctx, tenantID, 2, decimal.Zero)
+		return expectError("update of a missing account", err, models.ErrAccountNotFound)
+	})
+}
+
+func testSavepointRollback(ctx context.Context, store *repository.Store) error {
+	tenantID, err := twoAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	var outerID, innerID int64
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		var err error
+		outerID, err = recordTransfer(ctx, store, tenantID, 1, tenantID, 2, "10", models.TransactionStatusCompleted, nil)
+		if err != nil {
+			return err
+		}
+
+		err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+			var err error
+			innerID, err = recordTransfer(ctx, store, tenantID, 1, tenantID, 2, "5", models.TransactionStatusCompleted, nil)
+			if err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			return fmt.Errorf("nested unit of work returned %v, want the error of the callback", err)
+		}
+
+		// the outer writes survive the savepoint rollback
+		return expectBalance(ctx, store, tenantID, 1, "90")
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := expectBalance(ctx, store, tenantID, 1, "90"); err != nil {
+		return err
+	}
+	if err := expectBalance(ctx, store, tenantID, 2, "10"); err != nil {
+		return err
+	}
+	if _, err := store.Transactions.GetByID(ctx, tenantID, outerID); err != nil {
+		return fmt.Errorf("get outer transfer: %w", err)
+	}
+	_, err = store.Transactions.GetByID(ctx, tenantID, innerID)
+	return expectError("transfer of the rolled back savepoint", err, models.ErrTransactionNotFound)
+}
+
+func testSavepointRelease(ctx context.Context, store *repository.Store) error {
+	tenantID, err := twoAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		err := store.UnitOfWork.Do(ctx, repository.TxOptions{}, func(ctx context.Context) error {
+			_, err := recordTransfer(ctx, store, tenantID, 1, tenantID, 2, "5", models.TransactionStatusCompleted, nil)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("nested unit of work: %w", err)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		return fmt.Errorf("unit of work returned %v, want the error of the callback", err)
+	}
+
+	// released savepoints are still undone with the outer transaction
+	return expectBalance(ctx, store, tenantID, 1, "100")
+}
+
+func testNestedScope(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	return store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+		err := store.UnitOfWork.Do(ctx, scope(tenantID, newTenant()), func(ctx context.Context) error {
+			return errors.New("nested unit of work ran outside the outer scope")
+		})
+		if err := expectError("nested unit of work with another tenant", err, repository.ErrNestedTx); err != nil {
+			return err
+		}
+
+		err = store.UnitOfWork.Do(ctx, repository.TxOptions{Isolation: repository.Serializable}, func(ctx context.Context) error {
+			return errors.New("nested unit of work changed the isolation")
+		})
+		return expectError("nested unit of work with another isolation", err, repository.ErrNestedTx)
+	})
+}
+
+func testLockBlocks(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	holder := make(chan error, 1)
+	go func() {
+		holder <- store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+			if _, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1); err != nil {
+				return err
+			}
+			close(locked)
+			<-release
+			return store.Accounts.UpdateBalance(ctx, tenantID, 1, decimal.RequireFromString("60"))
+		})
+	}()
+
+	select {
+	case <-locked:
+	case err := <-holder:
+		return fmt.Errorf("holding transaction failed: %v", err)
+	}
+
+	type result struct {
+		account *models.Account
+		err     error
+	}
+	waiter := make(chan result, 1)
+	go func() {
+		var r result
+		r.err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+			var err error
+			r.account, err = store.Accounts.GetByIDForUpdate(ctx, tenantID, 1)
+			return err
+		})
+		waiter <- r
+	}()
+
+	select {
+	case r := <-waiter:
+		close(release)
+		return fmt.Errorf("second transaction locked the account while it was held (err %v)", r.err)
+	case <-time.After(blockedFor):
+	}
+
+	close(release)
+	if err := <-holder; err != nil {
+		return fmt.Errorf("holding transaction failed: %w", err)
+	}
+
+	select {
+	case r := <-waiter:
+		if r.err != nil {
+			return fmt.Errorf("waiting transaction failed: %w", r.err)
+		}
+		if !r.account.Balance.Equal(decimal.RequireFromString("60")) {
+			return fmt.Errorf("waiting transaction sees balance %s, want the committed 60", r.account.Balance)
+		}
+		return nil
+	case <-time.After(10 * blockedFor):
+		return errors.New("lock was not released by the commit")
+	}
+}
+
+func testLockWaitCancel(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	return store.UnitOfWork.Do(ctx, scope(tenantID), func(holderCtx context.Context) error {
+		if _, err := store.Accounts.GetByIDForUpdate(holderCtx, tenantID, 1); err != nil {
+			return err
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, blockedFor)
+		defer cancel()
+
+		start := time.Now()
+		err := store.UnitOfWork.Do(waitCtx, scope(tenantID), func(ctx context.Context) error {
+			_, err := store.Accounts.GetByIDForUpdate(ctx, tenantID, 1)
+			return err
+		})
+		if err == nil {
+			return errors.New("locked an account held by another transaction")
+		}
+		if elapsed := time.Since(start); elapsed > 10*blockedFor {
+			return fmt.Errorf("lock wait ignored the context deadline, returned after %s", elapsed)
+		}
+		return nil
+	})
+}
+
+func testStaleVersion(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	return store.UnitOfWork.Do(ctx, scope(tenantID), func(txCtx context.Context) error {
+		account, err := store.Accounts.GetByID(txCtx, tenantID, 1)
+		if err != nil {
+			return err
+		}
+
+		// another transfer changes the account in between
+		err = store.UnitOfWork.Do(ctx, scope(tenantID), func(ctx context.Context) error {
+			return store.Accounts.UpdateBalance(ctx, tenantID, 1, decimal.RequireFromString("50"))
+		})
+		if err != nil {
+			return fmt.Errorf("concurrent update: %w", err)
+		}
+
+		err = store.Accounts.UpdateBalanceIfVersion(txCtx, tenantID, 1, account.Balance.Sub(decimal.RequireFromString("10")), account.Version)
+		return expectError("update at the version read before the concurrent commit", err, repository.ErrVersionConflict)
+	})
+}
+
+func testSerializableIncrements(ctx context.Context, store *repository.Store) error {
+	tenantID := newTenant()
+	if err := createAccount(ctx, store, tenantID, 1, "100"); err != nil {
+		return err
+	}
+
+	const workers, increments = 2, 5
+	opts := repository.TxOptions{TenantIDs: []string{tenantID}, Isolation: repository.Serializable}
+	increment := func(ctx context.Context) error {
+		account, err := store.Accounts.GetByID(ctx, tenantID, 1)
+		if err != nil {
+			return err
+		}
+		return store.Accounts.UpdateBalance(ctx, tenantID, 1, account.Balance.Add(decimal.NewFromInt(1)))
+	}
+
+	errs := make(chan error, workers)
+	for range workers {
+		go func() {
+			for range increments {
+				// run again whatever the database aborted, like the transfer service
+				for {
+					err := store.UnitOfWork.Do(ctx, opts, increment)
+					if errors.Is(err, repository.ErrSerializationFailure) || errors.Is(err, repository.ErrDeadlock) {
+						continue
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					break
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for range workers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return expectBalance(ctx, store, tenantID, 1, fmt.Sprint(100+workers*increments))
+}
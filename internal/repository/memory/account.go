@@ -0,0 +1,466 @@
+package memory
+
+import (
+	"context"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type accountRepository struct {
+	db *database
+}
+
+// create a new account
+func (r *accountRepository) Create(ctx context.Context, account *models.Account) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	key := accountKey{account.TenantID, account.AccountID}
+	if _, ok := r.db.accounts[key]; ok {
+		return models.ErrAccountExists
+	}
+
+	now := time.Now()
+	row := &accountRow{
+		account:        *account,
+		initialBalance: account.Balance,
+		delegates:      make(map[string]bool),
+	}
+	row.account.CreatedAt = now
+	row.account.UpdatedAt = now
+	r.db.accounts[key] = row
+
+	return nil
+}
+
+// get an account by ID. Inside a unit of work its own balance updates are
+// visible, and serializable ones lock the account until they end.
+func (r *accountRepository) GetByID(ctx context.Context, tenantID string, accountID int64) (*models.Account, error) {
+	t, inTx := txFromContext(ctx)
+	if inTx && t.isolation == repository.Serializable {
+		return r.GetByIDForUpdate(ctx, tenantID, accountID)
+	}
+	return r.read(t, accountKey{tenantID, accountID})
+}
+
+// get an account by ID and lock it until the unit of work running in ctx
+// ends, waiting for other transactions holding the lock. Outside a unit of
+// work the lock is released right away. Hot accounts are returned without a
+// lock, their shards are locked instead.
+func (r *accountRepository) GetByIDForUpdate(ctx context.Context, tenantID string, accountID int64) (*models.Account, error) {
+	key := accountKey{tenantID, accountID}
+	t, inTx := txFromContext(ctx)
+	if account, err := r.read(t, key); err != nil || account.Shards > 0 {
+		return account, err
+	}
+
+	if !inTx {
+		l := r.db.lock(key.row())
+		if err := l.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer l.release()
+		return r.read(nil, key)
+	}
+
+	if !t.sees(tenantID) {
+		return nil, models.ErrAccountNotFound
+	}
+	if err := t.lockRow(ctx, key.row()); err != nil {
+		return nil, err
+	}
+	return r.read(t, key)
+}
+
+// update the balance, locking the account. Inside a unit of work the update
+// is applied when it commits.
+func (r *accountRepository) UpdateBalance(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal) error {
+	return r.update(ctx, accountKey{tenantID, accountID}, newBalance, nil)
+}
+
+// update the balance only if the account is still at the version it was
+// read at, otherwise return ErrVersionConflict. The account stays locked
+// until the unit of work running in ctx ends.
+func (r *accountRepository) UpdateBalanceIfVersion(ctx context.Context, tenantID string, accountID int64, newBalance decimal.Decimal, version int64) error {
+	return r.update(ctx, accountKey{tenantID, accountID}, newBalance, &version)
+}
+
+// the account as seen by the unit of work, or committed when t is nil, with
+// the balance of a hot account summed over its shards
+func (r *accountRepository) read(t *tx, key accountKey) (*models.Account, error) {
+	if t != nil && !t.sees(key.tenantID) {
+		return nil, models.ErrAccountNotFound
+	}
+
+	r.db.mu.Lock()
+	row, ok := r.db.accounts[key]
+	var account models.Account
+	if ok {
+		account = row.account
+	}
+	r.db.mu.Unlock()
+	if !ok {
+		return nil, models.ErrAccountNotFound
+	}
+
+	if t != nil {
+		t.mu.Lock()
+		if update, ok := t.balances[key]; ok {
+			account.Balance = update.balance
+			account.Version = update.version
+		}
+		t.mu.Unlock()
+	}
+
+	shards := r.shards(t, key)
+	for _, balance := range shards {
+		account.Balance = account.Balance.Add(balance)
+	}
+	account.Shards = len(shards)
+	return &account, nil
+}
+
+// the shard balances of an account as seen by the unit of work, or committed
+// when t is nil
+func (r *accountRepository) shards(t *tx, key accountKey) []decimal.Decimal {
+	r.db.mu.Lock()
+	var shards []decimal.Decimal
+	if row, ok := r.db.accounts[key]; ok {
+		shards = slices.Clone(row.shards)
+	}
+	r.db.mu.Unlock()
+
+	if t == nil {
+		return shards
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if resharded, ok := t.reshards[key]; ok {
+		shards = slices.Clone(resharded)
+	}
+	for i := range shards {
+		if balance, ok := t.shards[key.shard(i)]; ok {
+			shards[i] = balance
+		}
+	}
+	return shards
+}
+
+// write a balance, first checking the version when one is given
+func (r *accountRepository) update(ctx context.Context, key accountKey, newBalance decimal.Decimal, version *int64) error {
+	if !r.exists(key) {
+		return models.ErrAccountNotFound
+	}
+
+	t, inTx := txFromContext(ctx)
+	if !inTx {
+		l := r.db.lock(key.row())
+		if err := l.acquire(ctx); err != nil {
+			return err
+		}
+		defer l.release()
+
+		r.db.mu.Lock()
+		defer r.db.mu.Unlock()
+		row := r.db.accounts[key]
+		if version != nil && row.account.Version != *version {
+			return repository.ErrVersionConflict
+		}
+		row.account.Balance = newBalance
+		row.account.Version++
+		row.account.UpdatedAt = time.Now()
+		return nil
+	}
+
+	if !t.sees(key.tenantID) {
+		return models.ErrAccountNotFound
+	}
+	if err := t.lockRow(ctx, key.row()); err != nil {
+		return err
+	}
+
+	current, err := r.read(t, key)
+	if err != nil {
+		return err
+	}
+	if version != nil && current.Version != *version {
+		return repository.ErrVersionConflict
+	}
+
+	t.mu.Lock()
+	t.balances[key] = balanceUpdate{newBalance, current.Version + 1}
+	t.mu.Unlock()
+
+	return nil
+}
+
+// allow a principal to act on an account
+func (r *accountRepository) AddDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	row, ok := r.db.accounts[accountKey{tenantID, accountID}]
+	if !ok {
+		return models.ErrAccountNotFound
+	}
+
+	row.delegates[principalID] = true
+	return nil
+}
+
+// remove a delegate from an account
+func (r *accountRepository) RemoveDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	row, ok := r.db.accounts[accountKey{tenantID, accountID}]
+	if !ok || !row.delegates[principalID] {
+		return models.ErrDelegateNotFound
+	}
+
+	delete(row.delegates, principalID)
+	return nil
+}
+
+// check whether a principal is a delegate of an account
+func (r *accountRepository) IsDelegate(ctx context.Context, tenantID string, accountID int64, principalID string) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	row, ok := r.db.accounts[accountKey{tenantID, accountID}]
+	return ok && row.delegates[principalID], nil
+}
+
+// compare every account of the tenant with the balance implied by its
+// initial balance and completed transfers
+func (r *accountRepository) Reconcile(ctx context.Context, tenantID string) ([]models.ReconciliationEntry, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	expected := make(map[int64]decimal.Decimal)
+	for key, row := range r.db.accounts {
+		if key.tenantID == tenantID {
+			expected[key.accountID] = row.initialBalance
+		}
+	}
+
+	for _, t := range r.db.transactions {
+		if t.Status != models.TransactionStatusCompleted {
+			continue
+		}
+		if t.DestinationTenantID == tenantID {
+			expected[t.DestinationAccountID] = expected[t.DestinationAccountID].Add(t.Amount)
+		}
+		if t.TenantID == tenantID {
+			expected[t.SourceAccountID] = expected[t.SourceAccountID].Sub(t.Amount)
+		}
+	}
+
+	var entries []models.ReconciliationEntry
+	for key, row := range r.db.accounts {
+		if key.tenantID != tenantID {
+			continue
+		}
+		balance := row.account.Balance
+		for _, shard := range row.shards {
+			balance = balance.Add(shard)
+		}
+		entries = append(entries, models.ReconciliationEntry{
+			AccountID: key.accountID,
+			Balance:   balance,
+			Expected:  expected[key.accountID],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccountID < entries[j].AccountID
+	})
+
+	return entries, nil
+}
+
+// lock a shard of a hot account until the unit of work running in ctx ends,
+// returning ErrShardNotFound if the account has no such shard
+func (r *accountRepository) GetShardForUpdate(ctx context.Context, tenantID string, accountID int64, shard int) (*models.AccountShard, error) {
+	key := accountKey{tenantID, accountID}
+
+	var found *models.AccountShard
+	err := r.inTx(ctx, key, func(t *tx) error {
+		balance, err := r.lockShard(ctx, t, key, shard)
+		if err != nil {
+			return err
+		}
+		found = &models.AccountShard{Shard: shard, Balance: balance}
+		return nil
+	})
+	return found, err
+}
+
+// lock a shard for t and return its balance
+func (r *accountRepository) lockShard(ctx context.Context, t *tx, key accountKey, shard int) (decimal.Decimal, error) {
+	if shard < 0 || shard >= len(r.shards(t, key)) {
+		return decimal.Zero, repository.ErrShardNotFound
+	}
+	if err := t.lockRow(ctx, key.shard(shard)); err != nil {
+		return decimal.Zero, err
+	}
+
+	// resharded while waiting for the lock
+	shards := r.shards(t, key)
+	if shard >= len(shards) {
+		return decimal.Zero, repository.ErrShardNotFound
+	}
+	return shards[shard], nil
+}
+
+// lock a random shard of a hot account holding at least amount, skipping
+// shards other transactions hold instead of waiting for them. Returns
+// ErrShardNotFound when no free shard holds enough.
+func (r *accountRepository) GetFundedShardForUpdate(ctx context.Context, tenantID string, accountID int64, amount decimal.Decimal) (*models.AccountShard, error) {
+	key := accountKey{tenantID, accountID}
+
+	var found *models.AccountShard
+	err := r.inTx(ctx, key, func(t *tx) error {
+		for _, shard := range rand.Perm(len(r.shards(t, key))) {
+			t.mu.Lock()
+			_, held := t.locks[key.shard(shard)]
+			t.mu.Unlock()
+
+			locked, err := t.tryLockRow(key.shard(shard))
+			if err != nil {
+				return err
+			}
+			if !locked {
+				continue
+			}
+
+			shards := r.shards(t, key)
+			if shard < len(shards) && shards[shard].GreaterThanOrEqual(amount) {
+				found = &models.AccountShard{Shard: shard, Balance: shards[shard]}
+				return nil
+			}
+			if !held {
+				t.unlockRow(key.shard(shard))
+			}
+		}
+		return repository.ErrShardNotFound
+	})
+	return found, err
+}
+
+// lock every shard of a hot account in shard order, waiting for other
+// transactions holding them
+func (r *accountRepository) ListShardsForUpdate(ctx context.Context, tenantID string, accountID int64) ([]models.AccountShard, error) {
+	key := accountKey{tenantID, accountID}
+
+	var found []models.AccountShard
+	err := r.inTx(ctx, key, func(t *tx) error {
+		n := len(r.shards(t, key))
+		for shard := range n {
+			if err := t.lockRow(ctx, key.shard(shard)); err != nil {
+				return err
+			}
+		}
+
+		// like postgres, leave out shards removed while waiting
+		for shard, balance := range r.shards(t, key) {
+			if shard < n {
+				found = append(found, models.AccountShard{Shard: shard, Balance: balance})
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// update the balance of a shard, locking it. Inside a unit of work the update
+// is applied when it commits.
+func (r *accountRepository) UpdateShardBalance(ctx context.Context, tenantID string, accountID int64, shard int, newBalance decimal.Decimal) error {
+	key := accountKey{tenantID, accountID}
+	return r.inTx(ctx, key, func(t *tx) error {
+		if _, err := r.lockShard(ctx, t, key, shard); err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		t.shards[key.shard(shard)] = newBalance
+		t.mu.Unlock()
+		return nil
+	})
+}
+
+// spread the balance of an account evenly over the given number of shards,
+// or move it back into the account row for 0, locking the account and all
+// its shards
+func (r *accountRepository) SetShards(ctx context.Context, tenantID string, accountID int64, shards int) (*models.Account, error) {
+	key := accountKey{tenantID, accountID}
+
+	var account *models.Account
+	err := r.inTx(ctx, key, func(t *tx) error {
+		// transfers of unsharded accounts hold the account row, those of hot
+		// accounts their shards. Reshards hold the account row too, so the
+		// number of shards cannot change after locking it.
+		if err := t.lockRow(ctx, key.row()); err != nil {
+			return err
+		}
+		current := len(r.shards(t, key))
+		for shard := range max(current, shards) {
+			if err := t.lockRow(ctx, key.shard(shard)); err != nil {
+				return err
+			}
+		}
+
+		before, err := r.read(t, key)
+		if err != nil {
+			return err
+		}
+
+		balance := before.Balance
+		if shards > 0 {
+			balance = decimal.Zero
+		}
+
+		t.mu.Lock()
+		t.balances[key] = balanceUpdate{balance, before.Version + 1}
+		t.reshards[key] = repository.SplitBalance(before.Balance, shards)
+		for shard := range current {
+			delete(t.shards, key.shard(shard))
+		}
+		t.mu.Unlock()
+
+		account, err = r.read(t, key)
+		return err
+	})
+	return account, err
+}
+
+// run fn in the transaction of the unit of work running in ctx, or else in
+// one of its own that commits right away, like a single statement outside a
+// unit of work in postgres
+func (r *accountRepository) inTx(ctx context.Context, key accountKey, fn func(t *tx) error) error {
+	if t, ok := txFromContext(ctx); ok {
+		if !t.sees(key.tenantID) || !r.exists(key) {
+			return models.ErrAccountNotFound
+		}
+		return fn(t)
+	}
+
+	u := &unitOfWork{db: r.db}
+	return u.Do(ctx, repository.TxOptions{TenantIDs: []string{key.tenantID}}, func(ctx context.Context) error {
+		return r.inTx(ctx, key, fn)
+	})
+}
+
+func (r *accountRepository) exists(key accountKey) bool {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	_, ok := r.db.accounts[key]
+	return ok
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"internal-transfers/internal/models"
+	"slices"
+	"time"
+)
+
+type apiKeyRepository struct {
+	db *database
+}
+
+// store a new api key, filling in its ID and creation time
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, existing := range r.db.apiKeys {
+		if existing.Prefix == key.Prefix {
+			return fmt.Errorf("api key prefix %q already exists", key.Prefix)
+		}
+	}
+
+	r.db.nextAPIKeyID++
+	key.ID = r.db.nextAPIKeyID
+	key.CreatedAt = time.Now()
+
+	stored := *key
+	stored.Scopes = slices.Clone(key.Scopes)
+	r.db.apiKeys[key.ID] = &stored
+
+	return nil
+}
+
+// get an api key by its public prefix
+func (r *apiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, key := range r.db.apiKeys {
+		if key.Prefix == prefix {
+			found := *key
+			found.Scopes = slices.Clone(key.Scopes)
+			return &found, nil
+		}
+	}
+
+	return nil, models.ErrAPIKeyNotFound
+}
+
+// revoke an api key, revoking an already revoked key is a no-op
+func (r *apiKeyRepository) Revoke(ctx context.Context, tenantID string, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	key, ok := r.db.apiKeys[id]
+	if !ok || key.TenantID != tenantID {
+		return models.ErrAPIKeyNotFound
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+	}
+
+	return nil
+}
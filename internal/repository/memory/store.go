@@ -0,0 +1,139 @@
+// Package memory keeps accounts, transfers and api keys in process memory,
+// for local development and demos. It follows the semantics of the postgres
+// repositories: accounts and the shards of hot accounts are locked until the
+// end of the unit of work that read them for update, balance updates and
+// transfer records only become visible on commit, and units of work only see
+// the tenants they were started for.
+//
+// Creating accounts and api keys, queueing transfers and changing delegates
+// always take effect right away, even inside a unit of work, and transfers
+// recorded by a unit of work are only listed once it commits.
+package memory
+
+import (
+	"context"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// account identity within the whole store
+type accountKey struct {
+	tenantID  string
+	accountID int64
+}
+
+// a row that can be locked: an account, or one of its shards
+type rowKey struct {
+	account accountKey
+	shard   int // -1 for the account row itself
+}
+
+func (k accountKey) row() rowKey {
+	return rowKey{k, -1}
+}
+
+func (k accountKey) shard(shard int) rowKey {
+	return rowKey{k, shard}
+}
+
+type accountRow struct {
+	account        models.Account // Balance excludes the shards, Shards is their number
+	initialBalance decimal.Decimal
+	delegates      map[string]bool
+	shards         []decimal.Decimal
+}
+
+// committed state shared by the repositories of a store
+type database struct {
+	mu sync.Mutex
+
+	accounts map[accountKey]*accountRow
+	locks    map[rowKey]*rowLock
+
+	transactions      map[int64]*models.Transaction
+	nextTransactionID int64
+	// reversal_of of completed transfers, including uncommitted ones, like
+	// the unique index in postgres
+	reversals map[int64]bool
+
+	// queued transfers by transaction ID
+	queue map[int64]*queuedTransfer
+	// pending transfers finished by uncommitted units of work, standing in
+	// for the row lock Finish takes in postgres
+	finishing map[int64]bool
+
+	apiKeys      map[int64]*models.APIKey
+	nextAPIKeyID int64
+}
+
+// create an empty store, all data is lost when the process exits
+func NewStore() *repository.Store {
+	db := &database{
+		accounts:     make(map[accountKey]*accountRow),
+		locks:        make(map[rowKey]*rowLock),
+		transactions: make(map[int64]*models.Transaction),
+		reversals:    make(map[int64]bool),
+		queue:        make(map[int64]*queuedTransfer),
+		finishing:    make(map[int64]bool),
+		apiKeys:      make(map[int64]*models.APIKey),
+	}
+
+	return &repository.Store{
+		Accounts:     &accountRepository{db: db},
+		Transactions: &transactionRepository{db: db},
+		APIKeys:      &apiKeyRepository{db: db},
+		UnitOfWork:   &unitOfWork{db: db},
+	}
+}
+
+// a transfer waiting for an async worker
+type queuedTransfer struct {
+	job         models.QueuedTransfer
+	availableAt time.Time // when it can be claimed, pushed back by each lease
+}
+
+// exclusive lock on an account or shard row, held by at most one transaction
+type rowLock struct {
+	held chan struct{}
+}
+
+// get the lock of a row, creating it on first use
+func (db *database) lock(key rowKey) *rowLock {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	l, ok := db.locks[key]
+	if !ok {
+		l = &rowLock{held: make(chan struct{}, 1)}
+		db.locks[key] = l
+	}
+	return l
+}
+
+// wait for the lock, giving up when the context is done
+func (l *rowLock) acquire(ctx context.Context) error {
+	select {
+	case l.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take the lock if it is free, without waiting
+func (l *rowLock) tryAcquire() bool {
+	select {
+	case l.held <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *rowLock) release() {
+	<-l.held
+}
@@ -0,0 +1,309 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type transactionRepository struct {
+	db *database
+}
+
+// create a new transaction record, also setting its CreatedAt. Inside a unit
+// of work it is stored when the unit of work commits, but the ID is assigned
+// right away, like a postgres sequence.
+func (r *transactionRepository) Create(ctx context.Context, transaction *models.Transaction) (int64, error) {
+	t, inTx := txFromContext(ctx)
+
+	// the row level security policy requires both tenants to be in scope
+	tenants := []string{transaction.TenantID}
+	if inTx {
+		tenants = t.tenants
+	}
+	if !slices.Contains(tenants, transaction.TenantID) || !slices.Contains(tenants, transaction.DestinationTenantID) {
+		return 0, fmt.Errorf("transaction between tenants %s and %s is outside the transaction scope", transaction.TenantID, transaction.DestinationTenantID)
+	}
+
+	// locks are always taken in this order, see tx.commit
+	if inTx {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.closed {
+			return 0, errTxClosed
+		}
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	source := accountKey{transaction.TenantID, transaction.SourceAccountID}
+	destination := accountKey{transaction.DestinationTenantID, transaction.DestinationAccountID}
+	if _, ok := r.db.accounts[source]; !ok {
+		return 0, fmt.Errorf("source account %d does not exist", transaction.SourceAccountID)
+	}
+	if _, ok := r.db.accounts[destination]; !ok {
+		return 0, fmt.Errorf("destination account %d does not exist", transaction.DestinationAccountID)
+	}
+
+	reserveReversal := transaction.ReversalOf != nil && transaction.Status == models.TransactionStatusCompleted
+	if reserveReversal && r.db.reversals[*transaction.ReversalOf] {
+		// unlike postgres this does not wait for a concurrent reversal to
+		// commit or roll back
+		return 0, models.ErrAlreadyReversed
+	}
+
+	r.db.nextTransactionID++
+	transaction.TransactionID = r.db.nextTransactionID
+	transaction.CreatedAt = time.Now()
+
+	if reserveReversal {
+		r.db.reversals[*transaction.ReversalOf] = true
+	}
+
+	stored := *transaction
+	if !inTx {
+		r.db.transactions[stored.TransactionID] = &stored
+		return stored.TransactionID, nil
+	}
+
+	if reserveReversal {
+		t.reversals = append(t.reversals, *transaction.ReversalOf)
+	}
+	t.transactions = append(t.transactions, &stored)
+
+	return transaction.TransactionID, nil
+}
+
+// get a transaction by ID, visible to both the source and destination tenant
+func (r *transactionRepository) GetByID(ctx context.Context, tenantID string, transactionID int64) (*models.Transaction, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	transaction, ok := r.db.transactions[transactionID]
+	if !ok || (transaction.TenantID != tenantID && transaction.DestinationTenantID != tenantID) {
+		return nil, models.ErrTransactionNotFound
+	}
+
+	found := *transaction
+	return &found, nil
+}
+
+// list the transfers into and out of an account, newest first. A beforeID of
+// zero starts from the newest transfer.
+func (r *transactionRepository) ListByAccount(ctx context.Context, tenantID string, accountID int64, limit int, beforeID int64) ([]models.Transaction, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	transactions := []models.Transaction{}
+	for id, t := range r.db.transactions {
+		if beforeID != 0 && id >= beforeID {
+			continue
+		}
+		if (t.TenantID == tenantID && t.SourceAccountID == accountID) ||
+			(t.DestinationTenantID == tenantID && t.DestinationAccountID == accountID) {
+			transactions = append(transactions, *t)
+		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].TransactionID > transactions[j].TransactionID
+	})
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+	}
+
+	return transactions, nil
+}
+
+// move the amount of a completed transfer between its accounts and record it
+// in a unit of work of its own, deciding like the single postgres statement:
+// missing and sharded accounts and sources the debitor may not debit return
+// ErrNotExecuted, and a source that cannot cover the amount records a failed
+// transfer and returns ErrInsufficientBalance.
+func (r *transactionRepository) Execute(ctx context.Context, transaction *models.Transaction, debitor *string) error {
+	accounts := &accountRepository{db: r.db}
+	source := accountKey{transaction.TenantID, transaction.SourceAccountID}
+	destination := accountKey{transaction.DestinationTenantID, transaction.DestinationAccountID}
+
+	// the lock order of the transfer service
+	first, second := source, destination
+	if second.tenantID < first.tenantID || (second.tenantID == first.tenantID && second.accountID < first.accountID) {
+		first, second = second, first
+	}
+
+	var insufficient bool
+	u := &unitOfWork{db: r.db}
+	err := u.Do(ctx, repository.TxOptions{TenantIDs: []string{source.tenantID, destination.tenantID}}, func(ctx context.Context) error {
+		t, _ := txFromContext(ctx)
+
+		balances := make(map[accountKey]decimal.Decimal, 2)
+		for _, key := range []accountKey{first, second} {
+			account, err := accounts.read(t, key)
+			if err != nil || account.Shards > 0 {
+				return repository.ErrNotExecuted
+			}
+			if err := t.lockRow(ctx, key.row()); err != nil {
+				return err
+			}
+
+			// sharded while waiting for the lock
+			account, err = accounts.read(t, key)
+			if err != nil || account.Shards > 0 {
+				return repository.ErrNotExecuted
+			}
+			balances[key] = account.Balance
+
+			if key == source && debitor != nil {
+				owner := account.OwnerID != nil && *account.OwnerID == *debitor
+				delegate, err := accounts.IsDelegate(ctx, key.tenantID, key.accountID, *debitor)
+				if err != nil {
+					return err
+				}
+				if !owner && !delegate {
+					return repository.ErrNotExecuted
+				}
+			}
+		}
+
+		if balances[source].LessThan(transaction.Amount) {
+			insufficient = true
+			transaction.Status = models.TransactionStatusFailed
+			_, err := r.Create(ctx, transaction)
+			return err
+		}
+
+		if err := accounts.UpdateBalance(ctx, source.tenantID, source.accountID, balances[source].Sub(transaction.Amount)); err != nil {
+			return err
+		}
+		if err := accounts.UpdateBalance(ctx, destination.tenantID, destination.accountID, balances[destination].Add(transaction.Amount)); err != nil {
+			return err
+		}
+
+		transaction.Status = models.TransactionStatusCompleted
+		transaction.ErrorMessage = nil
+		_, err := r.Create(ctx, transaction)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if insufficient {
+		return models.ErrInsufficientBalance
+	}
+	return nil
+}
+
+// record a pending transfer and queue it for the async workers, right away
+// even inside a unit of work
+func (r *transactionRepository) Enqueue(ctx context.Context, transaction *models.Transaction, roles []string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, key := range []accountKey{
+		{transaction.TenantID, transaction.SourceAccountID},
+		{transaction.DestinationTenantID, transaction.DestinationAccountID},
+	} {
+		if _, ok := r.db.accounts[key]; !ok {
+			return fmt.Errorf("%w: account_id %d", models.ErrAccountNotFound, key.accountID)
+		}
+	}
+
+	r.db.nextTransactionID++
+	transaction.TransactionID = r.db.nextTransactionID
+	transaction.CreatedAt = time.Now()
+	transaction.Status = models.TransactionStatusPending
+
+	stored := *transaction
+	r.db.transactions[stored.TransactionID] = &stored
+	r.db.queue[stored.TransactionID] = &queuedTransfer{
+		job: models.QueuedTransfer{
+			TransactionID:  stored.TransactionID,
+			TenantID:       stored.TenantID,
+			PrincipalRoles: slices.Clone(roles),
+		},
+		availableAt: stored.CreatedAt,
+	}
+	return nil
+}
+
+// claim the oldest due transfer of any tenant until the lease runs out
+func (r *transactionRepository) Claim(ctx context.Context, lease time.Duration) (*models.QueuedTransfer, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	now := time.Now()
+	var due *queuedTransfer
+	for _, q := range r.db.queue {
+		if q.availableAt.After(now) {
+			continue
+		}
+		if due == nil || q.availableAt.Before(due.availableAt) ||
+			(q.availableAt.Equal(due.availableAt) && q.job.TransactionID < due.job.TransactionID) {
+			due = q
+		}
+	}
+	if due == nil {
+		return nil, repository.ErrQueueEmpty
+	}
+
+	due.job.Attempts++
+	due.availableAt = now.Add(lease)
+	job := due.job
+	job.PrincipalRoles = slices.Clone(job.PrincipalRoles)
+	return &job, nil
+}
+
+// record the outcome of a pending transfer and remove it from the queue, on
+// commit inside a unit of work. A transfer another unit of work is finishing
+// is reported as not pending without waiting for it; if that one rolls back
+// the transfer stays queued and is claimed again.
+func (r *transactionRepository) Finish(ctx context.Context, transaction *models.Transaction) error {
+	t, inTx := txFromContext(ctx)
+	if inTx {
+		if !t.sees(transaction.TenantID) || !t.sees(transaction.DestinationTenantID) {
+			return fmt.Errorf("transaction between tenants %s and %s is outside the transaction scope", transaction.TenantID, transaction.DestinationTenantID)
+		}
+
+		// locks are always taken in this order, see tx.commit
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.closed {
+			return errTxClosed
+		}
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	id := transaction.TransactionID
+	stored, ok := r.db.transactions[id]
+	if !ok || stored.Status != models.TransactionStatusPending || r.db.finishing[id] {
+		if !inTx {
+			delete(r.db.queue, id)
+		}
+		return repository.ErrNotPending
+	}
+
+	finished := *stored
+	finished.Status = transaction.Status
+	finished.ErrorMessage = transaction.ErrorMessage
+	transaction.CreatedAt = finished.CreatedAt
+
+	if !inTx {
+		r.db.transactions[id] = &finished
+		delete(r.db.queue, id)
+		return nil
+	}
+
+	r.db.finishing[id] = true
+	t.finished = append(t.finished, id)
+	t.transactions = append(t.transactions, &finished)
+	return nil
+}
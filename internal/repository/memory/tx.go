@@ -0,0 +1,292 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// runs read committed and repeatable read the same way: reads outside row
+// locks see the latest committed state. Serializable units of work also lock
+// every account they read until they end, which makes them serializable
+// without the aborts postgres uses.
+type unitOfWork struct {
+	db *database
+}
+
+type txKey struct{}
+
+// returned when the context of a finished unit of work is used to write
+var errTxClosed = errors.New("unit of work has ended")
+
+func (u *unitOfWork) Do(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if outer, ok := ctx.Value(txKey{}).(*tx); ok {
+		if err := repository.CheckNested(opts, outer.tenants, outer.isolation); err != nil {
+			return err
+		}
+		sp := outer.savepoint()
+		return run(ctx, outer, fn, func(ok bool) {
+			if !ok {
+				outer.rollbackTo(sp)
+			}
+		})
+	}
+
+	isolation := opts.Isolation
+	if isolation == "" {
+		isolation = repository.ReadCommitted
+	}
+	t := &tx{
+		db:        u.db,
+		tenants:   opts.TenantIDs,
+		isolation: isolation,
+		locks:     make(map[rowKey]*rowLock),
+		balances:  make(map[accountKey]balanceUpdate),
+		shards:    make(map[rowKey]decimal.Decimal),
+		reshards:  make(map[accountKey][]decimal.Decimal),
+	}
+	return run(ctx, t, fn, func(ok bool) {
+		if ok {
+			t.commit()
+		} else {
+			t.rollback()
+		}
+	})
+}
+
+// call fn in the transaction and end it, or its savepoint, with the outcome
+func run(ctx context.Context, t *tx, fn func(ctx context.Context) error, end func(ok bool)) error {
+	defer func() {
+		if p := recover(); p != nil {
+			end(false)
+			panic(p)
+		}
+	}()
+
+	err := fn(context.WithValue(ctx, txKey{}, t))
+	end(err == nil)
+	return err
+}
+
+// pending writes and held row locks, applied or discarded when the
+// transaction ends
+type tx struct {
+	db        *database
+	tenants   []string
+	isolation repository.IsolationLevel
+
+	mu           sync.Mutex // taken before database.mu
+	closed       bool
+	locks        map[rowKey]*rowLock
+	balances     map[accountKey]balanceUpdate
+	shards       map[rowKey]decimal.Decimal       // shard balances
+	reshards     map[accountKey][]decimal.Decimal // new shards, before the balances in shards
+	transactions []*models.Transaction
+	reversals    []int64 // reserved in database.reversals
+	finished     []int64 // queued transfers reserved in database.finishing
+}
+
+// pending balance of an account and the version it gets on commit
+type balanceUpdate struct {
+	balance decimal.Decimal
+	version int64
+}
+
+// state of a transaction a savepoint can return to
+type savepoint struct {
+	locks        map[rowKey]bool
+	balances     map[accountKey]balanceUpdate
+	shards       map[rowKey]decimal.Decimal
+	reshards     map[accountKey][]decimal.Decimal
+	transactions int
+	reversals    int
+	finished     int
+}
+
+// the transaction of the unit of work running in ctx, if any
+func txFromContext(ctx context.Context) (*tx, bool) {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	return t, ok
+}
+
+// whether rows of the tenant are visible, like the row level security
+// policies in postgres
+func (t *tx) sees(tenantID string) bool {
+	return slices.Contains(t.tenants, tenantID)
+}
+
+// lock a row until the transaction ends, a no-op if already held
+func (t *tx) lockRow(ctx context.Context, key rowKey) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return errTxClosed
+	}
+	_, held := t.locks[key]
+	t.mu.Unlock()
+	if held {
+		return nil
+	}
+
+	l := t.db.lock(key)
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		l.release()
+		return errTxClosed
+	}
+	t.locks[key] = l
+	return nil
+}
+
+// lock a row until the transaction ends if no other transaction holds it,
+// reporting whether it is held now
+func (t *tx) tryLockRow(key rowKey) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false, errTxClosed
+	}
+	if _, held := t.locks[key]; held {
+		return true, nil
+	}
+
+	l := t.db.lock(key)
+	if !l.tryAcquire() {
+		return false, nil
+	}
+	t.locks[key] = l
+	return true, nil
+}
+
+// release a row lock taken by the transaction before it wrote the row
+func (t *tx) unlockRow(key rowKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.locks[key]; ok {
+		delete(t.locks, key)
+		l.release()
+	}
+}
+
+func (t *tx) savepoint() savepoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	held := make(map[rowKey]bool, len(t.locks))
+	for key := range t.locks {
+		held[key] = true
+	}
+	return savepoint{
+		locks:        held,
+		balances:     maps.Clone(t.balances),
+		shards:       maps.Clone(t.shards),
+		reshards:     maps.Clone(t.reshards),
+		transactions: len(t.transactions),
+		reversals:    len(t.reversals),
+		finished:     len(t.finished),
+	}
+}
+
+// undo the writes made since the savepoint and release the row locks taken
+// since, like postgres does on ROLLBACK TO SAVEPOINT
+func (t *tx) rollbackTo(sp savepoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, l := range t.locks {
+		if !sp.locks[key] {
+			delete(t.locks, key)
+			l.release()
+		}
+	}
+	t.balances = sp.balances
+	t.shards = sp.shards
+	t.reshards = sp.reshards
+	t.transactions = t.transactions[:sp.transactions]
+
+	t.db.mu.Lock()
+	for _, id := range t.reversals[sp.reversals:] {
+		delete(t.db.reversals, id)
+	}
+	for _, id := range t.finished[sp.finished:] {
+		delete(t.db.finishing, id)
+	}
+	t.db.mu.Unlock()
+	t.reversals = t.reversals[:sp.reversals]
+	t.finished = t.finished[:sp.finished]
+}
+
+func (t *tx) commit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	t.db.mu.Lock()
+	for key, update := range t.balances {
+		row := t.db.accounts[key]
+		row.account.Balance = update.balance
+		row.account.Version = update.version
+		row.account.UpdatedAt = now
+	}
+	for key, shards := range t.reshards {
+		row := t.db.accounts[key]
+		row.shards = shards
+		row.account.Shards = len(shards)
+	}
+	for key, balance := range t.shards {
+		t.db.accounts[key.account].shards[key.shard] = balance
+	}
+	for _, transaction := range t.transactions {
+		t.db.transactions[transaction.TransactionID] = transaction
+	}
+	for _, id := range t.finished {
+		delete(t.db.finishing, id)
+		delete(t.db.queue, id)
+	}
+	t.db.mu.Unlock()
+
+	t.releaseLocks()
+}
+
+func (t *tx) rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.db.mu.Lock()
+	for _, id := range t.reversals {
+		delete(t.db.reversals, id)
+	}
+	for _, id := range t.finished {
+		delete(t.db.finishing, id)
+	}
+	t.db.mu.Unlock()
+
+	t.releaseLocks()
+}
+
+// t.mu must be held
+func (t *tx) releaseLocks() {
+	for _, l := range t.locks {
+		l.release()
+	}
+	t.locks = nil
+	t.closed = true
+}
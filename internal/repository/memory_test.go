@@ -0,0 +1,11 @@
+package repository_test
+
+import (
+	"internal-transfers/internal/repository/conformance"
+	"internal-transfers/internal/repository/memory"
+	"testing"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	conformance.Test(t, memory.NewStore())
+}
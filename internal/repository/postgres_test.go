@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"internal-transfers/internal/migrate"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/conformance"
+	"internal-transfers/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// connection string of the database the Postgres tests migrate and write to
+const testDatabaseEnv = "TEST_DATABASE_URL"
+
+func TestPostgresStoreConformance(t *testing.T) {
+	url := os.Getenv(testDatabaseEnv)
+	if url == "" {
+		t.Skip(testDatabaseEnv + " is not set")
+	}
+
+	db, err := pgxpool.New(t.Context(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	migrator, err := migrate.New(db, migrations.FS, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	conformance.Test(t, repository.NewPostgresStore(db, repository.ReadCommitted))
+}
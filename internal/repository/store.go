@@ -0,0 +1,21 @@
+package repository
+
+import "github.com/jackc/pgx/v5/pgxpool"
+
+// repositories sharing one storage backend, with the unit of work their
+// calls can join
+type Store struct {
+	Accounts     AccountRepository
+	Transactions TransactionRepository
+	APIKeys      APIKeyRepository
+	UnitOfWork   UnitOfWork
+}
+
+func NewPostgresStore(db *pgxpool.Pool, isolation IsolationLevel) *Store {
+	return &Store{
+		Accounts:     NewAccountRepository(db),
+		Transactions: NewTransactionRepository(db),
+		APIKeys:      NewAPIKeyRepository(db),
+		UnitOfWork:   NewUnitOfWork(db, isolation),
+	}
+}
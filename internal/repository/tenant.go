@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// scope the row level security policies to the tenants for the rest of the
+// current transaction
+const setTenantsQuery = `SELECT set_config('app.tenant_ids', $1, true)`
+
+// run a single statement scoped to the tenant. The scope and the statement
+// are sent as one batch, which runs as an implicit transaction, so this costs
+// no extra round trip. Inside a unit of work the statement joins its
+// transaction, which is already scoped.
+func queryRowScoped(ctx context.Context, db *pgxpool.Pool, tenantID string, scan func(pgx.Row) error, query string, args ...any) error {
+	if tx, ok := txFromContext(ctx); ok {
+		return scan(tx.QueryRow(ctx, query, args...))
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(setTenantsQuery, tenantID)
+	batch.Queue(query, args...)
+
+	results := db.SendBatch(ctx, batch)
+	defer results.Close()
+
+	if _, err := results.Exec(); err != nil {
+		return fmt.Errorf("failed to set tenant scope: %w", err)
+	}
+	if err := scan(results.QueryRow()); err != nil {
+		return err
+	}
+	return results.Close()
+}
+
+// run a query scoped to the tenant, calling scan for every row, see
+// queryRowScoped
+func queryScoped(ctx context.Context, db *pgxpool.Pool, tenantID string, scan func(pgx.Rows) error, query string, args ...any) error {
+	if tx, ok := txFromContext(ctx); ok {
+		rows, err := tx.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		return scanRows(rows, scan)
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(setTenantsQuery, tenantID)
+	batch.Queue(query, args...)
+
+	results := db.SendBatch(ctx, batch)
+	defer results.Close()
+
+	if _, err := results.Exec(); err != nil {
+		return fmt.Errorf("failed to set tenant scope: %w", err)
+	}
+
+	rows, err := results.Query()
+	if err != nil {
+		return err
+	}
+	if err := scanRows(rows, scan); err != nil {
+		return err
+	}
+	return results.Close()
+}
+
+// call scan for every row and close rows
+func scanRows(rows pgx.Rows, scan func(pgx.Rows) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// execute a single statement scoped to the tenant, see queryRowScoped
+func execScoped(ctx context.Context, db *pgxpool.Pool, tenantID string, query string, args ...any) (pgconn.CommandTag, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Exec(ctx, query, args...)
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(setTenantsQuery, tenantID)
+	batch.Queue(query, args...)
+
+	results := db.SendBatch(ctx, batch)
+	defer results.Close()
+
+	if _, err := results.Exec(); err != nil {
+		return pgconn.CommandTag{}, fmt.Errorf("failed to set tenant scope: %w", err)
+	}
+	tag, err := results.Exec()
+	if err != nil {
+		return tag, err
+	}
+	return tag, results.Close()
+}
+
+// check whether the connected role ignores row level security, which is the
+// case for superusers and roles with BYPASSRLS
+func BypassesRowLevelSecurity(ctx context.Context, db *pgxpool.Pool) (bool, error) {
+	query := `
+		SELECT rolsuper OR rolbypassrls
+		FROM pg_roles
+		WHERE rolname = current_user
+	`
+
+	var bypass bool
+	err := db.QueryRow(ctx, query).Scan(&bypass)
+	return bypass, err
+}
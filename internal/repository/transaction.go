@@ -2,17 +2,38 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"internal-transfers/internal/models"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type TransactionRepository interface {
-	Create(ctx context.Context, tx pgx.Tx, transaction *models.Transaction) (int64, error)
-	GetByID(ctx context.Context, transactionID int64) (*models.Transaction, error)
+	Create(ctx context.Context, transaction *models.Transaction) (int64, error)
+	GetByID(ctx context.Context, tenantID string, transactionID int64) (*models.Transaction, error)
+	ListByAccount(ctx context.Context, tenantID string, accountID int64, limit int, beforeID int64) ([]models.Transaction, error)
+	Execute(ctx context.Context, transaction *models.Transaction, debitor *string) error
+	Enqueue(ctx context.Context, transaction *models.Transaction, roles []string) error
+	Claim(ctx context.Context, lease time.Duration) (*models.QueuedTransfer, error)
+	Finish(ctx context.Context, transaction *models.Transaction) error
 }
 
+// returned by Execute when it cannot decide a transfer on its own: an account
+// is missing or sharded, or the debitor may not debit the source. Nothing
+// was written.
+var ErrNotExecuted = errors.New("transfer cannot be executed as a single statement")
+
+// returned by Claim when no queued transfer is due
+var ErrQueueEmpty = errors.New("no queued transfer is due")
+
+// returned by Finish when the transfer is no longer pending, because another
+// worker finished it
+var ErrNotPending = errors.New("transfer is not pending")
+
 type transactionRepository struct {
 	db *pgxpool.Pool
 }
@@ -21,68 +42,387 @@ func NewTransactionRepository(db *pgxpool.Pool) TransactionRepository {
 	return &transactionRepository{db: db}
 }
 
-// create a new transaction record
-func (r *transactionRepository) Create(ctx context.Context, tx pgx.Tx, transaction *models.Transaction) (int64, error) {
+const transactionColumns = `
+	transaction_id,
+	tenant_id,
+	source_account_id,
+	destination_tenant_id,
+	destination_account_id,
+	amount,
+	status,
+	created_at,
+	error_message,
+	initiated_by,
+	request_id,
+	reversal_of
+`
+
+// scan a row selected with transactionColumns
+func scanTransaction(row pgx.Row, transaction *models.Transaction) error {
+	return row.Scan(
+		&transaction.TransactionID,
+		&transaction.TenantID,
+		&transaction.SourceAccountID,
+		&transaction.DestinationTenantID,
+		&transaction.DestinationAccountID,
+		&transaction.Amount,
+		&transaction.Status,
+		&transaction.CreatedAt,
+		&transaction.ErrorMessage,
+		&transaction.InitiatedBy,
+		&transaction.RequestID,
+		&transaction.ReversalOf,
+	)
+}
+
+// create a new transaction record, also setting its CreatedAt
+func (r *transactionRepository) Create(ctx context.Context, transaction *models.Transaction) (int64, error) {
 	query := `
 		INSERT INTO transactions (
-			source_account_id, 
-			destination_account_id, 
-			amount, 
-			status, 
+			tenant_id,
+			source_account_id,
+			destination_tenant_id,
+			destination_account_id,
+			amount,
+			status,
 			created_at,
-			error_message
+			error_message,
+			initiated_by,
+			request_id,
+			reversal_of
 		)
-		VALUES ($1, $2, $3, $4, NOW(), $5)
-		RETURNING transaction_id
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, $8, $9, $10)
+		RETURNING transaction_id, created_at
 	`
 
-	var transactionID int64
-	err := tx.QueryRow(
-		ctx,
-		query,
+	args := []any{
+		transaction.TenantID,
 		transaction.SourceAccountID,
+		transaction.DestinationTenantID,
 		transaction.DestinationAccountID,
 		transaction.Amount,
 		transaction.Status,
 		transaction.ErrorMessage,
-	).Scan(&transactionID)
+		transaction.InitiatedBy,
+		transaction.RequestID,
+		transaction.ReversalOf,
+	}
+
+	var transactionID int64
+	err := queryRowScoped(ctx, r.db, transaction.TenantID, func(row pgx.Row) error {
+		return row.Scan(&transactionID, &transaction.CreatedAt)
+	}, query, args...)
 
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" && pgErr.ConstraintName == "idx_transactions_reversal_of" {
+				return 0, models.ErrAlreadyReversed
+			}
+		}
 		return 0, err
 	}
 
 	return transactionID, nil
 }
 
-// get a transaction by ID
-func (r *transactionRepository) GetByID(ctx context.Context, transactionID int64) (*models.Transaction, error) {
+// get a transaction by ID, visible to both the source and destination tenant
+func (r *transactionRepository) GetByID(ctx context.Context, tenantID string, transactionID int64) (*models.Transaction, error) {
 	query := `
-		SELECT 
-			transaction_id,
-			source_account_id,
-			destination_account_id,
-			amount,
-			status,
-			created_at,
-			error_message
+		SELECT ` + transactionColumns + `
 		FROM transactions
 		WHERE transaction_id = $1
+		  AND (tenant_id = $2 OR destination_tenant_id = $2)
 	`
 
 	var transaction models.Transaction
-	err := r.db.QueryRow(ctx, query, transactionID).Scan(
-		&transaction.TransactionID,
-		&transaction.SourceAccountID,
-		&transaction.DestinationAccountID,
-		&transaction.Amount,
-		&transaction.Status,
-		&transaction.CreatedAt,
-		&transaction.ErrorMessage,
-	)
+	err := queryRowScoped(ctx, r.db, tenantID, func(row pgx.Row) error {
+		return scanTransaction(row, &transaction)
+	}, query, transactionID, tenantID)
 
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrTransactionNotFound
+		}
 		return nil, err
 	}
 
 	return &transaction, nil
 }
+
+// list the transfers into and out of an account, newest first. A beforeID of
+// zero starts from the newest transfer.
+func (r *transactionRepository) ListByAccount(ctx context.Context, tenantID string, accountID int64, limit int, beforeID int64) ([]models.Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE ((tenant_id = $1 AND source_account_id = $2)
+		    OR (destination_tenant_id = $1 AND destination_account_id = $2))
+		  AND ($3::BIGINT = 0 OR transaction_id < $3)
+		ORDER BY transaction_id DESC
+		LIMIT $4
+	`
+
+	transactions := []models.Transaction{}
+	err := queryScoped(ctx, r.db, tenantID, func(rows pgx.Rows) error {
+		var transaction models.Transaction
+		if err := scanTransaction(rows, &transaction); err != nil {
+			return err
+		}
+		transactions = append(transactions, transaction)
+		return nil
+	}, query, tenantID, accountID, beforeID, limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// move the amount of a completed transfer between its accounts and record it,
+// as one statement sent in one round trip. The accounts are locked in the
+// order transfers always lock them. A transfer the source cannot cover is
+// recorded as failed with the error message of the transaction and returns
+// ErrInsufficientBalance. debitor restricts the source to accounts it owns or
+// is a delegate of, nil allows any. The ID and creation time of the record
+// are set in transaction.
+func (r *transactionRepository) Execute(ctx context.Context, transaction *models.Transaction, debitor *string) error {
+	query := `
+		WITH locked AS (
+			SELECT
+				tenant_id = $1 AND account_id = $2 AS is_source,
+				balance,
+				$6::text IS NULL OR owner_id = $6 OR EXISTS (
+					SELECT 1 FROM account_delegates d
+					WHERE d.tenant_id = accounts.tenant_id
+					  AND d.account_id = accounts.account_id
+					  AND d.principal_id = $6
+				) AS allowed
+			FROM accounts
+			WHERE ((tenant_id = $1 AND account_id = $2) OR (tenant_id = $3 AND account_id = $4))
+			  AND shards = 0
+			ORDER BY tenant_id COLLATE "C", account_id
+			FOR UPDATE
+		),
+		outcome AS (
+			SELECT
+				COALESCE(count(*) = 2 AND bool_and(NOT is_source OR allowed), false) AS executable,
+				COALESCE(bool_or(is_source AND balance >= $5), false) AS funded
+			FROM locked
+		),
+		moved AS (
+			UPDATE accounts a
+			SET balance = a.balance + CASE WHEN a.tenant_id = $1 AND a.account_id = $2 THEN -$5::numeric ELSE $5::numeric END,
+			    version = a.version + 1,
+			    updated_at = NOW()
+			FROM outcome o
+			WHERE o.executable AND o.funded
+			  AND ((a.tenant_id = $1 AND a.account_id = $2) OR (a.tenant_id = $3 AND a.account_id = $4))
+		),
+		recorded AS (
+			INSERT INTO transactions (
+				tenant_id,
+				source_account_id,
+				destination_tenant_id,
+				destination_account_id,
+				amount,
+				status,
+				created_at,
+				error_message,
+				initiated_by,
+				request_id,
+				reversal_of
+			)
+			SELECT
+				$1, $2, $3, $4, $5,
+				CASE WHEN o.funded THEN 'completed' ELSE 'failed' END,
+				NOW(),
+				CASE WHEN o.funded THEN NULL ELSE $7::text END,
+				$8::text, $9::text, $10::bigint
+			FROM outcome o
+			WHERE o.executable
+			RETURNING transaction_id, created_at
+		)
+		SELECT o.executable, o.funded, r.transaction_id, r.created_at
+		FROM outcome o
+		LEFT JOIN recorded r ON true
+	`
+
+	args := []any{
+		transaction.TenantID,
+		transaction.SourceAccountID,
+		transaction.DestinationTenantID,
+		transaction.DestinationAccountID,
+		transaction.Amount,
+		debitor,
+		transaction.ErrorMessage,
+		transaction.InitiatedBy,
+		transaction.RequestID,
+		transaction.ReversalOf,
+	}
+
+	// the scope is a comma separated list, like a unit of work sets it
+	scope := transaction.TenantID + "," + transaction.DestinationTenantID
+
+	var executable, funded bool
+	var transactionID *int64
+	var createdAt *time.Time
+	err := queryRowScoped(ctx, r.db, scope, func(row pgx.Row) error {
+		return row.Scan(&executable, &funded, &transactionID, &createdAt)
+	}, query, args...)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" && pgErr.ConstraintName == "idx_transactions_reversal_of" {
+				return models.ErrAlreadyReversed
+			}
+		}
+		return classify(err)
+	}
+
+	if !executable {
+		return ErrNotExecuted
+	}
+
+	transaction.TransactionID = *transactionID
+	transaction.CreatedAt = *createdAt
+	if !funded {
+		transaction.Status = models.TransactionStatusFailed
+		return models.ErrInsufficientBalance
+	}
+	transaction.Status = models.TransactionStatusCompleted
+	transaction.ErrorMessage = nil
+	return nil
+}
+
+// record a pending transfer and queue it for the async workers, as one
+// statement. roles are those of the caller, its ID is the initiator of the
+// transaction. The ID and creation time of the record are set in transaction.
+func (r *transactionRepository) Enqueue(ctx context.Context, transaction *models.Transaction, roles []string) error {
+	query := `
+		WITH recorded AS (
+			INSERT INTO transactions (
+				tenant_id,
+				source_account_id,
+				destination_tenant_id,
+				destination_account_id,
+				amount,
+				status,
+				created_at,
+				initiated_by,
+				request_id
+			)
+			VALUES ($1, $2, $3, $4, $5, 'pending', NOW(), $6, $7)
+			RETURNING transaction_id, created_at
+		),
+		queued AS (
+			INSERT INTO transfer_queue (transaction_id, tenant_id, principal_roles)
+			SELECT transaction_id, $1, $8 FROM recorded
+		)
+		SELECT transaction_id, created_at FROM recorded
+	`
+
+	if roles == nil {
+		roles = []string{}
+	}
+	args := []any{
+		transaction.TenantID,
+		transaction.SourceAccountID,
+		transaction.DestinationTenantID,
+		transaction.DestinationAccountID,
+		transaction.Amount,
+		transaction.InitiatedBy,
+		transaction.RequestID,
+		roles,
+	}
+
+	scope := transaction.TenantID + "," + transaction.DestinationTenantID
+	err := queryRowScoped(ctx, r.db, scope, func(row pgx.Row) error {
+		return row.Scan(&transaction.TransactionID, &transaction.CreatedAt)
+	}, query, args...)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			accountID := transaction.DestinationAccountID
+			if pgErr.ConstraintName == "fk_source_account" {
+				accountID = transaction.SourceAccountID
+			}
+			return fmt.Errorf("%w: account_id %d", models.ErrAccountNotFound, accountID)
+		}
+		return err
+	}
+
+	transaction.Status = models.TransactionStatusPending
+	return nil
+}
+
+// claim the oldest due transfer of any tenant, skipping jobs other workers
+// are claiming. The job becomes due again after the lease, so a transfer
+// whose worker died is picked up by another one.
+func (r *transactionRepository) Claim(ctx context.Context, lease time.Duration) (*models.QueuedTransfer, error) {
+	query := `
+		UPDATE transfer_queue q
+		SET attempts = q.attempts + 1,
+		    available_at = NOW() + make_interval(secs => $1)
+		FROM (
+			SELECT transaction_id
+			FROM transfer_queue
+			WHERE available_at <= NOW()
+			ORDER BY available_at, transaction_id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE q.transaction_id = due.transaction_id
+		RETURNING q.transaction_id, q.tenant_id, q.principal_roles, q.attempts
+	`
+
+	// the queue has no row level security, so no tenant scope
+	var job models.QueuedTransfer
+	err := conn(ctx, r.db).QueryRow(ctx, query, lease.Seconds()).Scan(
+		&job.TransactionID,
+		&job.TenantID,
+		&job.PrincipalRoles,
+		&job.Attempts,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrQueueEmpty
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// record the outcome of a pending transfer, the status and error message of
+// transaction, and remove it from the queue. Inside the unit of work that
+// moved the balances, this makes sure a transfer runs once even when two
+// workers claimed it.
+func (r *transactionRepository) Finish(ctx context.Context, transaction *models.Transaction) error {
+	query := `
+		WITH dequeued AS (
+			DELETE FROM transfer_queue WHERE transaction_id = $1
+		)
+		UPDATE transactions
+		SET status = $2, error_message = $3
+		WHERE transaction_id = $1 AND status = 'pending'
+		RETURNING created_at
+	`
+
+	scope := transaction.TenantID + "," + transaction.DestinationTenantID
+	err := queryRowScoped(ctx, r.db, scope, func(row pgx.Row) error {
+		return row.Scan(&transaction.CreatedAt)
+	}, query, transaction.TransactionID, transaction.Status, transaction.ErrorMessage)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotPending
+		}
+		return classify(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// transaction isolation level, as configured with database.isolation
+type IsolationLevel string
+
+const (
+	ReadCommitted  IsolationLevel = "read_committed"
+	RepeatableRead IsolationLevel = "repeatable_read"
+	Serializable   IsolationLevel = "serializable"
+)
+
+// settings of a unit of work
+type TxOptions struct {
+	// tenants whose rows the transaction can see
+	TenantIDs []string
+	// isolation of the transaction, the store's default when empty
+	Isolation IsolationLevel
+}
+
+// runs callbacks atomically. Repository calls made with the context passed
+// to fn join the transaction, which commits when fn returns nil and rolls
+// back when it returns an error or panics.
+//
+// A Do inside fn runs in a savepoint of the outer transaction: its error
+// only undoes its own writes, and its writes are still lost if the outer
+// transaction rolls back. Nested calls must stay within the tenants of the
+// outer transaction and cannot change its isolation.
+type UnitOfWork interface {
+	Do(ctx context.Context, opts TxOptions, fn func(ctx context.Context) error) error
+}
+
+// returned for a nested unit of work the outer transaction cannot run
+var ErrNestedTx = errors.New("nested unit of work does not fit the outer transaction")
+
+// returned by Do, wrapping the database error, when the database aborted the
+// transaction because of concurrent ones. Running it again can succeed.
+var (
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrDeadlock             = errors.New("deadlock detected")
+)
+
+// returned by UpdateBalanceIfVersion when the account changed since it was
+// read. Running the unit of work again can succeed.
+var ErrVersionConflict = errors.New("account changed since it was read")
+
+// check a nested unit of work against the transaction it joins
+func CheckNested(opts TxOptions, tenantIDs []string, isolation IsolationLevel) error {
+	for _, id := range opts.TenantIDs {
+		if !slices.Contains(tenantIDs, id) {
+			return fmt.Errorf("%w: tenant %s is outside its scope", ErrNestedTx, id)
+		}
+	}
+	if opts.Isolation != "" && opts.Isolation != isolation {
+		return fmt.Errorf("%w: it runs at %s, not %s", ErrNestedTx, isolation, opts.Isolation)
+	}
+	return nil
+}
+
+type postgresUnitOfWork struct {
+	db        *pgxpool.Pool
+	isolation IsolationLevel
+}
+
+// unit of work over the pool, running transactions at the given isolation
+// unless the options ask for another one
+func NewUnitOfWork(db *pgxpool.Pool, isolation IsolationLevel) UnitOfWork {
+	return &postgresUnitOfWork{db: db, isolation: isolation}
+}
+
+type txKey struct{}
+
+// transaction of a running unit of work
+type scopedTx struct {
+	tx        pgx.Tx
+	tenantIDs []string
+	isolation IsolationLevel
+}
+
+// the transaction of the unit of work running in ctx, if any
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	st, ok := ctx.Value(txKey{}).(*scopedTx)
+	if !ok {
+		return nil, false
+	}
+	return st.tx, true
+}
+
+// statements shared by the pool and transactions
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// the transaction of the unit of work running in ctx, or else the pool, for
+// tables without row level security
+func conn(ctx context.Context, db *pgxpool.Pool) querier {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return db
+}
+
+func (u *postgresUnitOfWork) Do(ctx context.Context, opts TxOptions, fn func(ctx context.Context) error) error {
+	if outer, ok := ctx.Value(txKey{}).(*scopedTx); ok {
+		if err := CheckNested(opts, outer.tenantIDs, outer.isolation); err != nil {
+			return err
+		}
+		// pgx runs a transaction begun inside another as a savepoint
+		savepoint, err := outer.tx.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		return classify(run(ctx, &scopedTx{savepoint, outer.tenantIDs, outer.isolation}, fn))
+	}
+
+	isolation := opts.Isolation
+	if isolation == "" {
+		isolation = u.isolation
+	}
+	tx, err := u.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: isolation.pgx()})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, setTenantsQuery, strings.Join(opts.TenantIDs, ",")); err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("failed to set tenant scope: %w", err)
+	}
+	return classify(run(ctx, &scopedTx{tx, opts.TenantIDs, isolation}, fn))
+}
+
+// mark errors of transactions aborted because of concurrent ones
+func classify(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case "40001": // serialization_failure
+		return fmt.Errorf("%w: %w", ErrSerializationFailure, err)
+	case "40P01": // deadlock_detected
+		return fmt.Errorf("%w: %w", ErrDeadlock, err)
+	}
+	return err
+}
+
+// call fn in the transaction and end it with the outcome
+func run(ctx context.Context, st *scopedTx, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			st.tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, st)); err != nil {
+		st.tx.Rollback(ctx)
+		return err
+	}
+	return st.tx.Commit(ctx)
+}
+
+func (l IsolationLevel) pgx() pgx.TxIsoLevel {
+	switch l {
+	case RepeatableRead:
+		return pgx.RepeatableRead
+	case Serializable:
+		return pgx.Serializable
+	default:
+		return pgx.ReadCommitted
+	}
+}
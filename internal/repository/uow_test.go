@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		code string
+		want error
+	}{
+		{"40001", ErrSerializationFailure},
+		{"40P01", ErrDeadlock},
+		{"23505", nil},
+		{"55P03", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			pgErr := &pgconn.PgError{Code: tt.code}
+			err := classify(fmt.Errorf("failed to transfer: %w", pgErr))
+
+			if !errors.As(err, &pgErr) {
+				t.Errorf("classify() = %v, lost the database error", err)
+			}
+			for _, sentinel := range []error{ErrSerializationFailure, ErrDeadlock} {
+				if errors.Is(err, sentinel) != (sentinel == tt.want) {
+					t.Errorf("classify() = %v, want it to wrap %v", err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package requestid
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slog handler adding the request_id attribute to records logged with a
+// request context
+type logHandler struct {
+	slog.Handler
+}
+
+func NewLogHandler(next slog.Handler) slog.Handler {
+	return &logHandler{Handler: next}
+}
+
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := FromContext(ctx); id != "" {
+		record = record.Clone()
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithGroup(name)}
+}
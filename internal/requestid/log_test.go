@@ -0,0 +1,59 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(logger *slog.Logger, ctx context.Context)
+		want map[string]any
+	}{
+		{
+			name: "request context",
+			log:  func(l *slog.Logger, ctx context.Context) { l.InfoContext(WithID(ctx, "req-42"), "hello") },
+			want: map[string]any{"msg": "hello", "request_id": "req-42"},
+		},
+		{
+			name: "no request",
+			log:  func(l *slog.Logger, ctx context.Context) { l.InfoContext(ctx, "hello") },
+			want: map[string]any{"msg": "hello"},
+		},
+		{
+			name: "logger with attributes",
+			log: func(l *slog.Logger, ctx context.Context) {
+				l.With(slog.String("component", "worker")).InfoContext(WithID(ctx, "req-42"), "hello")
+			},
+			want: map[string]any{"msg": "hello", "component": "worker", "request_id": "req-42"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := slog.New(NewLogHandler(slog.NewJSONHandler(&out, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})))
+			tt.log(logger, context.Background())
+
+			var got map[string]any
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("record = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// header carrying the request ID in requests and responses
+const Header = "X-Request-ID"
+
+// accepted incoming IDs, anything else is replaced to keep logs clean
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+// W3C trace context, version-traceid-parentid-flags
+var traceparent = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+type requestIDKey struct{}
+
+// attach the request ID to the context
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// get the request ID, empty outside a request
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// pick the ID for an incoming request: the X-Request-ID header, then the
+// trace ID of a traceparent header, otherwise a newly generated one
+func FromRequest(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(Header)); validID.MatchString(id) {
+		return id
+	}
+	if m := traceparent.FindStringSubmatch(strings.TrimSpace(r.Header.Get("traceparent"))); m != nil && m[1] != strings.Repeat("0", 32) {
+		return m[1]
+	}
+	return New()
+}
+
+// generate a random request ID
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("requestid: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package requestid
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var generatedID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestFromRequest(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name        string
+		requestID   string
+		traceparent string
+		want        string // empty for a generated ID
+	}{
+		{"no headers", "", "", ""},
+		{"request ID", "req-42", "", "req-42"},
+		{"all accepted characters", "Az09._:/+=-", "", "Az09._:/+=-"},
+		{"surrounding spaces", "  req-42 ", "", "req-42"},
+		{"longest accepted", strings.Repeat("a", 128), "", strings.Repeat("a", 128)},
+		{"too long", strings.Repeat("a", 129), "", ""},
+		{"space inside", "req 42", "", ""},
+		{"newline", "req-42\nlevel=ERROR", "", ""},
+		{"quote", `req"42`, "", ""},
+		{"non-ASCII", "réq-42", "", ""},
+		{"request ID before traceparent", "req-42", "00-" + traceID + "-00f067aa0ba902b7-01", "req-42"},
+		{"traceparent", "", "00-" + traceID + "-00f067aa0ba902b7-01", traceID},
+		{"traceparent after an invalid request ID", "req 42", "00-" + traceID + "-00f067aa0ba902b7-01", traceID},
+		{"traceparent with an all-zero trace ID", "", "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01", ""},
+		{"malformed traceparent", "", "00-" + traceID + "-01", ""},
+		{"uppercase traceparent", "", "00-" + strings.ToUpper(traceID) + "-00f067aa0ba902b7-01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.requestID != "" {
+				r.Header.Set(Header, tt.requestID)
+			}
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+
+			got := FromRequest(r)
+			if tt.want == "" {
+				if !generatedID.MatchString(got) {
+					t.Errorf("FromRequest() = %q, want a generated ID", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("FromRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	first, second := New(), New()
+	if !generatedID.MatchString(first) || first == second {
+		t.Errorf("New() = %q, %q, want distinct random IDs", first, second)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("FromContext() = %q outside a request, want it empty", id)
+	}
+	if id := FromContext(WithID(context.Background(), "req-42")); id != "req-42" {
+		t.Errorf("FromContext() = %q, want req-42", id)
+	}
+}
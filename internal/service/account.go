@@ -2,37 +2,55 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"internal-transfers/internal/auth"
 	"internal-transfers/internal/models"
 	"internal-transfers/internal/repository"
+	"internal-transfers/internal/tenant"
 	"log/slog"
 
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // interface for account business logic
 type AccountService interface {
 	CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (*models.Account, error)
 	GetAccount(ctx context.Context, accountID int64) (*models.Account, error)
+	AddDelegate(ctx context.Context, accountID int64, principalID string) error
+	RemoveDelegate(ctx context.Context, accountID int64, principalID string) error
+	Reconcile(ctx context.Context) (*models.ReconciliationReport, error)
+	SetShards(ctx context.Context, accountID int64, shards int) (*models.Account, error)
 }
 
 type accountService struct {
+	uow         repository.UnitOfWork
 	accountRepo repository.AccountRepository
+	authorizer  Authorizer
 	logger      *slog.Logger
 }
 
 // create a new account service
-func NewAccountService(accountRepo repository.AccountRepository, logger *slog.Logger) AccountService {
+func NewAccountService(uow repository.UnitOfWork, accountRepo repository.AccountRepository, authorizer Authorizer, logger *slog.Logger) AccountService {
 	return &accountService{
+		uow:         uow,
 		accountRepo: accountRepo,
+		authorizer:  authorizer,
 		logger:      logger,
 	}
 }
 
 // create a new account with the given initial balance
-func (s *accountService) CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (*models.Account, error) {
+func (s *accountService) CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (_ *models.Account, err error) {
+	ctx, span := tracer.Start(ctx, "AccountService.CreateAccount", trace.WithAttributes(
+		attribute.Int64("account.id", req.AccountID),
+	))
+	defer func() { endSpan(span, err) }()
+
 	// validate initial balance is not negative
 	if req.InitialBalance.LessThan(decimal.Zero) {
-		s.logger.Warn("attempted to create account with negative balance",
+		s.logger.WarnContext(ctx, "attempted to create account with negative balance",
 			slog.Int64("account_id", req.AccountID),
 			slog.String("balance", req.InitialBalance.String()),
 		)
@@ -41,27 +59,39 @@ func (s *accountService) CreateAccount(ctx context.Context, req *models.CreateAc
 
 	// Validate account ID is positive
 	if req.AccountID <= 0 {
-		s.logger.Warn("attempted to create account with invalid ID",
+		s.logger.WarnContext(ctx, "attempted to create account with invalid ID",
 			slog.Int64("account_id", req.AccountID),
 		)
 		return nil, models.ErrInvalidAccountID
 	}
 
+	ownerID, err := s.resolveOwner(ctx, req.OwnerID)
+	if err != nil {
+		s.logger.WarnContext(ctx, "attempted to create account for another owner",
+			slog.Int64("account_id", req.AccountID),
+			slog.String("owner_id", req.OwnerID),
+		)
+		return nil, err
+	}
+
 	account := &models.Account{
+		TenantID:  tenant.FromContext(ctx),
 		AccountID: req.AccountID,
 		Balance:   req.InitialBalance,
+		OwnerID:   ownerID,
 	}
 
-	err := s.accountRepo.Create(ctx, account)
+	err = s.accountRepo.Create(ctx, account)
 	if err != nil {
-		s.logger.Error("failed to create account",
+		s.logger.ErrorContext(ctx, "failed to create account",
 			slog.Int64("account_id", req.AccountID),
 			slog.String("error", err.Error()),
 		)
 		return nil, err
 	}
 
-	s.logger.Info("account created successfully",
+	s.logger.InfoContext(ctx, "account created successfully",
+		slog.String("tenant_id", account.TenantID),
 		slog.Int64("account_id", account.AccountID),
 		slog.String("balance", account.Balance.String()),
 	)
@@ -70,14 +100,19 @@ func (s *accountService) CreateAccount(ctx context.Context, req *models.CreateAc
 }
 
 // get an account by ID
-func (s *accountService) GetAccount(ctx context.Context, accountID int64) (*models.Account, error) {
+func (s *accountService) GetAccount(ctx context.Context, accountID int64) (_ *models.Account, err error) {
+	ctx, span := tracer.Start(ctx, "AccountService.GetAccount", trace.WithAttributes(
+		attribute.Int64("account.id", accountID),
+	))
+	defer func() { endSpan(span, err) }()
+
 	if accountID <= 0 {
 		return nil, models.ErrInvalidAccountID
 	}
 
-	account, err := s.accountRepo.GetByID(ctx, accountID)
+	account, err := s.accountRepo.GetByID(ctx, tenant.FromContext(ctx), accountID)
 	if err != nil {
-		s.logger.Error("failed to get account",
+		s.logger.ErrorContext(ctx, "failed to get account",
 			slog.Int64("account_id", accountID),
 			slog.String("error", err.Error()),
 		)
@@ -86,3 +121,159 @@ func (s *accountService) GetAccount(ctx context.Context, accountID int64) (*mode
 
 	return account, nil
 }
+
+// the owner of a new account is the caller, unless an admin names another one
+func (s *accountService) resolveOwner(ctx context.Context, requested string) (*string, error) {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		// callers are authenticated upstream, trust the request
+		if requested == "" {
+			return nil, nil
+		}
+		return &requested, nil
+	}
+
+	if requested == "" || requested == principal.ID {
+		return &principal.ID, nil
+	}
+	if !principal.HasRole(auth.RoleAdmin) {
+		return nil, fmt.Errorf("%w: only admins may create accounts for another owner", models.ErrAccessDenied)
+	}
+	return &requested, nil
+}
+
+// allow a principal to debit an account
+func (s *accountService) AddDelegate(ctx context.Context, accountID int64, principalID string) error {
+	account, err := s.GetAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.authorizer.AuthorizeManage(ctx, account); err != nil {
+		s.logger.WarnContext(ctx, "denied adding account delegate",
+			slog.Int64("account_id", accountID),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	if err := s.accountRepo.AddDelegate(ctx, account.TenantID, accountID, principalID); err != nil {
+		s.logger.ErrorContext(ctx, "failed to add account delegate",
+			slog.Int64("account_id", accountID),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "account delegate added",
+		slog.Int64("account_id", accountID),
+		slog.String("principal_id", principalID),
+	)
+	return nil
+}
+
+// revoke a delegate of an account
+func (s *accountService) RemoveDelegate(ctx context.Context, accountID int64, principalID string) error {
+	account, err := s.GetAccount(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.authorizer.AuthorizeManage(ctx, account); err != nil {
+		s.logger.WarnContext(ctx, "denied removing account delegate",
+			slog.Int64("account_id", accountID),
+			slog.String("error", err.Error()),
+		)
+		return err
+	}
+
+	if err := s.accountRepo.RemoveDelegate(ctx, account.TenantID, accountID, principalID); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "account delegate removed",
+		slog.Int64("account_id", accountID),
+		slog.String("principal_id", principalID),
+	)
+	return nil
+}
+
+// check every account of the caller's tenant against its transfer history
+func (s *accountService) Reconcile(ctx context.Context) (*models.ReconciliationReport, error) {
+	tenantID := tenant.FromContext(ctx)
+
+	entries, err := s.accountRepo.Reconcile(ctx, tenantID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to reconcile accounts", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	report := &models.ReconciliationReport{
+		TenantID:        tenantID,
+		AccountsChecked: len(entries),
+		Mismatches:      []models.ReconciliationMismatch{},
+	}
+
+	total := decimal.Zero
+	for _, entry := range entries {
+		total = total.Add(entry.Balance)
+		if !entry.Balance.Equal(entry.Expected) {
+			report.Mismatches = append(report.Mismatches, models.ReconciliationMismatch{
+				AccountID: entry.AccountID,
+				Balance:   entry.Balance.String(),
+				Expected:  entry.Expected.String(),
+			})
+		}
+	}
+	report.TotalBalance = total.String()
+
+	if len(report.Mismatches) > 0 {
+		s.logger.WarnContext(ctx, "reconciliation found mismatched balances",
+			slog.String("tenant_id", tenantID),
+			slog.Int("mismatches", len(report.Mismatches)),
+		)
+	}
+
+	return report, nil
+}
+
+// split the balance of an account across shards, making it a hot account
+// whose transfers lock a single shard instead of the account. 0 merges the
+// shards back into the account.
+func (s *accountService) SetShards(ctx context.Context, accountID int64, shards int) (_ *models.Account, err error) {
+	ctx, span := tracer.Start(ctx, "AccountService.SetShards", trace.WithAttributes(
+		attribute.Int64("account.id", accountID),
+		attribute.Int("account.shards", shards),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if accountID <= 0 {
+		return nil, models.ErrInvalidAccountID
+	}
+	if shards < 0 || shards == 1 || shards > models.MaxShards {
+		return nil, fmt.Errorf("%w: %d, use 0 or 2 to %d", models.ErrInvalidShards, shards, models.MaxShards)
+	}
+
+	tenantID := tenant.FromContext(ctx)
+
+	var account *models.Account
+	err = s.uow.Do(ctx, repository.TxOptions{TenantIDs: []string{tenantID}}, func(ctx context.Context) error {
+		account, err = s.accountRepo.SetShards(ctx, tenantID, accountID, shards)
+		return err
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to shard account",
+			slog.Int64("account_id", accountID),
+			slog.Int("shards", shards),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "account sharded",
+		slog.String("tenant_id", tenantID),
+		slog.Int64("account_id", accountID),
+		slog.Int("shards", shards),
+	)
+	return account, nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/tenant"
+	"log/slog"
+	"strings"
+)
+
+// prefix of every issued api key, so leaked keys are easy to recognise
+const apiKeyPrefix = "itk"
+
+// interface for api key management and authentication
+type APIKeyService interface {
+	IssueKey(ctx context.Context, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error)
+	RevokeKey(ctx context.Context, id int64) error // within the caller's tenant
+	Authenticate(ctx context.Context, rawKey string) (*auth.Principal, error)
+}
+
+type apiKeyService struct {
+	repo     repository.APIKeyRepository
+	adminKey string
+	logger   *slog.Logger
+}
+
+// create a new api key service, a non-empty adminKey is accepted as a
+// bootstrap key with the admin scope so the first keys can be issued
+func NewAPIKeyService(repo repository.APIKeyRepository, adminKey string, logger *slog.Logger) APIKeyService {
+	return &apiKeyService{
+		repo:     repo,
+		adminKey: adminKey,
+		logger:   logger,
+	}
+}
+
+// issue a new api key, the raw key is only available in the return value
+func (s *apiKeyService) IssueKey(ctx context.Context, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	if !auth.ValidScopes(req.Scopes) {
+		return nil, "", fmt.Errorf("%w: allowed scopes are %s", models.ErrInvalidScope, strings.Join(auth.Scopes, ", "))
+	}
+
+	tenantID, err := keyTenant(ctx, req.TenantID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prefix, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rawKey := fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, secret)
+	hash := sha256.Sum256([]byte(rawKey))
+
+	key := &models.APIKey{
+		TenantID: tenantID,
+		Name:     req.Name,
+		Prefix:   prefix,
+		KeyHash:  hash[:],
+		Scopes:   req.Scopes,
+	}
+
+	if err := s.repo.Create(ctx, key); err != nil {
+		s.logger.ErrorContext(ctx, "failed to create api key",
+			slog.String("name", req.Name),
+			slog.String("error", err.Error()),
+		)
+		return nil, "", err
+	}
+
+	s.logger.InfoContext(ctx, "api key issued",
+		slog.Int64("api_key_id", key.ID),
+		slog.String("tenant_id", key.TenantID),
+		slog.String("name", key.Name),
+		slog.Any("scopes", key.Scopes),
+	)
+
+	return key, rawKey, nil
+}
+
+// revoke an api key
+func (s *apiKeyService) RevokeKey(ctx context.Context, id int64) error {
+	if err := s.repo.Revoke(ctx, tenant.FromContext(ctx), id); err != nil {
+		if !errors.Is(err, models.ErrAPIKeyNotFound) {
+			s.logger.ErrorContext(ctx, "failed to revoke api key",
+				slog.Int64("api_key_id", id),
+				slog.String("error", err.Error()),
+			)
+		}
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "api key revoked", slog.Int64("api_key_id", id))
+	return nil
+}
+
+// resolve a raw api key to the principal it was issued to
+func (s *apiKeyService) Authenticate(ctx context.Context, rawKey string) (*auth.Principal, error) {
+	if s.adminKey != "" && subtle.ConstantTimeCompare([]byte(rawKey), []byte(s.adminKey)) == 1 {
+		return &auth.Principal{
+			ID:         "apikey:bootstrap",
+			AllTenants: true,
+			Type:       auth.PrincipalAPIKey,
+			Name:       "bootstrap admin key",
+			Scopes:     []string{auth.ScopeAdmin},
+			Roles:      []string{auth.RoleAdmin},
+		}, nil
+	}
+
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	key, err := s.repo.GetByPrefix(ctx, parts[1])
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			return nil, auth.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(rawKey))
+	if subtle.ConstantTimeCompare(hash[:], key.KeyHash) != 1 || key.RevokedAt != nil {
+		s.logger.WarnContext(ctx, "rejected api key", slog.Int64("api_key_id", key.ID))
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	principal := &auth.Principal{
+		ID:       fmt.Sprintf("apikey:%d", key.ID),
+		TenantID: key.TenantID,
+		Type:     auth.PrincipalAPIKey,
+		Name:     key.Name,
+		Scopes:   key.Scopes,
+	}
+	// keys allowed to manage api keys may act on any account of their tenant,
+	// the admin role does not reach beyond it
+	if principal.HasScope(auth.ScopeAdmin) {
+		principal.Roles = []string{auth.RoleAdmin}
+	}
+
+	return principal, nil
+}
+
+// tenant of a new key: the caller's own, unless a caller that is not bound to
+// a tenant (the bootstrap key) names one
+func keyTenant(ctx context.Context, requested string) (string, error) {
+	callerTenant := tenant.FromContext(ctx)
+	if requested == "" || requested == callerTenant {
+		return callerTenant, nil
+	}
+
+	if !tenant.ValidID(requested) {
+		return "", fmt.Errorf("%w: %q", models.ErrInvalidTenantID, requested)
+	}
+
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if ok && !principal.AllTenants {
+		return "", fmt.Errorf("%w: cannot issue keys for another tenant", models.ErrAccessDenied)
+	}
+	return requested, nil
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return encode(b), nil
+}
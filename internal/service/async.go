@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/requestid"
+	"internal-transfers/internal/tenant"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// how queued transfers are executed, as configured with transfers.async_*
+type AsyncPolicy struct {
+	Workers      int           // queued transfers this instance executes at once, 0 executes none
+	PollInterval time.Duration // wait before looking again when the queue is empty
+	Lease        time.Duration // how long a claimed transfer is hidden from other workers
+	MaxAttempts  int           // claims before a transfer that keeps failing is given up
+}
+
+// how often GetTransaction looks at a pending transfer while waiting
+const waitPollInterval = 100 * time.Millisecond
+
+// check the request and the caller's access to the source account, then
+// record the transfer as pending and queue it for the workers. Its outcome
+// is counted when a worker executed it, or here when it is rejected.
+func (s *transferService) SubmitTransfer(ctx context.Context, req *models.CreateTransactionRequest) (_ *models.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "TransferService.SubmitTransfer", trace.WithAttributes(
+		attribute.Int64("transfer.source_account_id", req.SourceAccountID),
+		attribute.Int64("transfer.destination_account_id", req.DestinationAccountID),
+	))
+	defer func() {
+		if err != nil {
+			recordOutcome(metrics.KindTransfer, nil, err)
+		}
+		endSpan(span, err)
+	}()
+
+	source, destination, err := s.transferAccounts(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// fail early for callers who could never make the transfer, the worker
+	// checks again when it runs
+	account, err := s.accountRepo.GetByID(ctx, source.tenantID, source.accountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.AuthorizeDebit(ctx, account); err != nil {
+		s.logger.WarnContext(ctx, "transfer denied",
+			slog.Int64("source_account", source.accountID),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	transaction := &models.Transaction{
+		TenantID:             source.tenantID,
+		SourceAccountID:      source.accountID,
+		DestinationTenantID:  destination.tenantID,
+		DestinationAccountID: destination.accountID,
+		Amount:               req.Amount,
+	}
+	var roles []string
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		transaction.InitiatedBy = &principal.ID
+		roles = principal.Roles
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		transaction.RequestID = &id
+	}
+
+	if err := s.txRepo.Enqueue(ctx, transaction, roles); err != nil {
+		if !errors.Is(err, models.ErrAccountNotFound) {
+			s.logger.ErrorContext(ctx, "failed to queue transfer",
+				slog.String("error", err.Error()),
+			)
+		}
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "transfer queued",
+		slog.Int64("transaction_id", transaction.TransactionID),
+		slog.String("tenant_id", source.tenantID),
+		slog.Int64("source_account", source.accountID),
+		slog.String("destination_tenant_id", destination.tenantID),
+		slog.Int64("destination_account", destination.accountID),
+		slog.String("amount", req.Amount.String()),
+	)
+	return transaction, nil
+}
+
+// get a transfer of the caller's tenant. A pending transfer is looked at
+// again until it finishes or wait runs out, and returned as pending then.
+func (s *transferService) GetTransaction(ctx context.Context, transactionID int64, wait time.Duration) (*models.Transaction, error) {
+	tenantID := tenant.FromContext(ctx)
+	deadline := time.Now().Add(wait)
+
+	for {
+		transaction, err := s.txRepo.GetByID(ctx, tenantID, transactionID)
+		if err != nil {
+			if errors.Is(err, models.ErrTransactionNotFound) {
+				return nil, fmt.Errorf("%w: transaction_id %d", models.ErrTransactionNotFound, transactionID)
+			}
+			return nil, err
+		}
+
+		remaining := time.Until(deadline)
+		if transaction.Status != models.TransactionStatusPending || remaining <= 0 {
+			return transaction, nil
+		}
+
+		select {
+		case <-time.After(min(waitPollInterval, remaining)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// claim the oldest due queued transfer and execute it with the caller who
+// queued it, reporting false when none is due. Transfers that failed for a
+// conflict or the database stay queued and are claimed again once their
+// lease runs out, until they used up their attempts.
+func (s *transferService) ProcessQueued(ctx context.Context) (_ bool, err error) {
+	job, err := s.txRepo.Claim(ctx, s.async.Lease)
+	if errors.Is(err, repository.ErrQueueEmpty) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ctx = tenant.WithTenant(ctx, job.TenantID)
+	ctx, span := tracer.Start(ctx, "TransferService.ProcessQueued", trace.WithAttributes(
+		attribute.Int64("transfer.transaction_id", job.TransactionID),
+		attribute.Int("transfer.attempt", job.Attempts),
+	))
+	defer func() { endSpan(span, err) }()
+
+	pending, err := s.txRepo.GetByID(ctx, job.TenantID, job.TransactionID)
+	if err != nil {
+		return true, err
+	}
+	if pending.InitiatedBy != nil {
+		ctx = auth.WithPrincipal(ctx, &auth.Principal{
+			ID:       *pending.InitiatedBy,
+			TenantID: job.TenantID,
+			Roles:    job.PrincipalRoles,
+		})
+	}
+	if pending.RequestID != nil {
+		ctx = requestid.WithID(ctx, *pending.RequestID)
+	}
+
+	// finished by a worker whose lease ran out, only the job is left
+	if pending.Status != models.TransactionStatusPending {
+		if err := s.txRepo.Finish(ctx, pending); err != nil && !errors.Is(err, repository.ErrNotPending) {
+			return true, err
+		}
+		return true, nil
+	}
+
+	source := accountKey{pending.TenantID, pending.SourceAccountID}
+	destination := accountKey{pending.DestinationTenantID, pending.DestinationAccountID}
+	transaction, err := s.transfer(ctx, source, destination, pending.Amount, nil, pending.TransactionID)
+
+	logAttrs := []any{
+		slog.Int64("transaction_id", pending.TransactionID),
+		slog.Int("attempt", job.Attempts),
+	}
+	switch {
+	case err == nil, errors.Is(err, models.ErrInsufficientBalance):
+		recordOutcome(metrics.KindTransfer, transaction, err)
+		return true, nil
+	case errors.Is(err, repository.ErrNotPending):
+		s.logger.DebugContext(ctx, "queued transfer was finished by another worker", logAttrs...)
+		return true, nil
+	case !errors.Is(err, models.ErrAccountNotFound) && !errors.Is(err, models.ErrAccessDenied) && job.Attempts < s.async.MaxAttempts:
+		s.logger.WarnContext(ctx, "queued transfer failed, it runs again after its lease",
+			append(logAttrs, slog.String("error", err.Error()))...)
+		return true, nil
+	}
+
+	// the transfer cannot succeed, or ran out of attempts
+	failure := err
+	message := failure.Error()
+	pending.Status = models.TransactionStatusFailed
+	pending.ErrorMessage = &message
+	if err := s.txRepo.Finish(ctx, pending); err != nil {
+		if errors.Is(err, repository.ErrNotPending) {
+			return true, nil
+		}
+		return true, err
+	}
+	recordOutcome(metrics.KindTransfer, nil, failure)
+	s.logger.WarnContext(ctx, "queued transfer failed",
+		append(logAttrs, slog.String("error", message))...)
+	return true, nil
+}
+
+// execute queued transfers with the configured number of workers until ctx
+// ends. A transfer a worker started is finished before it stops.
+func RunTransferWorkers(ctx context.Context, transfers TransferService, policy AsyncPolicy, logger *slog.Logger) {
+	var wg sync.WaitGroup
+	for range policy.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				processed, err := transfers.ProcessQueued(context.WithoutCancel(ctx))
+				if err != nil {
+					logger.ErrorContext(ctx, "failed to process queued transfer", slog.String("error", err.Error()))
+				}
+				// keep draining a busy queue, back off when it is empty or failing
+				if processed && err == nil {
+					continue
+				}
+				select {
+				case <-time.After(policy.PollInterval):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// unit of work of a database failing the given number of units of work
+type unavailableUnitOfWork struct {
+	repository.UnitOfWork
+	failures atomic.Int32
+}
+
+var errDatabaseUnavailable = errors.New("connection reset by peer")
+
+func (u *unavailableUnitOfWork) Do(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	if u.failures.Add(-1) >= 0 {
+		return errDatabaseUnavailable
+	}
+	return u.UnitOfWork.Do(ctx, opts, fn)
+}
+
+// service over a memory store with accounts 1 and 2 whose unit of work
+// fails as told, and the ID of a transfer of 5 it queued
+func newQueueService(t *testing.T, maxAttempts int) (TransferService, *unavailableUnitOfWork, int64, context.Context) {
+	t.Helper()
+	store, ctx := newTestStore(t, 10, 0)
+	uow := &unavailableUnitOfWork{UnitOfWork: store.UnitOfWork}
+	// a lease of zero makes a failed transfer due again at once
+	transfers := NewTransferService(uow, store.Accounts, store.Transactions,
+		NewAllowAllAuthorizer(), CrossTenantPolicy{}, ConcurrencyLocking, false,
+		RetryPolicy{Attempts: 1}, BatchPolicy{}, AsyncPolicy{MaxAttempts: maxAttempts}, discardLogger)
+
+	queued, err := transfers.SubmitTransfer(ctx, transferRequest(1, 2, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transfers, uow, queued.TransactionID, ctx
+}
+
+func TestProcessQueued(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		failures    int32
+		wantClaims  int
+		wantStatus  models.TransactionStatus
+	}{
+		{"runs at once", 3, 0, 1, models.TransactionStatusCompleted},
+		{"retried after failures", 3, 2, 3, models.TransactionStatusCompleted},
+		{"fails after the last attempt", 2, 5, 2, models.TransactionStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfers, uow, id, ctx := newQueueService(t, tt.maxAttempts)
+			uow.failures.Store(tt.failures)
+
+			claims := 0
+			for {
+				processed, err := transfers.ProcessQueued(ctx)
+				if err != nil {
+					t.Fatalf("ProcessQueued() error = %v", err)
+				}
+				if !processed {
+					break
+				}
+				if claims++; claims > 10 {
+					t.Fatal("transfer never left the queue")
+				}
+			}
+			if claims != tt.wantClaims {
+				t.Errorf("claimed %d times, want %d", claims, tt.wantClaims)
+			}
+
+			transaction, err := transfers.GetTransaction(ctx, id, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transaction.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", transaction.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == models.TransactionStatusFailed &&
+				(transaction.ErrorMessage == nil || *transaction.ErrorMessage != errDatabaseUnavailable.Error()) {
+				t.Errorf("error message = %v, want the last failure", transaction.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestGetTransactionWait(t *testing.T) {
+	t.Run("times out as pending", func(t *testing.T) {
+		transfers, _, id, ctx := newQueueService(t, 1)
+
+		start := time.Now()
+		transaction, err := transfers.GetTransaction(ctx, id, 150*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transaction.Status != models.TransactionStatusPending {
+			t.Errorf("status = %s, want %s", transaction.Status, models.TransactionStatusPending)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+			t.Errorf("returned after %v, want it to wait out the 150ms", elapsed)
+		}
+	})
+
+	t.Run("returns once completed", func(t *testing.T) {
+		transfers, _, id, ctx := newQueueService(t, 1)
+		time.AfterFunc(50*time.Millisecond, func() { transfers.ProcessQueued(ctx) })
+
+		start := time.Now()
+		transaction, err := transfers.GetTransaction(ctx, id, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transaction.Status != models.TransactionStatusCompleted {
+			t.Errorf("status = %s, want %s", transaction.Status, models.TransactionStatusCompleted)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("returned after %v, want it to return once the transfer completed", elapsed)
+		}
+	})
+
+	t.Run("stops with the context", func(t *testing.T) {
+		transfers, _, id, ctx := newQueueService(t, 1)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		if _, err := transfers.GetTransaction(ctx, id, 10*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("GetTransaction() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("unknown transfer", func(t *testing.T) {
+		transfers, _, id, ctx := newQueueService(t, 1)
+		if _, err := transfers.GetTransaction(ctx, id+1, time.Second); !errors.Is(err, models.ErrTransactionNotFound) {
+			t.Errorf("GetTransaction() error = %v, want %v", err, models.ErrTransactionNotFound)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+)
+
+// decides whether the caller in the context may act on an account
+type Authorizer interface {
+	// move money out of the account
+	AuthorizeDebit(ctx context.Context, account *models.Account) error
+	// change who may act on the account
+	AuthorizeManage(ctx context.Context, account *models.Account) error
+	// the principal whose own and delegated accounts of the tenant the caller
+	// may debit, nil if it may debit every account of it, for debits checked
+	// by the database
+	Debitor(ctx context.Context, tenantID string) (*string, error)
+}
+
+// allows the account owner, its delegates (debit only) and admins of the
+// account's tenant
+type ownershipAuthorizer struct {
+	accountRepo repository.AccountRepository
+}
+
+func NewOwnershipAuthorizer(accountRepo repository.AccountRepository) Authorizer {
+	return &ownershipAuthorizer{accountRepo: accountRepo}
+}
+
+func (a *ownershipAuthorizer) AuthorizeDebit(ctx context.Context, account *models.Account) error {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: no authenticated caller", models.ErrAccessDenied)
+	}
+
+	if isOwnerOrAdmin(principal, account) {
+		return nil
+	}
+
+	delegate, err := a.accountRepo.IsDelegate(ctx, account.TenantID, account.AccountID, principal.ID)
+	if err != nil {
+		return err
+	}
+	if delegate {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s may not debit account_id %d", models.ErrAccessDenied, principal.ID, account.AccountID)
+}
+
+func (a *ownershipAuthorizer) AuthorizeManage(ctx context.Context, account *models.Account) error {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: no authenticated caller", models.ErrAccessDenied)
+	}
+
+	if isOwnerOrAdmin(principal, account) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s may not manage account_id %d", models.ErrAccessDenied, principal.ID, account.AccountID)
+}
+
+func (a *ownershipAuthorizer) Debitor(ctx context.Context, tenantID string) (*string, error) {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w: no authenticated caller", models.ErrAccessDenied)
+	}
+
+	if principal.IsAdminOf(tenantID) {
+		return nil, nil
+	}
+	return &principal.ID, nil
+}
+
+// accounts created before ownership existed have no owner and are admin only
+func isOwnerOrAdmin(principal *auth.Principal, account *models.Account) bool {
+	if principal.IsAdminOf(account.TenantID) {
+		return true
+	}
+	return account.OwnerID != nil && *account.OwnerID == principal.ID
+}
+
+// allows everything, used when callers are authenticated upstream
+type allowAllAuthorizer struct{}
+
+func NewAllowAllAuthorizer() Authorizer {
+	return allowAllAuthorizer{}
+}
+
+func (allowAllAuthorizer) AuthorizeDebit(context.Context, *models.Account) error {
+	return nil
+}
+
+func (allowAllAuthorizer) AuthorizeManage(context.Context, *models.Account) error {
+	return nil
+}
+
+func (allowAllAuthorizer) Debitor(context.Context, string) (*string, error) {
+	return nil, nil
+}
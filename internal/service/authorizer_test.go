@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/tenant"
+	"testing"
+)
+
+func TestOwnershipAuthorizer(t *testing.T) {
+	store := memory.NewStore()
+	ctx := tenant.WithTenant(context.Background(), "a")
+	owner := "apikey:1"
+	owned := &models.Account{TenantID: "a", AccountID: 1, OwnerID: &owner}
+	unowned := &models.Account{TenantID: "a", AccountID: 2}
+	for _, account := range []*models.Account{owned, unowned} {
+		if err := store.Accounts.Create(ctx, account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Accounts.AddDelegate(ctx, "a", 1, "apikey:2"); err != nil {
+		t.Fatal(err)
+	}
+	authorizer := NewOwnershipAuthorizer(store.Accounts)
+
+	admin := func(tenantID string) *auth.Principal {
+		return &auth.Principal{ID: "apikey:9", TenantID: tenantID, Roles: []string{auth.RoleAdmin}}
+	}
+	bootstrap := &auth.Principal{ID: "apikey:bootstrap", AllTenants: true, Roles: []string{auth.RoleAdmin}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		account   *models.Account
+		debit     bool
+		manage    bool
+	}{
+		{"no caller", nil, owned, false, false},
+		{"owner", &auth.Principal{ID: owner, TenantID: "a"}, owned, true, true},
+		{"other caller of the tenant", &auth.Principal{ID: "apikey:3", TenantID: "a"}, owned, false, false},
+		{"delegate", &auth.Principal{ID: "apikey:2", TenantID: "a"}, owned, true, false},
+		{"admin of the tenant", admin("a"), owned, true, true},
+		{"admin of another tenant", admin("b"), owned, false, false},
+		{"admin without a tenant", admin(""), owned, false, false},
+		{"bootstrap key", bootstrap, owned, true, true},
+		{"caller on an account without owner", &auth.Principal{ID: "apikey:3", TenantID: "a"}, unowned, false, false},
+		{"admin on an account without owner", admin("a"), unowned, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ctx
+			if tt.principal != nil {
+				ctx = auth.WithPrincipal(ctx, tt.principal)
+			}
+			check := func(what string, err error, allowed bool) {
+				t.Helper()
+				if allowed && err != nil {
+					t.Errorf("%s = %v, want allowed", what, err)
+				}
+				if !allowed && !errors.Is(err, models.ErrAccessDenied) {
+					t.Errorf("%s = %v, want ErrAccessDenied", what, err)
+				}
+			}
+			check("AuthorizeDebit()", authorizer.AuthorizeDebit(ctx, tt.account), tt.debit)
+			check("AuthorizeManage()", authorizer.AuthorizeManage(ctx, tt.account), tt.manage)
+		})
+	}
+}
+
+func TestOwnershipAuthorizerDebitor(t *testing.T) {
+	authorizer := NewOwnershipAuthorizer(memory.NewStore().Accounts)
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		tenantID  string
+		want      string // empty for every account of the tenant
+	}{
+		{"caller", &auth.Principal{ID: "apikey:1", TenantID: "a"}, "a", "apikey:1"},
+		{"admin of the tenant", &auth.Principal{ID: "apikey:9", TenantID: "a", Roles: []string{auth.RoleAdmin}}, "a", ""},
+		{"admin of another tenant", &auth.Principal{ID: "apikey:9", TenantID: "b", Roles: []string{auth.RoleAdmin}}, "a", "apikey:9"},
+		{"bootstrap key", &auth.Principal{ID: "apikey:bootstrap", AllTenants: true, Roles: []string{auth.RoleAdmin}}, "a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			debitor, err := authorizer.Debitor(auth.WithPrincipal(context.Background(), tt.principal), tt.tenantID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if debitor != nil {
+				got = *debitor
+			}
+			if got != tt.want {
+				t.Errorf("Debitor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := authorizer.Debitor(context.Background(), "a"); !errors.Is(err, models.ErrAccessDenied) {
+		t.Errorf("Debitor() without caller = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestAPIKeyPrincipal(t *testing.T) {
+	store := memory.NewStore()
+	keys := NewAPIKeyService(store.APIKeys, "bootstrap-secret", discardLogger)
+	ctx := auth.WithPrincipal(tenant.WithTenant(context.Background(), tenant.Default),
+		&auth.Principal{ID: "apikey:bootstrap", AllTenants: true, Roles: []string{auth.RoleAdmin}})
+
+	issue := func(tenantID string, scopes ...string) string {
+		t.Helper()
+		_, raw, err := keys.IssueKey(ctx, &models.CreateAPIKeyRequest{Name: "test", TenantID: tenantID, Scopes: scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name       string
+		rawKey     string
+		tenantID   string
+		admin      bool // admin of acme
+		adminOther bool // admin of another tenant
+	}{
+		{"bootstrap key", "bootstrap-secret", "", true, true},
+		{"admin key", issue("acme", auth.ScopeAdmin), "acme", true, false},
+		{"transfer key", issue("acme", auth.ScopeTransfersWrite), "acme", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := keys.Authenticate(context.Background(), tt.rawKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.TenantID != tt.tenantID || p.IsAdminOf("acme") != tt.admin || p.IsAdminOf("other") != tt.adminOther {
+				t.Errorf("principal %+v: tenant %q, admin of acme %v, of other %v", p, p.TenantID, p.IsAdminOf("acme"), p.IsAdminOf("other"))
+			}
+		})
+	}
+
+	if _, err := keys.Authenticate(context.Background(), "itk_0000_nope"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Authenticate() of an unknown key = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/repository"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// group commit of transfers, as configured with transfers.batch_window and
+// transfers.batch_max_size. A zero window commits every transfer on its own.
+type BatchPolicy struct {
+	Window  time.Duration // how long the first transfer of a batch waits for others
+	MaxSize int           // transfers of a batch, a full batch does not wait
+	Timeout time.Duration // how long the transaction of a batch may take
+}
+
+// returned by the batcher once it was closed, transfers then run on their own
+var errBatcherClosed = errors.New("transfer batcher closed")
+
+// a transfer waiting for its batch
+type batchJob struct {
+	ctx  context.Context
+	opts repository.TxOptions
+	fn   func(ctx context.Context) error
+	done chan error
+	// set by whichever comes first, the batch handing over the outcome or
+	// the caller giving up on it, so that only one side does
+	settled atomic.Bool
+}
+
+// collects transfers arriving close together and runs those of the same
+// tenants in one transaction, each in a savepoint of its own, so that they
+// share a commit. Batches run one after the other, transfers arriving
+// meanwhile make up the next one.
+type transferBatcher struct {
+	uow    repository.UnitOfWork
+	policy BatchPolicy
+	jobs   chan *batchJob
+	logger *slog.Logger
+
+	// held to queue a job, so that none is queued once closed is set
+	mu      sync.RWMutex
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newTransferBatcher(uow repository.UnitOfWork, policy BatchPolicy, logger *slog.Logger) *transferBatcher {
+	b := &transferBatcher{
+		uow:     uow,
+		policy:  policy,
+		jobs:    make(chan *batchJob, policy.MaxSize),
+		logger:  logger,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// run fn in the next batch and return its outcome: the error of fn, or of
+// the commit when fn succeeded. When ctx ends first the error of ctx is
+// returned, although a transfer that was queued may still be applied.
+func (b *transferBatcher) do(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	job := &batchJob{ctx: ctx, opts: opts, fn: fn, done: make(chan error, 1)}
+	if err := b.enqueue(ctx, job); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-job.done:
+		return err
+	case <-ctx.Done():
+		if !job.settled.CompareAndSwap(false, true) {
+			return <-job.done
+		}
+		metrics.TransferBatchAbandoned.Inc()
+		b.logger.WarnContext(ctx, "stopped waiting for the batch of a transfer, it may still be applied",
+			slog.String("error", ctx.Err().Error()),
+		)
+		return ctx.Err()
+	}
+}
+
+func (b *transferBatcher) enqueue(ctx context.Context, job *batchJob) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errBatcherClosed
+	}
+	select {
+	case b.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop taking transfers and wait until the queued ones were committed, or
+// until ctx ends
+func (b *transferBatcher) close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *transferBatcher) loop() {
+	defer close(b.stopped)
+	for {
+		var first *batchJob
+		select {
+		case first = <-b.jobs:
+		case <-b.stop:
+			b.drain()
+			return
+		}
+
+		batch := []*batchJob{first}
+		window := time.NewTimer(b.policy.Window)
+	collect:
+		for len(batch) < b.policy.MaxSize {
+			select {
+			case job := <-b.jobs:
+				batch = append(batch, job)
+			case <-window.C:
+				break collect
+			}
+		}
+		window.Stop()
+		b.flushAll(batch)
+	}
+}
+
+// commit the jobs queued before the batcher was closed, no more can arrive
+func (b *transferBatcher) drain() {
+	var batch []*batchJob
+	for {
+		select {
+		case job := <-b.jobs:
+			batch = append(batch, job)
+		default:
+			b.flushAll(batch)
+			return
+		}
+	}
+}
+
+func (b *transferBatcher) flushAll(batch []*batchJob) {
+	for _, group := range groupByTenants(batch) {
+		b.flush(group)
+	}
+}
+
+// split a batch into the transfers of each tenant set, in order of arrival.
+// A transaction only sees the rows of its tenants, so transfers sharing one
+// must not reach the rows of tenants other than their own.
+func groupByTenants(batch []*batchJob) [][]*batchJob {
+	var groups [][]*batchJob
+	index := make(map[string]int)
+	for _, job := range batch {
+		key := strings.Join(slices.Compact(slices.Sorted(slices.Values(job.opts.TenantIDs))), "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], job)
+	}
+	return groups
+}
+
+// run transfers of the same tenants in one transaction. A transfer that
+// fails only rolls back its savepoint, a failed commit fails every transfer
+// that had succeeded. The transaction is cancelled after the batch timeout, so
+// a database that stops answering does not hold up the batches after it.
+func (b *transferBatcher) flush(batch []*batchJob) {
+	metrics.TransferBatchSize.Observe(float64(len(batch)))
+
+	// every transfer of a batch runs in the same concurrency mode
+	opts := repository.TxOptions{TenantIDs: batch[0].opts.TenantIDs, Isolation: batch[0].opts.Isolation}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.policy.Timeout)
+	defer cancel()
+
+	results := make([]error, len(batch))
+	err := b.uow.Do(ctx, opts, func(ctx context.Context) error {
+		for i, job := range batch {
+			if err := job.ctx.Err(); err != nil {
+				results[i] = err
+				continue
+			}
+			results[i] = b.uow.Do(batchContext{ctx, job.ctx}, job.opts, job.fn)
+		}
+		return nil
+	})
+
+	for i, job := range batch {
+		if err != nil && results[i] == nil {
+			results[i] = err
+		}
+		if !job.settled.CompareAndSwap(false, true) && results[i] == nil {
+			b.logger.WarnContext(job.ctx, "transfer applied after its caller stopped waiting for it")
+		}
+		job.done <- results[i]
+	}
+}
+
+// the values of a transfer's context, like its tenant, principal and span,
+// with the transaction and lifetime of its batch. A transfer whose client
+// goes away must not cancel the queries of the others.
+type batchContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c batchContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.values.Value(key)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/memory"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unit of work recording the tenants of the transactions it begins, not
+// of the savepoints within them
+type scopeRecorder struct {
+	repository.UnitOfWork
+	mu     sync.Mutex
+	scopes [][]string
+}
+
+func (u *scopeRecorder) Do(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	if _, nested := ctx.(batchContext); !nested {
+		u.mu.Lock()
+		u.scopes = append(u.scopes, opts.TenantIDs)
+		u.mu.Unlock()
+	}
+	return u.UnitOfWork.Do(ctx, opts, fn)
+}
+
+func TestBatchKeepsTenantsApart(t *testing.T) {
+	uow := &scopeRecorder{UnitOfWork: memory.NewStore().UnitOfWork}
+	batcher := newTransferBatcher(uow, BatchPolicy{Window: 50 * time.Millisecond, MaxSize: 100, Timeout: time.Second}, discardLogger)
+	t.Cleanup(func() { batcher.close(context.Background()) })
+
+	tenantSets := [][]string{{"a"}, {"b"}, {"c", "d"}, {"a"}, {"d", "c"}, {"b"}}
+	var wg sync.WaitGroup
+	for _, tenants := range tenantSets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := batcher.do(context.Background(), repository.TxOptions{TenantIDs: tenants}, func(context.Context) error { return nil })
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(uow.scopes) < 3 {
+		t.Errorf("got %d transactions, want one per tenant set at least", len(uow.scopes))
+	}
+	for _, scope := range uow.scopes {
+		sorted := slices.Sorted(slices.Values(scope))
+		if !slices.Equal(sorted, []string{"a"}) && !slices.Equal(sorted, []string{"b"}) && !slices.Equal(sorted, []string{"c", "d"}) {
+			t.Errorf("transaction scoped to %v, want the tenants of a single transfer", scope)
+		}
+	}
+}
+
+// unit of work of a database that stops answering, it only returns once
+// the context of the transaction ends
+type hangingUnitOfWork struct{}
+
+func (hangingUnitOfWork) Do(ctx context.Context, _ repository.TxOptions, _ func(ctx context.Context) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBatchCallerStopsWaiting(t *testing.T) {
+	batcher := newTransferBatcher(hangingUnitOfWork{}, BatchPolicy{Window: time.Millisecond, MaxSize: 10, Timeout: time.Hour}, discardLogger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := batcher.do(ctx, repository.TxOptions{}, func(context.Context) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("do() returned after %v, want it to return at the deadline", elapsed)
+	}
+}
+
+func TestBatchTimeout(t *testing.T) {
+	batcher := newTransferBatcher(hangingUnitOfWork{}, BatchPolicy{Window: time.Millisecond, MaxSize: 10, Timeout: 20 * time.Millisecond}, discardLogger)
+	t.Cleanup(func() { batcher.close(context.Background()) })
+
+	err := batcher.do(context.Background(), repository.TxOptions{}, func(context.Context) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("do() error = %v, want the batch to time out", err)
+	}
+}
+
+func TestBatchClose(t *testing.T) {
+	uow := &scopeRecorder{UnitOfWork: memory.NewStore().UnitOfWork}
+	batcher := newTransferBatcher(uow, BatchPolicy{Window: 50 * time.Millisecond, MaxSize: 100, Timeout: time.Second}, discardLogger)
+
+	// queued before the close, committed by it
+	done := make(chan error)
+	go func() {
+		done <- batcher.do(context.Background(), repository.TxOptions{TenantIDs: []string{"a"}}, func(context.Context) error { return nil })
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := batcher.close(context.Background()); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("queued transfer error = %v, want it committed", err)
+	}
+
+	err := batcher.do(context.Background(), repository.TxOptions{}, func(context.Context) error { return nil })
+	if !errors.Is(err, errBatcherClosed) {
+		t.Errorf("do() after close error = %v, want %v", err, errBatcherClosed)
+	}
+	if err := batcher.close(context.Background()); err != nil {
+		t.Errorf("second close() error = %v", err)
+	}
+}
+
+func TestClosedServiceTransfersOnItsOwn(t *testing.T) {
+	transfers, ctx := newTestService(t, ConcurrencyLocking, false, BatchPolicy{Window: time.Millisecond, MaxSize: 10, Timeout: time.Second}, 10, 0)
+	if err := transfers.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := transfers.ExecuteTransfer(ctx, transferRequest(1, 2, 5)); err != nil {
+		t.Errorf("ExecuteTransfer() after Close error = %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/models"
+)
+
+// count the outcome of a transfer, err being the reason it did not complete
+func recordOutcome(kind string, transaction *models.Transaction, err error) {
+	metrics.TransferOutcomes.WithLabelValues(kind, transferOutcome(err)).Inc()
+	if err == nil {
+		metrics.TransferAmount.Observe(transaction.Amount.InexactFloat64())
+	}
+}
+
+// the outcome label of a transfer that ended with err
+func transferOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeCompleted
+	case errors.Is(err, models.ErrInsufficientBalance):
+		return metrics.OutcomeInsufficient
+	case errors.Is(err, models.ErrAccessDenied), errors.Is(err, models.ErrCrossTenantTransfer):
+		return metrics.OutcomeDenied
+	case errors.Is(err, models.ErrTransferConflict):
+		return metrics.OutcomeConflict
+	case errors.Is(err, models.ErrInvalidAmount),
+		errors.Is(err, models.ErrInvalidAccountID),
+		errors.Is(err, models.ErrInvalidTenantID),
+		errors.Is(err, models.ErrSelfTransfer),
+		errors.Is(err, models.ErrAccountNotFound),
+		errors.Is(err, models.ErrTransactionNotFound),
+		errors.Is(err, models.ErrAlreadyReversed),
+		errors.Is(err, models.ErrNotReversible):
+		return metrics.OutcomeRejected
+	}
+	return metrics.OutcomeError
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/tenant"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+)
+
+// transfer service over a fresh memory store, with accounts of the given
+// balances numbered from 1 in the tenant of the returned context
+func newTestService(t testing.TB, concurrency Concurrency, singleStatement bool, batch BatchPolicy, balances ...int64) (TransferService, context.Context) {
+	t.Helper()
+	store, ctx := newTestStore(t, balances...)
+	return newStoreService(store, concurrency, singleStatement, batch), ctx
+}
+
+// memory store with accounts of the given balances numbered from 1 in the
+// tenant of the returned context
+func newTestStore(t testing.TB, balances ...int64) (*repository.Store, context.Context) {
+	t.Helper()
+	store := memory.NewStore()
+	ctx := tenant.WithTenant(context.Background(), "test")
+	for i, balance := range balances {
+		err := store.Accounts.Create(ctx, &models.Account{
+			TenantID:  "test",
+			AccountID: int64(i + 1),
+			Balance:   decimal.NewFromInt(balance),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, ctx
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newStoreService(store *repository.Store, concurrency Concurrency, singleStatement bool, batch BatchPolicy) TransferService {
+	return NewTransferService(store.UnitOfWork, store.Accounts, store.Transactions,
+		NewAllowAllAuthorizer(), CrossTenantPolicy{}, concurrency, singleStatement,
+		RetryPolicy{Attempts: 10, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		batch,
+		AsyncPolicy{Lease: time.Minute, MaxAttempts: 1},
+		discardLogger)
+}
+
+func transferRequest(source, destination, amount int64) *models.CreateTransactionRequest {
+	return &models.CreateTransactionRequest{
+		SourceAccountID:      source,
+		DestinationAccountID: destination,
+		Amount:               decimal.NewFromInt(amount),
+	}
+}
+
+func TestTransferOutcomes(t *testing.T) {
+	transfers, ctx := newTestService(t, ConcurrencyLocking, false, BatchPolicy{}, 10, 0)
+
+	process := func() {
+		t.Helper()
+		if processed, err := transfers.ProcessQueued(ctx); !processed || err != nil {
+			t.Fatalf("ProcessQueued() = %v, %v", processed, err)
+		}
+	}
+
+	var first *models.Transaction
+	steps := []struct {
+		name    string
+		run     func()
+		kind    string
+		outcome string
+	}{
+		{"completed", func() { first, _ = transfers.ExecuteTransfer(ctx, transferRequest(1, 2, 2)) }, metrics.KindTransfer, metrics.OutcomeCompleted},
+		{"insufficient", func() { transfers.ExecuteTransfer(ctx, transferRequest(1, 2, 100)) }, metrics.KindTransfer, metrics.OutcomeInsufficient},
+		{"unknown account", func() { transfers.ExecuteTransfer(ctx, transferRequest(1, 9, 1)) }, metrics.KindTransfer, metrics.OutcomeRejected},
+		{"self transfer", func() { transfers.ExecuteTransfer(ctx, transferRequest(1, 1, 1)) }, metrics.KindTransfer, metrics.OutcomeRejected},
+		{"queued and completed", func() { transfers.SubmitTransfer(ctx, transferRequest(1, 2, 3)); process() }, metrics.KindTransfer, metrics.OutcomeCompleted},
+		{"queued and insufficient", func() { transfers.SubmitTransfer(ctx, transferRequest(1, 2, 100)); process() }, metrics.KindTransfer, metrics.OutcomeInsufficient},
+		{"queued for an unknown account", func() { transfers.SubmitTransfer(ctx, transferRequest(1, 9, 1)) }, metrics.KindTransfer, metrics.OutcomeRejected},
+		{"reversed", func() { transfers.ReverseTransfer(ctx, first.TransactionID) }, metrics.KindReversal, metrics.OutcomeCompleted},
+		{"reversed twice", func() { transfers.ReverseTransfer(ctx, first.TransactionID) }, metrics.KindReversal, metrics.OutcomeRejected},
+	}
+
+	kinds := []string{metrics.KindTransfer, metrics.KindReversal}
+	outcomes := []string{metrics.OutcomeCompleted, metrics.OutcomeInsufficient, metrics.OutcomeRejected,
+		metrics.OutcomeDenied, metrics.OutcomeConflict, metrics.OutcomeError}
+	counts := func() map[string]float64 {
+		m := make(map[string]float64)
+		for _, kind := range kinds {
+			for _, outcome := range outcomes {
+				m[kind+"/"+outcome] = testutil.ToFloat64(metrics.TransferOutcomes.WithLabelValues(kind, outcome))
+			}
+		}
+		return m
+	}
+
+	for _, step := range steps {
+		before := counts()
+		step.run()
+		after := counts()
+		for key := range after {
+			want := before[key]
+			if key == step.kind+"/"+step.outcome {
+				want++
+			}
+			if after[key] != want {
+				t.Errorf("%s: %s counted %v times, want %v", step.name, key, after[key]-before[key], want-before[key])
+			}
+		}
+	}
+}
+
+func TestTransferOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, metrics.OutcomeCompleted},
+		{models.ErrInsufficientBalance, metrics.OutcomeInsufficient},
+		{fmt.Errorf("%w: account_id 3", models.ErrAccountNotFound), metrics.OutcomeRejected},
+		{models.ErrNotReversible, metrics.OutcomeRejected},
+		{models.ErrAccessDenied, metrics.OutcomeDenied},
+		{models.ErrCrossTenantTransfer, metrics.OutcomeDenied},
+		{fmt.Errorf("%w after 3 attempts", models.ErrTransferConflict), metrics.OutcomeConflict},
+		{repository.ErrSerializationFailure, metrics.OutcomeError},
+		{context.Canceled, metrics.OutcomeError},
+		{errors.New("connection reset"), metrics.OutcomeError},
+	}
+	for _, tt := range tests {
+		if got := transferOutcome(tt.err); got != tt.want {
+			t.Errorf("transferOutcome(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// how units of work the database aborted for a conflict with concurrent ones,
+// or that found an account changed under them, are run again
+type RetryPolicy struct {
+	Attempts  int           // attempts including the first, 1 disables retries
+	BaseDelay time.Duration // backoff before the first retry, doubled for each further one
+	MaxDelay  time.Duration
+}
+
+// backoff before the given retry, starting at 1. Half of it is random so that
+// transfers that conflicted with each other do not collide again.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if retry < 32 && p.BaseDelay<<(retry-1) < delay {
+		delay = p.BaseDelay << (retry - 1)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// the reason label of a conflict, empty for other errors
+func conflictReason(err error) string {
+	switch {
+	case errors.Is(err, repository.ErrSerializationFailure):
+		return "serialization_failure"
+	case errors.Is(err, repository.ErrDeadlock):
+		return "deadlock"
+	case errors.Is(err, repository.ErrVersionConflict):
+		return "version_conflict"
+	}
+	return ""
+}
+
+// run fn in the next batch of the batcher. A transfer that conflicted within
+// its batch, or arrived once the batcher was closed, runs on its own with the
+// usual retries.
+func (s *transferService) doBatched(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	err := s.batcher.do(ctx, opts, fn)
+	if errors.Is(err, errBatcherClosed) {
+		return s.doRetried(ctx, opts, fn)
+	}
+	reason := conflictReason(err)
+	if reason == "" {
+		return err
+	}
+
+	metrics.TransferConflicts.WithLabelValues(reason, "retried").Inc()
+	s.logger.DebugContext(ctx, "transfer conflicted in its batch, running it on its own",
+		slog.String("reason", reason),
+		slog.String("error", err.Error()),
+	)
+	return s.doRetried(ctx, opts, fn)
+}
+
+// run fn in a unit of work, running it again after conflicts. fn must only
+// keep state it resets at the start of each run. When the attempts or the
+// context deadline run out, ErrTransferConflict is returned.
+func (s *transferService) doRetried(ctx context.Context, opts repository.TxOptions, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.uow.Do(ctx, opts, fn)
+		reason := conflictReason(err)
+		if reason == "" {
+			return err
+		}
+
+		logAttrs := []any{
+			slog.String("reason", reason),
+			slog.Int("attempt", attempt),
+			slog.String("error", err.Error()),
+		}
+
+		if attempt >= s.retry.Attempts {
+			metrics.TransferConflicts.WithLabelValues(reason, "exhausted").Inc()
+			s.logger.WarnContext(ctx, "transfer conflicted, giving up after the last attempt", logAttrs...)
+			return fmt.Errorf("%w after %d attempts", models.ErrTransferConflict, attempt)
+		}
+
+		// a retry that cannot finish before the deadline only adds load
+		delay := s.retry.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			metrics.TransferConflicts.WithLabelValues(reason, "deadline").Inc()
+			s.logger.WarnContext(ctx, "transfer conflicted, no time left to retry", logAttrs...)
+			return fmt.Errorf("%w after %d attempts", models.ErrTransferConflict, attempt)
+		}
+
+		metrics.TransferConflicts.WithLabelValues(reason, "retried").Inc()
+		s.logger.InfoContext(ctx, "transfer conflicted, retrying",
+			append(logAttrs, slog.Duration("backoff", delay))...,
+		)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.String("retry.reason", reason),
+			attribute.Int("retry.attempt", attempt),
+			attribute.Int64("retry.backoff_ms", delay.Milliseconds()),
+		))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
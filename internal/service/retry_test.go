@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// unit of work that returns the next error of errs on each run, nil once
+// they ran out
+type flakyUnitOfWork struct {
+	errs []error
+	runs int
+}
+
+func (u *flakyUnitOfWork) Do(ctx context.Context, _ repository.TxOptions, fn func(ctx context.Context) error) error {
+	u.runs++
+	if len(u.errs) == 0 {
+		return fn(ctx)
+	}
+	err := u.errs[0]
+	u.errs = u.errs[1:]
+	return err
+}
+
+func retryingService(uow repository.UnitOfWork, retry RetryPolicy) *transferService {
+	return &transferService{
+		uow:    uow,
+		retry:  retry,
+		logger: discardLogger,
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Attempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+
+	for retry := 1; retry <= 64; retry++ {
+		// the delay before jitter: doubled from the base up to the maximum
+		full := policy.MaxDelay
+		if retry < 5 {
+			full = policy.BaseDelay << (retry - 1)
+		}
+		for range 100 {
+			if delay := policy.backoff(retry); delay < full/2 || delay > full {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", retry, delay, full/2, full)
+			}
+		}
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	conflict := fmt.Errorf("%w: 40001", repository.ErrSerializationFailure)
+
+	tests := []struct {
+		name      string
+		attempts  int
+		conflicts int
+		wantRuns  int
+		wantErr   error
+	}{
+		{"succeeds first time", 3, 0, 1, nil},
+		{"succeeds after conflicts", 3, 2, 3, nil},
+		{"conflicts on every attempt", 3, 5, 3, models.ErrTransferConflict},
+		{"retries disabled", 1, 1, 1, models.ErrTransferConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uow := &flakyUnitOfWork{}
+			for range tt.conflicts {
+				uow.errs = append(uow.errs, conflict)
+			}
+			s := retryingService(uow, RetryPolicy{Attempts: tt.attempts, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond})
+
+			err := s.doRetried(context.Background(), repository.TxOptions{}, func(context.Context) error { return nil })
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("doRetried() error = %v, want %v", err, tt.wantErr)
+			}
+			if uow.runs != tt.wantRuns {
+				t.Errorf("ran %d times, want %d", uow.runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	uow := &flakyUnitOfWork{errs: []error{repository.ErrDeadlock, repository.ErrDeadlock}}
+	s := retryingService(uow, RetryPolicy{Attempts: 10, BaseDelay: time.Second, MaxDelay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := s.doRetried(ctx, repository.TxOptions{}, func(context.Context) error { return nil })
+	if !errors.Is(err, models.ErrTransferConflict) {
+		t.Errorf("doRetried() error = %v, want %v", err, models.ErrTransferConflict)
+	}
+	if uow.runs != 1 {
+		t.Errorf("ran %d times, want 1", uow.runs)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("doRetried() took %v, it should give up without sleeping", elapsed)
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	uow := &flakyUnitOfWork{errs: []error{repository.ErrDeadlock}}
+	s := retryingService(uow, RetryPolicy{Attempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := s.doRetried(ctx, repository.TxOptions{}, func(context.Context) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("doRetried() error = %v, want %v", err, context.Canceled)
+	}
+	if uow.runs != 1 {
+		t.Errorf("ran %d times, want 1", uow.runs)
+	}
+}
+
+func TestRetryOnlyConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		retried bool
+	}{
+		{"serialization failure", fmt.Errorf("%w: %w", repository.ErrSerializationFailure, &pgconn.PgError{Code: "40001"}), true},
+		{"deadlock", fmt.Errorf("%w: %w", repository.ErrDeadlock, &pgconn.PgError{Code: "40P01"}), true},
+		{"version conflict", fmt.Errorf("update account: %w", repository.ErrVersionConflict), true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"lock timeout", &pgconn.PgError{Code: "55P03"}, false},
+		{"insufficient balance", models.ErrInsufficientBalance, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uow := &flakyUnitOfWork{errs: []error{tt.err}}
+			s := retryingService(uow, RetryPolicy{Attempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond})
+
+			err := s.doRetried(context.Background(), repository.TxOptions{}, func(context.Context) error { return nil })
+			if tt.retried {
+				if err != nil || uow.runs != 2 {
+					t.Errorf("doRetried() = %v after %d runs, want success on the retry", err, uow.runs)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) || uow.runs != 1 {
+				t.Errorf("doRetried() = %v after %d runs, want %v without a retry", err, uow.runs, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+	"internal-transfers/internal/tenant"
+	"strings"
+)
+
+// tenant pairs that may transfer money across tenants, keyed by the source
+// tenant. Transfers within a tenant are always allowed.
+type CrossTenantPolicy map[string]map[string]bool
+
+// parse "source->destination" pairs, "*" matches any tenant
+func ParseCrossTenantPolicy(pairs []string) (CrossTenantPolicy, error) {
+	policy := make(CrossTenantPolicy)
+	for _, pair := range pairs {
+		source, destination, ok := strings.Cut(pair, "->")
+		source, destination = strings.TrimSpace(source), strings.TrimSpace(destination)
+		if !ok || !validPolicyTenant(source) || !validPolicyTenant(destination) {
+			return nil, fmt.Errorf("invalid cross-tenant pair %q, expected source->destination", pair)
+		}
+		if policy[source] == nil {
+			policy[source] = make(map[string]bool)
+		}
+		policy[source][destination] = true
+	}
+	return policy, nil
+}
+
+// check whether money may move from the source to the destination tenant
+func (p CrossTenantPolicy) Allows(source, destination string) bool {
+	if source == destination {
+		return true
+	}
+	for _, s := range []string{source, "*"} {
+		if p[s][destination] || p[s]["*"] {
+			return true
+		}
+	}
+	return false
+}
+
+func validPolicyTenant(tenantID string) bool {
+	return tenantID == "*" || tenant.ValidID(tenantID)
+}
@@ -4,185 +4,580 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/metrics"
 	"internal-transfers/internal/models"
 	"internal-transfers/internal/repository"
+	"internal-transfers/internal/requestid"
+	"internal-transfers/internal/tenant"
 	"log/slog"
+	"math/rand/v2"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TransferService interface {
 	ExecuteTransfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.Transaction, error)
+	ReverseTransfer(ctx context.Context, transactionID int64) (*models.Transaction, error)
+	ListTransactions(ctx context.Context, accountID int64, limit int, beforeID int64) ([]models.Transaction, error)
+	SubmitTransfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.Transaction, error)
+	GetTransaction(ctx context.Context, transactionID int64, wait time.Duration) (*models.Transaction, error)
+	ProcessQueued(ctx context.Context) (bool, error)
+	Close(ctx context.Context) error
 }
 
 type transferService struct {
-	db          *pgxpool.Pool
+	uow         repository.UnitOfWork
 	accountRepo repository.AccountRepository
 	txRepo      repository.TransactionRepository
-	logger      *slog.Logger
+	authorizer  Authorizer
+	crossTenant CrossTenantPolicy
+	concurrency Concurrency
+	// try transfers as a single statement first, see executeStatement
+	singleStatement bool
+	retry           RetryPolicy
+	// commits transfers in groups, nil when batching is off
+	batcher *transferBatcher
+	async   AsyncPolicy
+	logger  *slog.Logger
 }
 
 func NewTransferService(
-	db *pgxpool.Pool,
+	uow repository.UnitOfWork,
 	accountRepo repository.AccountRepository,
 	txRepo repository.TransactionRepository,
+	authorizer Authorizer,
+	crossTenant CrossTenantPolicy,
+	concurrency Concurrency,
+	singleStatement bool,
+	retry RetryPolicy,
+	batch BatchPolicy,
+	async AsyncPolicy,
 	logger *slog.Logger,
 ) TransferService {
+	var batcher *transferBatcher
+	if batch.Window > 0 {
+		batcher = newTransferBatcher(uow, batch, logger)
+	}
 	return &transferService{
-		db:          db,
-		accountRepo: accountRepo,
-		txRepo:      txRepo,
-		logger:      logger,
+		uow:             uow,
+		accountRepo:     accountRepo,
+		txRepo:          txRepo,
+		authorizer:      authorizer,
+		crossTenant:     crossTenant,
+		concurrency:     concurrency,
+		singleStatement: singleStatement,
+		retry:           retry,
+		batcher:         batcher,
+		async:           async,
+		logger:          logger,
 	}
 }
 
-func (s *transferService) ExecuteTransfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.Transaction, error) {
+// stop batching transfers once the queued ones were committed, transfers
+// arriving afterwards run on their own
+func (s *transferService) Close(ctx context.Context) error {
+	if s.batcher == nil {
+		return nil
+	}
+	return s.batcher.close(ctx)
+}
+
+// how a transfer keeps concurrent transfers of the same accounts from losing
+// updates, as configured with transfers.concurrency
+type Concurrency string
+
+const (
+	// lock both accounts with SELECT ... FOR UPDATE before reading them
+	ConcurrencyLocking Concurrency = "locking"
+	// read without locks and only write back accounts whose version did not
+	// change in the meantime
+	ConcurrencyOptimistic Concurrency = "optimistic"
+	// read without locks at serializable isolation, the database aborts
+	// conflicting transfers
+	ConcurrencySerializable Concurrency = "serializable"
+)
+
+// account identity within the whole deployment
+type accountKey struct {
+	tenantID  string
+	accountID int64
+}
+
+// total order used to lock accounts without deadlocks
+func (k accountKey) less(other accountKey) bool {
+	if k.tenantID != other.tenantID {
+		return k.tenantID < other.tenantID
+	}
+	return k.accountID < other.accountID
+}
+
+func (s *transferService) ExecuteTransfer(ctx context.Context, req *models.CreateTransactionRequest) (transaction *models.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "TransferService.ExecuteTransfer", trace.WithAttributes(
+		attribute.Int64("transfer.source_account_id", req.SourceAccountID),
+		attribute.Int64("transfer.destination_account_id", req.DestinationAccountID),
+	))
+	defer func() {
+		recordOutcome(metrics.KindTransfer, transaction, err)
+		endSpan(span, err)
+	}()
+
+	source, destination, err := s.transferAccounts(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.transfer(ctx, source, destination, req.Amount, nil, 0)
+}
+
+// check a transfer request and resolve its accounts
+func (s *transferService) transferAccounts(ctx context.Context, req *models.CreateTransactionRequest) (source, destination accountKey, err error) {
 	// validate amount is positive
 	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		s.logger.Warn("attempted transfer with invalid amount",
+		s.logger.WarnContext(ctx, "attempted transfer with invalid amount",
 			slog.String("amount", req.Amount.String()),
 		)
-		return nil, models.ErrInvalidAmount
+		return source, destination, models.ErrInvalidAmount
+	}
+
+	// Source accounts always belong to the caller's tenant
+	source = accountKey{tenant.FromContext(ctx), req.SourceAccountID}
+	destination = accountKey{source.tenantID, req.DestinationAccountID}
+	if req.DestinationTenantID != "" {
+		if !tenant.ValidID(req.DestinationTenantID) {
+			return source, destination, fmt.Errorf("%w: %q", models.ErrInvalidTenantID, req.DestinationTenantID)
+		}
+		destination.tenantID = req.DestinationTenantID
+	}
+
+	if !s.crossTenant.Allows(source.tenantID, destination.tenantID) {
+		s.logger.WarnContext(ctx, "attempted cross-tenant transfer",
+			slog.String("tenant_id", source.tenantID),
+			slog.String("destination_tenant_id", destination.tenantID),
+		)
+		return source, destination, fmt.Errorf("%w: %s to %s", models.ErrCrossTenantTransfer, source.tenantID, destination.tenantID)
 	}
 
 	// Validate source and destination are different
-	if req.SourceAccountID == req.DestinationAccountID {
-		s.logger.Warn("attempted self-transfer",
+	if source == destination {
+		s.logger.WarnContext(ctx, "attempted self-transfer",
 			slog.Int64("account_id", req.SourceAccountID),
 		)
-		return nil, models.ErrSelfTransfer
+		return source, destination, models.ErrSelfTransfer
 	}
 
 	// Validate account IDs are positive
 	if req.SourceAccountID <= 0 {
-		return nil, fmt.Errorf("%w: source account_id %d", models.ErrInvalidAccountID, req.SourceAccountID)
+		return source, destination, fmt.Errorf("%w: source account_id %d", models.ErrInvalidAccountID, req.SourceAccountID)
 	}
 	if req.DestinationAccountID <= 0 {
-		return nil, fmt.Errorf("%w: destination account_id %d", models.ErrInvalidAccountID, req.DestinationAccountID)
+		return source, destination, fmt.Errorf("%w: destination account_id %d", models.ErrInvalidAccountID, req.DestinationAccountID)
 	}
 
-	// Begin database transaction
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		s.logger.Error("failed to begin transaction", slog.String("error", err.Error()))
-		return nil, err
+	return source, destination, nil
+}
+
+// move amount from source to destination, locking both accounts in a
+// consistent order. A failed attempt because of the balance is recorded too.
+// A queued transfer passes the ID of its pending record, which is finished
+// instead of recording a new one.
+func (s *transferService) transfer(ctx context.Context, source, destination accountKey, amount decimal.Decimal, reversalOf *int64, pendingID int64) (*models.Transaction, error) {
+	// Record who initiated the transfer
+	var initiatedBy *string
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		initiatedBy = &principal.ID
+	}
+	var requestID *string
+	if id := requestid.FromContext(ctx); id != "" {
+		requestID = &id
 	}
-	defer tx.Rollback(ctx)
 
-	firstID, secondID := req.SourceAccountID, req.DestinationAccountID
-	if firstID > secondID {
-		firstID, secondID = secondID, firstID
+	if s.singleStatement && pendingID == 0 {
+		errorMsg := insufficientMessage
+		transaction := &models.Transaction{
+			TenantID:             source.tenantID,
+			SourceAccountID:      source.accountID,
+			DestinationTenantID:  destination.tenantID,
+			DestinationAccountID: destination.accountID,
+			Amount:               amount,
+			ErrorMessage:         &errorMsg,
+			InitiatedBy:          initiatedBy,
+			RequestID:            requestID,
+			ReversalOf:           reversalOf,
+		}
+		if executed, err := s.executeStatement(ctx, transaction); executed {
+			if err != nil {
+				return nil, err
+			}
+			s.logCompleted(ctx, transaction)
+			return transaction, nil
+		}
 	}
 
-	firstAccount, err := s.accountRepo.GetByIDForUpdate(ctx, tx, firstID)
-	if err != nil {
-		s.logger.Error("failed to lock first account",
-			slog.Int64("account_id", firstID),
-			slog.String("error", err.Error()),
-		)
-		if errors.Is(err, models.ErrAccountNotFound) {
-			return nil, fmt.Errorf("%w: account_id %d", models.ErrAccountNotFound, firstID)
+	// Run in one transaction, scoped to both tenants for row level security.
+	// The body runs again when the database aborts it for a conflict. With
+	// batching on it runs in a savepoint of a transaction shared with others.
+	var transaction *models.Transaction
+	var insufficient bool
+	opts := repository.TxOptions{TenantIDs: []string{source.tenantID, destination.tenantID}}
+	if s.concurrency == ConcurrencySerializable {
+		opts.Isolation = repository.Serializable
+	}
+	attempt := func(ctx context.Context) error {
+		transaction, insufficient = nil, false
+
+		first, second := source, destination
+		if second.less(first) {
+			first, second = second, first
 		}
-		return nil, err
+
+		// Read both accounts in lock order, together with the shards of hot
+		// accounts, so every lock of the first is taken before the second
+		accounts := make(map[accountKey]*transferAccount, 2)
+		for _, key := range []accountKey{first, second} {
+			account, err := s.readAccount(ctx, key, key == source, amount)
+			if err != nil {
+				if conflictReason(err) != "" {
+					return err
+				}
+				s.logger.ErrorContext(ctx, "failed to read account",
+					slog.String("tenant_id", key.tenantID),
+					slog.Int64("account_id", key.accountID),
+					slog.String("error", err.Error()),
+				)
+				if errors.Is(err, models.ErrAccountNotFound) {
+					return fmt.Errorf("%w: account_id %d", models.ErrAccountNotFound, key.accountID)
+				}
+				return err
+			}
+			accounts[key] = account
+		}
+		sourceAccount := accounts[source]
+
+		// Check the caller may move money out of the source account
+		if err := s.authorizer.AuthorizeDebit(ctx, sourceAccount.Account); err != nil {
+			s.logger.WarnContext(ctx, "transfer denied",
+				slog.Int64("source_account", source.accountID),
+				slog.String("error", err.Error()),
+			)
+			return err
+		}
+
+		// Check if source account has sufficient balance
+		if sourceAccount.available().LessThan(amount) {
+			s.logger.WarnContext(ctx, "insufficient balance for transfer",
+				slog.Int64("source_account", source.accountID),
+				slog.String("balance", sourceAccount.Balance.String()),
+				slog.String("amount", amount.String()),
+			)
+
+			errorMsg := insufficientMessage
+			failedTx := &models.Transaction{
+				TenantID:             source.tenantID,
+				SourceAccountID:      source.accountID,
+				DestinationTenantID:  destination.tenantID,
+				DestinationAccountID: destination.accountID,
+				Amount:               amount,
+				Status:               models.TransactionStatusFailed,
+				ErrorMessage:         &errorMsg,
+				InitiatedBy:          initiatedBy,
+				RequestID:            requestID,
+				ReversalOf:           reversalOf,
+			}
+			if pendingID != 0 {
+				failedTx.TransactionID = pendingID
+				if err := s.txRepo.Finish(ctx, failedTx); err != nil {
+					return err
+				}
+			} else if _, err := s.txRepo.Create(ctx, failedTx); err != nil {
+				s.logger.ErrorContext(ctx, "failed to create failed transaction record",
+					slog.String("error", err.Error()),
+				)
+				return err
+			}
+			insufficient = true
+
+			return nil
+		}
+
+		// Update balances in lock order, conditional updates lock the rows too
+		for _, key := range []accountKey{first, second} {
+			change := amount
+			if key == source {
+				change = amount.Neg()
+			}
+			account := accounts[key]
+			if err := s.writeBalance(ctx, account, change); err != nil {
+				if conflictReason(err) == "" {
+					s.logger.ErrorContext(ctx, "failed to update account balance",
+						slog.String("tenant_id", account.TenantID),
+						slog.Int64("account_id", account.AccountID),
+						slog.String("error", err.Error()),
+					)
+				}
+				return err
+			}
+		}
+
+		transaction = &models.Transaction{
+			TenantID:             source.tenantID,
+			SourceAccountID:      source.accountID,
+			DestinationTenantID:  destination.tenantID,
+			DestinationAccountID: destination.accountID,
+			Amount:               amount,
+			Status:               models.TransactionStatusCompleted,
+			InitiatedBy:          initiatedBy,
+			RequestID:            requestID,
+			ReversalOf:           reversalOf,
+		}
+
+		if pendingID != 0 {
+			transaction.TransactionID = pendingID
+			return s.txRepo.Finish(ctx, transaction)
+		}
+
+		transactionID, err := s.txRepo.Create(ctx, transaction)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to create transaction record",
+				slog.String("error", err.Error()),
+			)
+			return err
+		}
+
+		transaction.TransactionID = transactionID
+		return nil
 	}
 
-	secondAccount, err := s.accountRepo.GetByIDForUpdate(ctx, tx, secondID)
+	var err error
+	if s.batcher != nil {
+		err = s.doBatched(ctx, opts, attempt)
+	} else {
+		err = s.doRetried(ctx, opts, attempt)
+	}
 	if err != nil {
-		s.logger.Error("failed to lock second account",
-			slog.Int64("account_id", secondID),
-			slog.String("error", err.Error()),
-		)
-		if errors.Is(err, models.ErrAccountNotFound) {
-			return nil, fmt.Errorf("%w: account_id %d", models.ErrAccountNotFound, secondID)
-		}
 		return nil, err
 	}
+	if insufficient {
+		return nil, models.ErrInsufficientBalance
+	}
 
-	// Map accounts to source and destination
-	var sourceAccount, destAccount *models.Account
-	if firstID == req.SourceAccountID {
-		sourceAccount = firstAccount
-		destAccount = secondAccount
-	} else {
-		sourceAccount = secondAccount
-		destAccount = firstAccount
+	s.logCompleted(ctx, transaction)
+	return transaction, nil
+}
+
+// error message recorded with transfers the source cannot cover
+const insufficientMessage = "insufficient balance!"
+
+// execute the transfer as a single statement, which is one round trip
+// instead of one per query. It reports false when the statement could not
+// decide the transfer, hot or missing accounts and debits the caller may not
+// make, or lost a conflict, and the transfer has to run the usual way.
+func (s *transferService) executeStatement(ctx context.Context, transaction *models.Transaction) (bool, error) {
+	debitor, err := s.authorizer.Debitor(ctx, transaction.TenantID)
+	if err != nil {
+		return false, nil
 	}
 
-	// Check if source account has sufficient balance
-	if sourceAccount.Balance.LessThan(req.Amount) {
-		s.logger.Warn("insufficient balance for transfer",
-			slog.Int64("source_account", req.SourceAccountID),
-			slog.String("balance", sourceAccount.Balance.String()),
-			slog.String("amount", req.Amount.String()),
+	err = s.txRepo.Execute(ctx, transaction, debitor)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, models.ErrInsufficientBalance):
+		s.logger.WarnContext(ctx, "insufficient balance for transfer",
+			slog.Int64("source_account", transaction.SourceAccountID),
+			slog.String("amount", transaction.Amount.String()),
 		)
+		return true, err
+	case errors.Is(err, repository.ErrNotExecuted), conflictReason(err) != "":
+		return false, nil
+	}
 
-		errorMsg := "insufficient balance!"
-		failedTx := &models.Transaction{
-			SourceAccountID:      req.SourceAccountID,
-			DestinationAccountID: req.DestinationAccountID,
-			Amount:               req.Amount,
-			Status:               models.TransactionStatusFailed,
-			ErrorMessage:         &errorMsg,
-		}
-		s.txRepo.Create(ctx, tx, failedTx)
-		tx.Commit(ctx)
+	s.logger.ErrorContext(ctx, "failed to execute transfer statement",
+		slog.String("error", err.Error()),
+	)
+	return true, err
+}
 
-		return nil, models.ErrInsufficientBalance
+func (s *transferService) logCompleted(ctx context.Context, transaction *models.Transaction) {
+	s.logger.InfoContext(ctx, "transfer completed successfully",
+		slog.Int64("transaction_id", transaction.TransactionID),
+		slog.String("tenant_id", transaction.TenantID),
+		slog.Int64("source_account", transaction.SourceAccountID),
+		slog.String("destination_tenant_id", transaction.DestinationTenantID),
+		slog.Int64("destination_account", transaction.DestinationAccountID),
+		slog.String("amount", transaction.Amount.String()),
+	)
+}
+
+// an account taking part in a transfer
+type transferAccount struct {
+	*models.Account
+	// shards of a hot account the transfer locked, the ones it changes
+	shards []models.AccountShard
+}
+
+// the balance the transfer can debit
+func (a *transferAccount) available() decimal.Decimal {
+	if a.Shards == 0 {
+		return a.Balance
+	}
+	total := decimal.Zero
+	for _, shard := range a.shards {
+		total = total.Add(shard.Balance)
 	}
+	return total
+}
 
-	// Calculate new balances
-	newSourceBalance := sourceAccount.Balance.Sub(req.Amount)
-	newDestBalance := destAccount.Balance.Add(req.Amount)
+// read an account for a transfer, locking it unless the transfer relies on
+// versions or serializable isolation instead. Hot accounts always lock the
+// shards the transfer changes instead of the account.
+func (s *transferService) readAccount(ctx context.Context, key accountKey, debit bool, amount decimal.Decimal) (*transferAccount, error) {
+	var account *models.Account
+	var err error
+	if s.concurrency == ConcurrencyLocking {
+		account, err = s.accountRepo.GetByIDForUpdate(ctx, key.tenantID, key.accountID)
+	} else {
+		account, err = s.accountRepo.GetByID(ctx, key.tenantID, key.accountID)
+	}
+	if err != nil || account.Shards == 0 {
+		return &transferAccount{Account: account}, err
+	}
 
-	// Update balances
-	err = s.accountRepo.UpdateBalance(ctx, tx, req.SourceAccountID, newSourceBalance)
-	if err != nil {
-		s.logger.Error("failed to update source account balance",
-			slog.Int64("account_id", req.SourceAccountID),
-			slog.String("error", err.Error()),
-		)
+	shards, err := s.lockShards(ctx, account, debit, amount)
+	return &transferAccount{Account: account, shards: shards}, err
+}
+
+// lock the shards of a hot account a transfer changes. Credits go to a random
+// shard. Debits take a single shard holding the whole amount when one is
+// free, and otherwise wait for every shard.
+func (s *transferService) lockShards(ctx context.Context, account *models.Account, debit bool, amount decimal.Decimal) ([]models.AccountShard, error) {
+	var shard *models.AccountShard
+	var err error
+	if debit {
+		shard, err = s.accountRepo.GetFundedShardForUpdate(ctx, account.TenantID, account.AccountID, amount)
+	} else {
+		shard, err = s.accountRepo.GetShardForUpdate(ctx, account.TenantID, account.AccountID, rand.IntN(account.Shards))
+	}
+	if err == nil {
+		return []models.AccountShard{*shard}, nil
+	}
+	if !errors.Is(err, repository.ErrShardNotFound) {
 		return nil, err
 	}
+	if !debit {
+		return nil, fmt.Errorf("%w: account %d was resharded", repository.ErrVersionConflict, account.AccountID)
+	}
 
-	err = s.accountRepo.UpdateBalance(ctx, tx, req.DestinationAccountID, newDestBalance)
+	shards, err := s.accountRepo.ListShardsForUpdate(ctx, account.TenantID, account.AccountID)
 	if err != nil {
-		s.logger.Error("failed to update destination account balance",
-			slog.Int64("account_id", req.DestinationAccountID),
-			slog.String("error", err.Error()),
-		)
 		return nil, err
 	}
+	if len(shards) != account.Shards {
+		return nil, fmt.Errorf("%w: account %d was resharded", repository.ErrVersionConflict, account.AccountID)
+	}
+	return shards, nil
+}
+
+// apply a balance change to an account read with readAccount. Debits of hot
+// accounts drain the locked shards in order.
+func (s *transferService) writeBalance(ctx context.Context, account *transferAccount, change decimal.Decimal) error {
+	if account.Shards == 0 {
+		balance := account.Balance.Add(change)
+		if s.concurrency == ConcurrencyOptimistic {
+			return s.accountRepo.UpdateBalanceIfVersion(ctx, account.TenantID, account.AccountID, balance, account.Version)
+		}
+		return s.accountRepo.UpdateBalance(ctx, account.TenantID, account.AccountID, balance)
+	}
 
-	transaction := &models.Transaction{
-		SourceAccountID:      req.SourceAccountID,
-		DestinationAccountID: req.DestinationAccountID,
-		Amount:               req.Amount,
-		Status:               models.TransactionStatusCompleted,
+	for _, shard := range account.shards {
+		if change.IsZero() {
+			break
+		}
+		// a shard never goes below zero
+		delta := change
+		if delta.IsNegative() && shard.Balance.LessThan(delta.Neg()) {
+			delta = shard.Balance.Neg()
+		}
+		if err := s.accountRepo.UpdateShardBalance(ctx, account.TenantID, account.AccountID, shard.Shard, shard.Balance.Add(delta)); err != nil {
+			return err
+		}
+		change = change.Sub(delta)
 	}
+	return nil
+}
+
+// move the amount of a completed transfer back to its source. The reversal
+// debits the original destination, so the caller must be allowed to do that.
+func (s *transferService) ReverseTransfer(ctx context.Context, transactionID int64) (reversal *models.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "TransferService.ReverseTransfer", trace.WithAttributes(
+		attribute.Int64("transfer.reversal_of", transactionID),
+	))
+	defer func() {
+		recordOutcome(metrics.KindReversal, reversal, err)
+		endSpan(span, err)
+	}()
 
-	transactionID, err := s.txRepo.Create(ctx, tx, transaction)
+	original, err := s.txRepo.GetByID(ctx, tenant.FromContext(ctx), transactionID)
 	if err != nil {
-		s.logger.Error("failed to create transaction record",
-			slog.String("error", err.Error()),
-		)
+		if errors.Is(err, models.ErrTransactionNotFound) {
+			return nil, fmt.Errorf("%w: transaction_id %d", models.ErrTransactionNotFound, transactionID)
+		}
 		return nil, err
 	}
 
-	transaction.TransactionID = transactionID
+	if original.Status != models.TransactionStatusCompleted {
+		return nil, fmt.Errorf("%w: transaction %d is %s", models.ErrNotReversible, transactionID, original.Status)
+	}
+	if original.ReversalOf != nil {
+		return nil, fmt.Errorf("%w: transaction %d is itself a reversal", models.ErrNotReversible, transactionID)
+	}
+
+	// the reversal moves money the other way, which the policy may not allow
+	source := accountKey{original.DestinationTenantID, original.DestinationAccountID}
+	destination := accountKey{original.TenantID, original.SourceAccountID}
+	if !s.crossTenant.Allows(source.tenantID, destination.tenantID) {
+		s.logger.WarnContext(ctx, "attempted cross-tenant reversal",
+			slog.String("tenant_id", source.tenantID),
+			slog.String("destination_tenant_id", destination.tenantID),
+		)
+		return nil, fmt.Errorf("%w: %s to %s", models.ErrCrossTenantTransfer, source.tenantID, destination.tenantID)
+	}
 
-	err = tx.Commit(ctx)
+	reversal, err = s.transfer(ctx, source, destination, original.Amount, &original.TransactionID, 0)
 	if err != nil {
-		s.logger.Error("failed to commit transaction", slog.String("error", err.Error()))
 		return nil, err
 	}
 
-	s.logger.Info("transfer completed successfully",
-		slog.Int64("transaction_id", transactionID),
-		slog.Int64("source_account", req.SourceAccountID),
-		slog.Int64("destination_account", req.DestinationAccountID),
-		slog.String("amount", req.Amount.String()),
+	s.logger.InfoContext(ctx, "transfer reversed",
+		slog.Int64("transaction_id", reversal.TransactionID),
+		slog.Int64("reversal_of", transactionID),
 	)
+	return reversal, nil
+}
 
-	return transaction, nil
+// list the transfers of an account in the caller's tenant, newest first
+func (s *transferService) ListTransactions(ctx context.Context, accountID int64, limit int, beforeID int64) ([]models.Transaction, error) {
+	if accountID <= 0 {
+		return nil, models.ErrInvalidAccountID
+	}
+
+	tenantID := tenant.FromContext(ctx)
+
+	// an unknown account is reported rather than listed as empty
+	if _, err := s.accountRepo.GetByID(ctx, tenantID, accountID); err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.txRepo.ListByAccount(ctx, tenantID, accountID, limit, beforeID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list transactions",
+			slog.Int64("account_id", accountID),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	return transactions, nil
 }
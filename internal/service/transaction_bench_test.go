@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"internal-transfers/internal/migrate"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/tenant"
+	"internal-transfers/migrations"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// accounts the benchmark transfers pick from, fewer mean more contention
+const benchAccounts = 10
+
+// transfers in flight per -cpu, enough for group commit to fill its batches
+const benchParallelism = 8
+
+// store the benchmarks run against, the Postgres database named by
+// TEST_DATABASE_URL or else a memory store
+func benchStore(b *testing.B) *repository.Store {
+	b.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		return memory.NewStore()
+	}
+
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(db.Close)
+	migrator, err := migrate.New(db, migrations.FS, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	return repository.NewPostgresStore(db, repository.ReadCommitted)
+}
+
+// transfers of 1 between random accounts through each strategy, run by
+// benchParallelism goroutines per -cpu. Runs against Postgres leave their accounts
+// behind in tenants of their own.
+func BenchmarkTransfer(b *testing.B) {
+	store := benchStore(b)
+
+	strategies := []struct {
+		name            string
+		concurrency     Concurrency
+		singleStatement bool
+		batch           BatchPolicy
+	}{
+		{"locking", ConcurrencyLocking, false, BatchPolicy{}},
+		{"optimistic", ConcurrencyOptimistic, false, BatchPolicy{}},
+		{"serializable", ConcurrencySerializable, false, BatchPolicy{}},
+		{"single_statement", ConcurrencyLocking, true, BatchPolicy{}},
+		{"batched", ConcurrencyLocking, false, BatchPolicy{Window: 2 * time.Millisecond, MaxSize: 100, Timeout: 5 * time.Second}},
+	}
+
+	for _, strategy := range strategies {
+		b.Run(strategy.name, func(b *testing.B) {
+			ctx := benchTenant(b, store, int64(b.N))
+			transfers := newStoreService(store, strategy.concurrency, strategy.singleStatement, strategy.batch)
+
+			var conflicts atomic.Int64
+			b.SetParallelism(benchParallelism)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					source := mathrand.Int64N(benchAccounts) + 1
+					destination := mathrand.Int64N(benchAccounts-1) + 1
+					if destination >= source {
+						destination++
+					}
+					_, err := transfers.ExecuteTransfer(ctx, transferRequest(source, destination, 1))
+					switch {
+					case errors.Is(err, models.ErrTransferConflict):
+						conflicts.Add(1)
+					case err != nil:
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conflicts.Load())/float64(b.N), "conflicts/op")
+		})
+	}
+}
+
+// context of a fresh tenant with benchAccounts accounts, each holding enough
+// for n transfers, so transfers only fail for contention
+func benchTenant(b *testing.B, store *repository.Store, n int64) context.Context {
+	b.Helper()
+	id := make([]byte, 6)
+	rand.Read(id)
+	tenantID := "bench-" + hex.EncodeToString(id)
+	ctx := tenant.WithTenant(context.Background(), tenantID)
+
+	for i := int64(1); i <= benchAccounts; i++ {
+		err := store.Accounts.Create(ctx, &models.Account{
+			TenantID:  tenantID,
+			AccountID: i,
+			Balance:   decimal.NewFromInt(n),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return ctx
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/tenant"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// transaction repository failing to record failed transfers
+type failingAuditRepository struct {
+	repository.TransactionRepository
+}
+
+var errAuditFailed = errors.New("audit insert failed")
+
+func (r failingAuditRepository) Create(ctx context.Context, transaction *models.Transaction) (int64, error) {
+	if transaction.Status == models.TransactionStatusFailed {
+		return 0, errAuditFailed
+	}
+	return r.TransactionRepository.Create(ctx, transaction)
+}
+
+func TestInsufficientBalanceNeedsTheFailedRecord(t *testing.T) {
+	store, ctx := newTestStore(t, 10, 0)
+	transfers := newStoreService(store, ConcurrencyLocking, false, BatchPolicy{})
+
+	_, err := transfers.ExecuteTransfer(ctx, transferRequest(1, 2, 100))
+	if !errors.Is(err, models.ErrInsufficientBalance) {
+		t.Fatalf("ExecuteTransfer() = %v, want ErrInsufficientBalance", err)
+	}
+
+	store.Transactions = failingAuditRepository{store.Transactions}
+	transfers = newStoreService(store, ConcurrencyLocking, false, BatchPolicy{})
+	_, err = transfers.ExecuteTransfer(ctx, transferRequest(1, 2, 100))
+	if !errors.Is(err, errAuditFailed) {
+		t.Fatalf("ExecuteTransfer() = %v, want the error of the failed record", err)
+	}
+}
+
+func TestReversalChecksCrossTenantPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		wantErr error
+	}{
+		{"only the original direction", []string{"a->b"}, models.ErrCrossTenantTransfer},
+		{"both directions", []string{"a->b", "b->a"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.NewStore()
+			ctxA := tenant.WithTenant(context.Background(), "a")
+			ctxB := tenant.WithTenant(context.Background(), "b")
+			for _, ctx := range []context.Context{ctxA, ctxB} {
+				err := store.Accounts.Create(ctx, &models.Account{TenantID: tenant.FromContext(ctx), AccountID: 1, Balance: decimal.NewFromInt(10)})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			policy, err := ParseCrossTenantPolicy(tt.pairs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transfers := NewTransferService(store.UnitOfWork, store.Accounts, store.Transactions,
+				NewAllowAllAuthorizer(), policy, ConcurrencyLocking, false,
+				RetryPolicy{Attempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+				BatchPolicy{}, AsyncPolicy{}, discardLogger)
+
+			req := transferRequest(1, 1, 4)
+			req.DestinationTenantID = "b"
+			original, err := transfers.ExecuteTransfer(ctxA, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = transfers.ReverseTransfer(ctxB, original.TransactionID)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("ReverseTransfer() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
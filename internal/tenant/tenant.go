@@ -0,0 +1,32 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// tenant of callers that are not assigned to one, and of data created
+// before multi-tenancy existed
+const Default = "default"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+type tenantKey struct{}
+
+// attach the tenant the request acts in to the context
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// get the tenant the request acts in, Default if none was set
+func FromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(tenantKey{}).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return Default
+}
+
+// check a tenant ID is well formed
+func ValidID(tenantID string) bool {
+	return validID.MatchString(tenantID)
+}
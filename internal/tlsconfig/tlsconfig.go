@@ -0,0 +1,209 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS server settings
+type Options struct {
+	CertFile       string
+	KeyFile        string
+	MinVersion     string // "1.2" or "1.3"
+	CipherPolicy   string // "default", "intermediate" or "modern"
+	ClientCAFile   string
+	ClientAuth     string // "none", "request", "verify_if_given" or "require"
+	ReloadInterval time.Duration
+}
+
+// AEAD cipher suites with forward secrecy, TLS 1.3 suites are not configurable
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// keeps the server certificate and client CA pool in sync with the files on
+// disk so rotated certificates are used without a restart
+type Reloader struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// load the certificate files
+func NewReloader(opts Options, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{
+		opts:   opts,
+		logger: logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// build the server TLS configuration
+func (r *Reloader) ServerConfig() (*tls.Config, error) {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// http.Server only adds these to its own copy of the config, not to
+		// the configs handed out per handshake below, which would lose HTTP/2
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	switch r.opts.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		base.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS min version %q", r.opts.MinVersion)
+	}
+
+	switch r.opts.CipherPolicy {
+	case "", "default":
+	case "intermediate":
+		base.CipherSuites = intermediateCipherSuites
+	case "modern":
+		base.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS cipher policy %q", r.opts.CipherPolicy)
+	}
+
+	switch r.opts.ClientAuth {
+	case "", "none":
+		base.ClientAuth = tls.NoClientCert
+	case "request":
+		base.ClientAuth = tls.RequestClientCert
+	case "verify_if_given":
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS client auth mode %q", r.opts.ClientAuth)
+	}
+
+	if base.ClientAuth >= tls.VerifyClientCertIfGiven && r.opts.ClientCAFile == "" {
+		return nil, errors.New("client certificate verification requires a client CA file")
+	}
+
+	// hand out a fresh config per handshake so reloaded files take effect
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*r.cert}
+		cfg.ClientCAs = r.clientCA
+		return cfg, nil
+	}
+
+	return base, nil
+}
+
+// poll the certificate files and reload them when they change
+func (r *Reloader) Watch(ctx context.Context) {
+	if r.opts.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err != nil {
+				r.logger.Error("failed to check TLS files", slog.String("error", err.Error()))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				// keep serving with the previous certificate
+				r.logger.Error("failed to reload TLS files", slog.String("error", err.Error()))
+				continue
+			}
+			r.logger.Info("TLS certificates reloaded")
+		}
+	}
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *Reloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	var clientCA *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return errors.New("client CA file contains no certificates")
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = clientCA
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return nil
+}
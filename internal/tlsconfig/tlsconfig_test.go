@@ -0,0 +1,263 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// write a self-signed certificate for localhost and its key, returning the
+// parsed certificate
+func writeCert(t *testing.T, certFile, keyFile, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// options for a fresh certificate, which also serves as client CA
+func testOptions(t *testing.T) (Options, *x509.Certificate) {
+	t.Helper()
+	dir := t.TempDir()
+	opts := Options{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	cert := writeCert(t, opts.CertFile, opts.KeyFile, "server")
+	return opts, cert
+}
+
+// the config the server would use for a handshake
+func handshakeConfig(t *testing.T, base *tls.Config) *tls.Config {
+	t.Helper()
+	cfg, err := base.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	opts, _ := testOptions(t)
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(o *Options)
+	}{
+		{"missing certificate", func(o *Options) { o.CertFile = filepath.Join(t.TempDir(), "missing.crt") }},
+		{"missing key", func(o *Options) { o.KeyFile = filepath.Join(t.TempDir(), "missing.key") }},
+		{"invalid certificate", func(o *Options) { o.CertFile = garbage }},
+		{"key of another certificate", func(o *Options) {
+			other, _ := testOptions(t)
+			o.KeyFile = other.KeyFile
+		}},
+		{"missing client CA", func(o *Options) { o.ClientCAFile = filepath.Join(t.TempDir(), "missing.pem") }},
+		{"client CA without certificates", func(o *Options) { o.ClientCAFile = garbage }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			tt.modify(&o)
+			if _, err := NewReloader(o, discardLogger); err == nil {
+				t.Error("NewReloader() = nil error, want an error")
+			}
+		})
+	}
+}
+
+func TestServerConfigClientAuth(t *testing.T) {
+	opts, cert := testOptions(t)
+
+	tests := []struct {
+		mode    string
+		withCA  bool
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{"", false, tls.NoClientCert, false},
+		{"none", false, tls.NoClientCert, false},
+		{"request", false, tls.RequestClientCert, false},
+		{"verify_if_given", true, tls.VerifyClientCertIfGiven, false},
+		{"require", true, tls.RequireAndVerifyClientCert, false},
+		{"verify_if_given", false, 0, true},
+		{"require", false, 0, true},
+		{"optional", true, 0, true},
+	}
+	for _, tt := range tests {
+		name := tt.mode
+		if tt.withCA {
+			name += " with CA"
+		}
+		t.Run(name, func(t *testing.T) {
+			o := opts
+			o.ClientAuth = tt.mode
+			if tt.withCA {
+				o.ClientCAFile = opts.CertFile
+			}
+			r, err := NewReloader(o, discardLogger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base, err := r.ServerConfig()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ServerConfig() = nil error, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := handshakeConfig(t, base)
+			if cfg.ClientAuth != tt.want {
+				t.Errorf("ClientAuth = %v, want %v", cfg.ClientAuth, tt.want)
+			}
+			if tt.withCA && !cfg.ClientCAs.Equal(poolOf(cert)) {
+				t.Error("ClientCAs do not hold the client CA")
+			}
+		})
+	}
+}
+
+func poolOf(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+func TestServerConfigOffersHTTP2(t *testing.T) {
+	opts, cert := testOptions(t)
+	r, err := NewReloader(opts, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := r.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: tlsConfig,
+	}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: poolOf(cert)},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("negotiated %s, want HTTP/2", resp.Proto)
+	}
+}
+
+func TestWatchReloadsRewrittenCertificate(t *testing.T) {
+	opts, first := testOptions(t)
+	opts.ReloadInterval = 10 * time.Millisecond
+	r, err := NewReloader(opts, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := r.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+
+	second := writeCert(t, opts.CertFile, opts.KeyFile, "rotated")
+	// file systems with coarse timestamps may keep the old modification time
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{opts.CertFile, opts.KeyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		served := handshakeConfig(t, base).Certificates[0].Leaf
+		if served.Equal(second) {
+			return
+		}
+		if !served.Equal(first) {
+			t.Fatalf("serving %s, want the first or the rotated certificate", served.Subject)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rewritten certificate was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
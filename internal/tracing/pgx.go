@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const dbTracerName = "internal-transfers/internal/tracing/pgx"
+
+// pgx tracer creating a span per SQL statement, batch and pool acquire, so
+// lock waits, queries and commits show up separately in a transfer trace
+type DBTracer struct{}
+
+var (
+	_ pgx.QueryTracer       = DBTracer{}
+	_ pgx.BatchTracer       = DBTracer{}
+	_ pgxpool.AcquireTracer = DBTracer{}
+)
+
+func (DBTracer) tracer() trace.Tracer {
+	return otel.Tracer(dbTracerName)
+}
+
+func (t DBTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer().Start(ctx, "db "+operation(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.query.text", data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (DBTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
+
+func (t DBTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = t.tracer().Start(ctx, "db batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.Int("db.batch.size", data.Batch.Len()),
+		),
+	)
+	return ctx
+}
+
+func (DBTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("query", trace.WithAttributes(attribute.String("db.query.text", data.SQL)))
+	if data.Err != nil {
+		span.RecordError(data.Err)
+	}
+}
+
+func (DBTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+func (t DBTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	ctx, _ = t.tracer().Start(ctx, "db pool acquire")
+	return ctx
+}
+
+func (DBTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+// first keyword of the statement, e.g. SELECT or COMMIT, marking row locks
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	op := strings.ToUpper(fields[0])
+	if strings.Contains(strings.ToUpper(sql), "FOR UPDATE") {
+		op += " FOR UPDATE"
+	}
+	return op
+}
@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS initiated_by VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_initiated_by ON transactions(initiated_by);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS account_delegates;
+
+DROP INDEX IF EXISTS idx_accounts_owner_id;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS owner_id;
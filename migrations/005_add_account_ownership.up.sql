@@ -0,0 +1,15 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_accounts_owner_id ON accounts(owner_id);
+
+CREATE TABLE IF NOT EXISTS account_delegates (
+    account_id BIGINT NOT NULL,
+    principal_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (account_id, principal_id),
+    CONSTRAINT fk_delegate_account
+        FOREIGN KEY (account_id)
+        REFERENCES accounts(account_id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,36 @@
+-- Only succeeds while account IDs are still globally unique.
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE account_delegates DROP CONSTRAINT IF EXISTS fk_delegate_account;
+ALTER TABLE account_delegates DROP CONSTRAINT IF EXISTS account_delegates_pkey;
+ALTER TABLE account_delegates ADD CONSTRAINT account_delegates_pkey PRIMARY KEY (account_id, principal_id);
+ALTER TABLE account_delegates DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_source_account;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_destination_account;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS check_different_accounts;
+DROP INDEX IF EXISTS idx_transactions_source;
+DROP INDEX IF EXISTS idx_transactions_destination;
+ALTER TABLE transactions DROP COLUMN IF EXISTS destination_tenant_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_pkey;
+ALTER TABLE accounts ADD CONSTRAINT accounts_pkey PRIMARY KEY (account_id);
+ALTER TABLE accounts DROP COLUMN IF EXISTS tenant_id;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_source ON transactions(source_account_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_destination ON transactions(destination_account_id);
+ALTER TABLE transactions
+    ADD CONSTRAINT fk_source_account
+        FOREIGN KEY (source_account_id)
+        REFERENCES accounts(account_id),
+    ADD CONSTRAINT fk_destination_account
+        FOREIGN KEY (destination_account_id)
+        REFERENCES accounts(account_id),
+    ADD CONSTRAINT check_different_accounts
+        CHECK (source_account_id != destination_account_id);
+ALTER TABLE account_delegates
+    ADD CONSTRAINT fk_delegate_account
+        FOREIGN KEY (account_id)
+        REFERENCES accounts(account_id)
+        ON DELETE CASCADE;
@@ -0,0 +1,38 @@
+-- Account IDs become unique per tenant, so every reference to an account
+-- has to carry the tenant as well.
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_source_account;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_destination_account;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS check_different_accounts;
+ALTER TABLE account_delegates DROP CONSTRAINT IF EXISTS fk_delegate_account;
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_pkey;
+ALTER TABLE accounts ADD CONSTRAINT accounts_pkey PRIMARY KEY (tenant_id, account_id);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS destination_tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE transactions
+    ADD CONSTRAINT fk_source_account
+        FOREIGN KEY (tenant_id, source_account_id)
+        REFERENCES accounts(tenant_id, account_id),
+    ADD CONSTRAINT fk_destination_account
+        FOREIGN KEY (destination_tenant_id, destination_account_id)
+        REFERENCES accounts(tenant_id, account_id),
+    ADD CONSTRAINT check_different_accounts
+        CHECK ((tenant_id, source_account_id) <> (destination_tenant_id, destination_account_id));
+
+DROP INDEX IF EXISTS idx_transactions_source;
+DROP INDEX IF EXISTS idx_transactions_destination;
+CREATE INDEX IF NOT EXISTS idx_transactions_source ON transactions(tenant_id, source_account_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_destination ON transactions(destination_tenant_id, destination_account_id);
+
+ALTER TABLE account_delegates ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE account_delegates DROP CONSTRAINT IF EXISTS account_delegates_pkey;
+ALTER TABLE account_delegates ADD CONSTRAINT account_delegates_pkey PRIMARY KEY (tenant_id, account_id, principal_id);
+ALTER TABLE account_delegates
+    ADD CONSTRAINT fk_delegate_account
+        FOREIGN KEY (tenant_id, account_id)
+        REFERENCES accounts(tenant_id, account_id)
+        ON DELETE CASCADE;
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
@@ -0,0 +1,13 @@
+DROP POLICY IF EXISTS tenant_isolation ON account_delegates;
+ALTER TABLE account_delegates NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE account_delegates DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON transactions;
+ALTER TABLE transactions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE transactions DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON accounts;
+ALTER TABLE accounts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE accounts DISABLE ROW LEVEL SECURITY;
+
+DROP FUNCTION IF EXISTS app_tenant_ids();
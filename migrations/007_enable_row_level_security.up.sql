@@ -0,0 +1,26 @@
+-- Rows are only visible to sessions whose app.tenant_ids setting (a comma
+-- separated list set by the repository layer per transaction) contains their
+-- tenant. An unset variable matches nothing, so unscoped queries fail closed.
+-- FORCE applies the policies to the table owner as well; superusers and roles
+-- with BYPASSRLS are still exempt, so the service must not connect as one.
+CREATE OR REPLACE FUNCTION app_tenant_ids() RETURNS TEXT[]
+    LANGUAGE sql STABLE
+    AS $$ SELECT string_to_array(NULLIF(current_setting('app.tenant_ids', true), ''), ',') $$;
+
+ALTER TABLE accounts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE accounts FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON accounts
+    USING (tenant_id = ANY (app_tenant_ids()))
+    WITH CHECK (tenant_id = ANY (app_tenant_ids()));
+
+ALTER TABLE transactions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE transactions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON transactions
+    USING (tenant_id = ANY (app_tenant_ids()) OR destination_tenant_id = ANY (app_tenant_ids()))
+    WITH CHECK (tenant_id = ANY (app_tenant_ids()) AND destination_tenant_id = ANY (app_tenant_ids()));
+
+ALTER TABLE account_delegates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE account_delegates FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON account_delegates
+    USING (tenant_id = ANY (app_tenant_ids()))
+    WITH CHECK (tenant_id = ANY (app_tenant_ids()));
@@ -0,0 +1,46 @@
+-- Token buckets shared by all instances. Buckets are keyed by the limiter
+-- (e.g. "client:<principal>") and hold no tenant data, so there is no RLS.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    full_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_rate_limit_buckets_full_at ON rate_limit_buckets(full_at);
+
+-- Refill the bucket for p_key and take one token if available. The row lock
+-- serializes concurrent callers so a token is never handed out twice.
+CREATE OR REPLACE FUNCTION rate_limit_take(
+    p_key TEXT,
+    p_burst DOUBLE PRECISION,
+    p_rate DOUBLE PRECISION,
+    OUT available DOUBLE PRECISION,
+    OUT granted BOOLEAN
+)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    bucket rate_limit_buckets%ROWTYPE;
+    ts TIMESTAMPTZ;
+BEGIN
+    INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at)
+    VALUES (p_key, p_burst, clock_timestamp(), clock_timestamp())
+    ON CONFLICT (key) DO NOTHING;
+
+    SELECT * INTO bucket FROM rate_limit_buckets WHERE key = p_key FOR UPDATE;
+    ts := clock_timestamp();
+
+    available := LEAST(p_burst, bucket.tokens + GREATEST(0, EXTRACT(EPOCH FROM ts - bucket.updated_at)) * p_rate);
+    granted := available >= 1;
+    IF granted THEN
+        available := available - 1;
+    END IF;
+
+    UPDATE rate_limit_buckets
+    SET tokens = available,
+        updated_at = ts,
+        full_at = ts + make_interval(secs => (p_burst - available) / p_rate)
+    WHERE key = p_key;
+END
+$$;
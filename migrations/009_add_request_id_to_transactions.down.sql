@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_transactions_request_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS request_id VARCHAR(128);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_request_id ON transactions(request_id);
@@ -0,0 +1,37 @@
+-- Reconciliation replays the ledger from the balance an account was opened
+-- with, so that balance has to be kept. Existing accounts get it back from
+-- their completed transfers. FORCE is lifted while backfilling so the owner
+-- running the migration sees every tenant's rows.
+ALTER TABLE accounts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE transactions NO FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS initial_balance DECIMAL(36, 18);
+
+UPDATE accounts a
+SET initial_balance = a.balance
+    - COALESCE((
+        SELECT SUM(t.amount) FROM transactions t
+        WHERE t.status = 'completed'
+          AND t.destination_tenant_id = a.tenant_id
+          AND t.destination_account_id = a.account_id
+    ), 0)
+    + COALESCE((
+        SELECT SUM(t.amount) FROM transactions t
+        WHERE t.status = 'completed'
+          AND t.tenant_id = a.tenant_id
+          AND t.source_account_id = a.account_id
+    ), 0)
+WHERE a.initial_balance IS NULL;
+
+ALTER TABLE accounts ALTER COLUMN initial_balance SET NOT NULL;
+
+ALTER TABLE accounts FORCE ROW LEVEL SECURITY;
+ALTER TABLE transactions FORCE ROW LEVEL SECURITY;
+
+-- A reversal is a transfer in the opposite direction pointing at the
+-- transfer it undoes. Each transfer can be reversed at most once.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reversal_of BIGINT REFERENCES transactions(transaction_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_reversal_of
+    ON transactions(reversal_of)
+    WHERE status = 'completed';
@@ -0,0 +1,3 @@
+-- Incremented by every balance update, so a transfer can read an account
+-- without locking it and only write it back if nobody changed it since.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- Fold the shards back into their accounts before dropping them. FORCE is
+-- lifted so the owner running the migration sees every tenant's rows.
+ALTER TABLE accounts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE account_shards NO FORCE ROW LEVEL SECURITY;
+
+UPDATE accounts a
+SET balance = a.balance + s.total, shards = 0
+FROM (
+    SELECT tenant_id, account_id, SUM(balance) AS total
+    FROM account_shards
+    GROUP BY tenant_id, account_id
+) s
+WHERE s.tenant_id = a.tenant_id AND s.account_id = a.account_id;
+
+ALTER TABLE accounts FORCE ROW LEVEL SECURITY;
+
+DROP TABLE IF EXISTS account_shards;
+ALTER TABLE accounts DROP COLUMN IF EXISTS shards;
@@ -0,0 +1,24 @@
+-- Hot accounts keep their balance in several shard rows, so concurrent
+-- transfers lock different rows instead of queueing on the account row. The
+-- balance of an account is its own balance plus that of its shards, and a
+-- sharded account keeps zero in its own.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS shards INT NOT NULL DEFAULT 0 CHECK (shards >= 0);
+
+CREATE TABLE IF NOT EXISTS account_shards (
+    tenant_id VARCHAR(64) NOT NULL,
+    account_id BIGINT NOT NULL,
+    shard INT NOT NULL CHECK (shard >= 0),
+    balance DECIMAL(36, 18) NOT NULL CHECK (balance >= 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, account_id, shard),
+    CONSTRAINT fk_shard_account
+        FOREIGN KEY (tenant_id, account_id)
+        REFERENCES accounts(tenant_id, account_id)
+        ON DELETE CASCADE
+);
+
+ALTER TABLE account_shards ENABLE ROW LEVEL SECURITY;
+ALTER TABLE account_shards FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON account_shards
+    USING (tenant_id = ANY (app_tenant_ids()))
+    WITH CHECK (tenant_id = ANY (app_tenant_ids()));
@@ -0,0 +1,11 @@
+-- Queued transfers can no longer run, so they fail. FORCE is lifted so the
+-- owner running the migration sees every tenant's rows.
+ALTER TABLE transactions NO FORCE ROW LEVEL SECURITY;
+
+UPDATE transactions
+SET status = 'failed', error_message = 'transfer queue removed before the transfer ran'
+WHERE status = 'pending';
+
+ALTER TABLE transactions FORCE ROW LEVEL SECURITY;
+
+DROP TABLE IF EXISTS transfer_queue;
@@ -0,0 +1,15 @@
+-- Transfers accepted with POST /transactions?async=true are recorded as
+-- pending and wait here until a worker executes them. Workers claim jobs of
+-- every tenant, so the queue has no RLS: it only points at the pending
+-- transaction, which stays protected, and keeps the roles of the caller.
+CREATE TABLE IF NOT EXISTS transfer_queue (
+    transaction_id BIGINT PRIMARY KEY REFERENCES transactions(transaction_id) ON DELETE CASCADE,
+    tenant_id VARCHAR(64) NOT NULL,
+    principal_roles TEXT[] NOT NULL DEFAULT '{}',
+    attempts INT NOT NULL DEFAULT 0,
+    enqueued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- when the job can be claimed, pushed back by the lease of each claim
+    available_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfer_queue_available_at ON transfer_queue(available_at);
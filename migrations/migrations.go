@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL schema migrations into the server binary.
+package migrations
+
+import "embed"
+
+// NNN_name.up.sql and NNN_name.down.sql files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"internal-transfers/internal/config"
+	"log/slog"
+	"os"
+)
+
+// handle `server config print`, showing the effective configuration
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: server config print [-redacted] [-format yaml|json] [-config file] [setting flags]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	redact := flags.Bool("redacted", false, "replace secrets with "+`"REDACTED"`)
+	format := flags.String("format", "yaml", "output format, yaml or json")
+	loader := config.NewLoader(flags)
+	flags.Parse(args[1:])
+
+	cfg, err := loader.Load()
+	if err != nil {
+		var cfgErr *config.Errors
+		if !errors.As(err, &cfgErr) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		for _, e := range cfgErr.Errs {
+			fmt.Fprintln(os.Stderr, "  "+e.Error())
+		}
+		return 1
+	}
+
+	out, err := cfg.Marshal(*format, *redact)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// log configuration problems, listing every invalid setting
+func logConfigError(logger *slog.Logger, err error) {
+	var cfgErr *config.Errors
+	if !errors.As(err, &cfgErr) {
+		logger.Error("failed to load configuration", slog.String("error", err.Error()))
+		return
+	}
+
+	problems := make([]string, len(cfgErr.Errs))
+	for i, e := range cfgErr.Errs {
+		problems[i] = e.Error()
+	}
+	logger.Error("invalid configuration", slog.Any("errors", problems))
+}
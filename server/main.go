@@ -2,11 +2,22 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"internal-transfers/internal/api"
+	"internal-transfers/internal/auth"
 	"internal-transfers/internal/config"
+	"internal-transfers/internal/health"
+	"internal-transfers/internal/metrics"
+	"internal-transfers/internal/migrate"
+	"internal-transfers/internal/ratelimit"
 	"internal-transfers/internal/repository"
+	"internal-transfers/internal/repository/memory"
+	"internal-transfers/internal/requestid"
 	"internal-transfers/internal/service"
+	"internal-transfers/internal/tlsconfig"
+	"internal-transfers/internal/tracing"
+	"internal-transfers/migrations"
 	"log/slog"
 	"net/http"
 	"os"
@@ -19,44 +30,150 @@ import (
 )
 
 func main() {
-	// Setup logging
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			os.Exit(runConfigCommand(args[1:]))
+		case "migrate":
+			os.Exit(runMigrateCommand(args[1:]))
+		}
+	}
 
-	logger.Info("starting internal transfers service")
+	// Load configuration from defaults, config file, env and flags
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	loader := config.NewLoader(flags)
+	flags.Parse(args)
 
-	// Load env configuration
-	cfg, err := config.Load()
+	cfg, err := loader.Load()
 	if err != nil {
-		logger.Error("failed to load configuration", slog.String("error", err.Error()))
+		logConfigError(slog.New(slog.NewJSONHandler(os.Stderr, nil)), err)
 		os.Exit(1)
 	}
 
-	dbPool, err := connectDB(cfg.Database, logger)
+	// Setup logging
+	logger := newLogger(cfg.Log)
+	slog.SetDefault(logger)
+
+	logger.Info("starting internal transfers service", slog.String("log_level", cfg.Log.Level))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.Tracing.Exporter,
+		File:         cfg.Tracing.File,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		ServiceName:  cfg.Tracing.ServiceName,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		logger.Error("failed to connect to database", slog.String("error", err.Error()))
+		logger.Error("failed to set up tracing", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
-	defer dbPool.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", slog.String("error", err.Error()))
+		}
+	}()
 
-	logger.Info("connected to database successfully")
+	// Initialize readiness checks
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
 
-	// Initialize repos
-	accountRepo := repository.NewAccountRepository(dbPool)
-	transactionRepo := repository.NewTransactionRepository(dbPool)
+	// Initialize storage
+	var store *repository.Store
+	var dbPool *pgxpool.Pool
+	switch cfg.Storage.Backend {
+	case "memory":
+		logger.Warn("using in-memory storage, all data is lost when the server stops")
+		store = memory.NewStore()
+	default:
+		var migrator *migrate.Migrator
+		dbPool, migrator, err = openDatabase(context.Background(), cfg, logger)
+		if err != nil {
+			logger.Error("failed to open database", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer dbPool.Close()
+
+		metrics.MustRegister(metrics.NewPoolCollector(dbPool))
+
+		checker.Add("database", health.DatabaseCheck(dbPool))
+		checker.Add("pool", health.PoolSaturationCheck(dbPool, cfg.Health.PoolSaturation))
+		checker.Add("migrations", health.MigrationCheck(dbPool, migrator.Latest()))
+
+		store = repository.NewPostgresStore(dbPool, repository.IsolationLevel(cfg.Database.Isolation))
+	}
 
 	// Initialize services
-	accountService := service.NewAccountService(accountRepo, logger)
-	transferService := service.NewTransferService(dbPool, accountRepo, transactionRepo, logger)
+	authorizer := service.NewAllowAllAuthorizer()
+	if cfg.Auth.Enabled {
+		authorizer = service.NewOwnershipAuthorizer(store.Accounts)
+	}
+	crossTenant, err := service.ParseCrossTenantPolicy(cfg.Tenancy.CrossTenantTransfers)
+	if err != nil {
+		logger.Error("invalid tenancy configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	accountService := service.NewAccountService(store.UnitOfWork, store.Accounts, authorizer, logger)
+	retry := service.RetryPolicy{
+		Attempts:  cfg.Transfers.RetryAttempts,
+		BaseDelay: cfg.Transfers.RetryBaseDelay,
+		MaxDelay:  cfg.Transfers.RetryMaxDelay,
+	}
+	batch := service.BatchPolicy{
+		Window:  cfg.Transfers.BatchWindow,
+		MaxSize: cfg.Transfers.BatchMaxSize,
+		Timeout: cfg.Transfers.BatchTimeout,
+	}
+	async := service.AsyncPolicy{
+		Workers:      cfg.Transfers.AsyncWorkers,
+		PollInterval: cfg.Transfers.AsyncPollInterval,
+		Lease:        cfg.Transfers.AsyncLease,
+		MaxAttempts:  cfg.Transfers.AsyncMaxAttempts,
+	}
+	transferService := service.NewTransferService(store.UnitOfWork, store.Accounts, store.Transactions, authorizer, crossTenant, service.Concurrency(cfg.Transfers.Concurrency), cfg.Transfers.SingleStatement, retry, batch, async, logger)
+	apiKeyService := service.NewAPIKeyService(store.APIKeys, cfg.Auth.AdminKey, logger)
+
+	// Initialize authenticators
+	authenticators := []auth.Authenticator{
+		api.NewAPIKeyAuthenticator(apiKeyService),
+	}
+	if cfg.Auth.JWT.Enabled() {
+		jwtAuthenticator, err := newJWTAuthenticator(cfg.Auth.JWT, logger)
+		if err != nil {
+			logger.Error("failed to set up JWT authentication", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		authenticators = append(authenticators, auth.NewClientCertAuthenticator(cfg.TLS.ClientCertTenant, cfg.TLS.ClientCertScopes))
+	}
+
+	// Initialize rate limiters
+	clientLimiter, accountLimiter, err := newRateLimiters(cfg.RateLimit, dbPool, logger)
+	if err != nil {
+		logger.Error("invalid rate limit configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
 	// Initialize API service
-	accountHandler := api.NewAccountHandler(accountService, logger)
-	transactionHandler := api.NewTransactionHandler(transferService, logger)
+	deps := routerDeps{
+		healthHandler:      api.NewHealthHandler(checker, logger),
+		accountHandler:     api.NewAccountHandler(accountService, logger),
+		transactionHandler: api.NewTransactionHandler(transferService, accountLimiter, logger),
+		apiKeyHandler:      api.NewAPIKeyHandler(apiKeyService, logger),
+		authenticators:     authenticators,
+		clientLimiter:      clientLimiter,
+	}
 
 	// Setup router
-	router := setupRouter(accountHandler, transactionHandler, logger)
+	router := setupRouter(cfg, deps, logger)
+	if err := api.CheckSpecCoverage(router, unmountedRoutes(cfg)...); err != nil {
+		logger.Error("routes do not match the OpenAPI spec", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
 	// Setup HTTP server
 	serverAddr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
@@ -68,10 +185,57 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Setup TLS
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
+	if cfg.TLS.Enabled() {
+		reloader, err := tlsconfig.NewReloader(tlsconfig.Options{
+			CertFile:       cfg.TLS.CertFile,
+			KeyFile:        cfg.TLS.KeyFile,
+			MinVersion:     cfg.TLS.MinVersion,
+			CipherPolicy:   cfg.TLS.CipherPolicy,
+			ClientCAFile:   cfg.TLS.ClientCAFile,
+			ClientAuth:     cfg.TLS.ClientAuth,
+			ReloadInterval: cfg.TLS.ReloadInterval,
+		}, logger)
+		if err != nil {
+			logger.Error("failed to load TLS certificates", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		server.TLSConfig, err = reloader.ServerConfig()
+		if err != nil {
+			logger.Error("invalid TLS configuration", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		go reloader.Watch(watchCtx)
+	}
+
+	// Start the workers executing queued transfers
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := make(chan struct{})
+	go func() {
+		service.RunTransferWorkers(workersCtx, transferService, async, logger)
+		close(workersDone)
+	}()
+
 	// Start server
 	go func() {
-		logger.Info("server starting", slog.String("address", serverAddr))
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("server starting",
+			slog.String("address", serverAddr),
+			slog.Bool("tls", cfg.TLS.Enabled()),
+		)
+
+		var err error
+		if cfg.TLS.Enabled() {
+			// certificates are provided by server.TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("server error", slog.String("error", err.Error()))
 			os.Exit(1)
 		}
@@ -79,7 +243,15 @@ func main() {
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	sig := <-quit
+
+	// Fail readiness first so load balancers stop sending traffic before the
+	// listener closes, only needed when an orchestrator sends SIGTERM
+	checker.SetDraining()
+	if sig == syscall.SIGTERM && cfg.Server.DrainDelay > 0 {
+		logger.Info("draining before shutdown", slog.Duration("delay", cfg.Server.DrainDelay))
+		time.Sleep(cfg.Server.DrainDelay)
+	}
 
 	logger.Info("shutting down server...")
 
@@ -90,9 +262,30 @@ func main() {
 		logger.Error("server forced to shutdown", slog.String("error", err.Error()))
 	}
 
+	// running transfers finish, the rest stay queued for the next start
+	stopWorkers()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		logger.Error("queued transfer workers did not stop in time")
+	}
+	if err := transferService.Close(ctx); err != nil {
+		logger.Error("batched transfers did not finish in time", slog.String("error", err.Error()))
+	}
+
 	logger.Info("server exited")
 }
 
+func newLogger(cfg config.LogConfig) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	return slog.New(requestid.NewLogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: level,
+	})))
+}
+
 func connectDB(cfg config.DatabaseConfig, logger *slog.Logger) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -104,6 +297,7 @@ func connectDB(cfg config.DatabaseConfig, logger *slog.Logger) (*pgxpool.Pool, e
 
 	poolConfig.MaxConns = cfg.MaxConns
 	poolConfig.MinConns = cfg.MinConns
+	poolConfig.ConnConfig.Tracer = tracing.DBTracer{}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
@@ -117,30 +311,173 @@ func connectDB(cfg config.DatabaseConfig, logger *slog.Logger) (*pgxpool.Pool, e
 	return pool, nil
 }
 
-func setupRouter(
-	accountHandler *api.AccountHandler,
-	transactionHandler *api.TransactionHandler,
-	logger *slog.Logger,
-) *chi.Mux {
+// connect to postgres and check the schema is ready for this version
+func openDatabase(ctx context.Context, cfg *config.Config, logger *slog.Logger) (*pgxpool.Pool, *migrate.Migrator, error) {
+	dbPool, err := connectDB(cfg.Database, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("connected to database successfully")
+
+	// Refuse to serve on a schema older than the code expects
+	migrator, err := migrate.New(dbPool, migrations.FS, logger)
+	if err != nil {
+		dbPool.Close()
+		return nil, nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if err := checkSchema(ctx, migrator, logger); err != nil {
+		dbPool.Close()
+		return nil, nil, fmt.Errorf("database schema is not ready, run `server migrate up`: %w", err)
+	}
+
+	if bypass, err := repository.BypassesRowLevelSecurity(ctx, dbPool); err != nil {
+		logger.Warn("failed to check row level security", slog.String("error", err.Error()))
+	} else if bypass {
+		logger.Warn("database role bypasses row level security, tenant isolation relies on application filtering only",
+			slog.String("user", cfg.Database.User),
+		)
+	}
+
+	return dbPool, migrator, nil
+}
+
+func newJWTAuthenticator(cfg config.JWTConfig, logger *slog.Logger) (auth.Authenticator, error) {
+	var keys *auth.KeySet
+	var err error
+	if cfg.JWKSFile != "" {
+		keys, err = auth.NewFileKeySet(cfg.JWKSFile, logger)
+	} else {
+		keys, err = auth.NewRemoteKeySet(context.Background(), cfg.JWKSURL, cfg.JWKSRefresh, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewJWTAuthenticator(keys, auth.JWTOptions{
+		Issuer:      cfg.Issuer,
+		Audience:    cfg.Audience,
+		ClockSkew:   cfg.ClockSkew,
+		TenantClaim: cfg.TenantClaim,
+	}), nil
+}
+
+// client and source account limiters for POST /transactions, nil when disabled
+func newRateLimiters(cfg config.RateLimitConfig, db *pgxpool.Pool, logger *slog.Logger) (client, account *ratelimit.Limiter, err error) {
+	if cfg.ClientRate <= 0 && cfg.AccountRate <= 0 {
+		return nil, nil, nil
+	}
+
+	var store ratelimit.Store
+	switch cfg.Backend {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(db, logger)
+	default:
+		return nil, nil, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+
+	if cfg.ClientRate > 0 {
+		client = ratelimit.New(store, ratelimit.Limit{Rate: cfg.ClientRate, Burst: cfg.ClientBurst})
+	}
+	if cfg.AccountRate > 0 {
+		account = ratelimit.New(store, ratelimit.Limit{Rate: cfg.AccountRate, Burst: cfg.AccountBurst})
+	}
+
+	return client, account, nil
+}
+
+// handlers, authenticators and limiters wired into the router
+type routerDeps struct {
+	healthHandler      *api.HealthHandler
+	accountHandler     *api.AccountHandler
+	transactionHandler *api.TransactionHandler
+	apiKeyHandler      *api.APIKeyHandler
+	authenticators     []auth.Authenticator
+	clientLimiter      *ratelimit.Limiter // nil when client rate limiting is disabled
+}
+
+// documented routes setupRouter leaves out with this configuration
+func unmountedRoutes(cfg *config.Config) []string {
+	if cfg.Auth.Enabled {
+		return nil
+	}
+	return []string{"POST /admin/api-keys", "DELETE /admin/api-keys/{key_id}"}
+}
+
+func setupRouter(cfg *config.Config, deps routerDeps, logger *slog.Logger) *chi.Mux {
 	router := chi.NewRouter()
 
 	// Global middleware
+	router.Use(api.RequestIDMiddleware)
+	router.Use(api.TracingMiddleware)
+	router.Use(api.MetricsMiddleware)
 	router.Use(api.RecoveryMiddleware(logger))
 	router.Use(api.LoggingMiddleware(logger))
+	router.Use(api.ClientCertMiddleware)
+	router.Use(api.BodyLimitMiddleware(cfg.Server.MaxBodyBytes))
 
-	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"healthy"}`))
-	})
+	router.Get("/livez", deps.healthHandler.Live)
+	router.Get("/readyz", deps.healthHandler.Ready)
+	router.Get("/health", deps.healthHandler.Health) // kept for existing probes, same as /livez
+
+	router.Method(http.MethodGet, "/metrics", metrics.Handler())
+	router.Get("/openapi.json", api.OpenAPIHandler)
+	router.Get("/docs", api.SwaggerUIHandler)
+	router.Get("/docs/{file}", api.SwaggerUIAssetHandler)
+
+	// scopes are only enforced when authentication is enabled
+	requireScope := func(scope string) func(http.Handler) http.Handler {
+		if !cfg.Auth.Enabled {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return api.RequireScope(scope)
+	}
 
 	// API routes
-	router.Route("/accounts", func(r chi.Router) {
-		r.Post("/", accountHandler.CreateAccount)
-		r.Get("/{account_id}", accountHandler.GetAccount)
-	})
+	router.Group(func(r chi.Router) {
+		if cfg.Auth.Enabled {
+			r.Use(api.AuthMiddleware(logger, deps.authenticators...))
+		}
+		r.Use(api.TenantMiddleware)
+
+		r.Route("/accounts", func(r chi.Router) {
+			r.With(requireScope(auth.ScopeAccountsWrite)).Post("/", deps.accountHandler.CreateAccount)
+			r.With(requireScope(auth.ScopeAccountsRead)).Get("/{account_id}", deps.accountHandler.GetAccount)
+			r.With(requireScope(auth.ScopeAccountsRead)).Get("/{account_id}/transactions", deps.transactionHandler.ListAccountTransactions)
+			r.With(requireScope(auth.ScopeAccountsWrite)).Post("/{account_id}/delegates", deps.accountHandler.AddDelegate)
+			r.With(requireScope(auth.ScopeAccountsWrite)).Delete("/{account_id}/delegates/{principal_id}", deps.accountHandler.RemoveDelegate)
+		})
+
+		r.Route("/transactions", func(r chi.Router) {
+			// polling is not rate limited, it would eat into the transfers
+			r.With(requireScope(auth.ScopeAccountsRead)).Get("/{transaction_id}", deps.transactionHandler.GetTransaction)
 
-	router.Post("/transactions", transactionHandler.CreateTransaction)
+			r.Group(func(r chi.Router) {
+				r.Use(requireScope(auth.ScopeTransfersWrite))
+				if deps.clientLimiter != nil {
+					r.Use(api.RateLimitMiddleware(deps.clientLimiter, logger))
+				}
+				r.Post("/", deps.transactionHandler.CreateTransaction)
+				r.Post("/{transaction_id}/reversal", deps.transactionHandler.ReverseTransaction)
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireScope(auth.ScopeAdmin))
+			r.Get("/reconciliation", deps.accountHandler.Reconcile)
+			r.Put("/accounts/{account_id}/shards", deps.accountHandler.SetShards)
+
+			// api keys can only be managed when authentication is enabled
+			if cfg.Auth.Enabled {
+				r.Route("/api-keys", func(r chi.Router) {
+					r.Post("/", deps.apiKeyHandler.CreateAPIKey)
+					r.Delete("/{key_id}", deps.apiKeyHandler.RevokeAPIKey)
+				})
+			}
+		})
+	})
 
 	return router
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"internal-transfers/internal/config"
+	"internal-transfers/internal/migrate"
+	"internal-transfers/migrations"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+const migrateUsage = "usage: server migrate [-config file] [setting flags] up | down [N] | status | goto VERSION | force VERSION"
+
+// handle `server migrate`, applying the embedded migrations to the
+// configured database
+func runMigrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), migrateUsage)
+		flags.PrintDefaults()
+	}
+	loader := config.NewLoader(flags)
+	flags.Parse(args)
+
+	action := flags.Args()
+	if len(action) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		logConfigError(slog.New(slog.NewJSONHandler(os.Stderr, nil)), err)
+		return 1
+	}
+	logger := newLogger(cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbPool, err := connectDB(cfg.Database, logger)
+	if err != nil {
+		logger.Error("failed to connect to database", slog.String("error", err.Error()))
+		return 1
+	}
+	defer dbPool.Close()
+
+	migrator, err := migrate.New(dbPool, migrations.FS, logger)
+	if err != nil {
+		logger.Error("failed to load migrations", slog.String("error", err.Error()))
+		return 1
+	}
+
+	switch {
+	case action[0] == "up" && len(action) == 1:
+		err = migrator.Up(ctx)
+	case action[0] == "down" && len(action) <= 2:
+		steps := 1
+		if len(action) == 2 {
+			if steps, err = strconv.Atoi(action[1]); err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps %q\n", action[1])
+				return 2
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case action[0] == "status" && len(action) == 1:
+		err = printStatus(ctx, migrator)
+	case (action[0] == "goto" || action[0] == "force") && len(action) == 2:
+		version, parseErr := strconv.ParseUint(action[1], 10, 32)
+		if parseErr != nil {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n", action[1])
+			return 2
+		}
+		if action[0] == "goto" {
+			err = migrator.Goto(ctx, uint(version))
+		} else {
+			err = migrator.Force(ctx, uint(version))
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		logger.Error("migration failed", slog.String("action", action[0]), slog.String("error", err.Error()))
+		return 1
+	}
+	return 0
+}
+
+func printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	dirty := ""
+	if status.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Printf("version: %d%s\nlatest:  %d\n", status.Version, dirty, status.Latest)
+	if len(status.Pending) == 0 {
+		fmt.Println("pending: none")
+		return nil
+	}
+	fmt.Println("pending:")
+	for _, mig := range status.Pending {
+		fmt.Printf("  %03d_%s\n", mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// fail unless the schema is clean and at least at the latest embedded version
+func checkSchema(ctx context.Context, migrator *migrate.Migrator, logger *slog.Logger) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("%w at version %d", migrate.ErrDirty, status.Version)
+	case status.Version < status.Latest:
+		return fmt.Errorf("schema at version %d, need %d (%d pending)", status.Version, status.Latest, len(status.Pending))
+	case status.Version > status.Latest:
+		// expected while a newer release rolls out
+		logger.Warn("database schema is newer than this binary",
+			slog.Uint64("version", uint64(status.Version)),
+			slog.Uint64("latest", uint64(status.Latest)),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"internal-transfers/internal/api"
+	"internal-transfers/internal/config"
+	"internal-transfers/internal/health"
+	"internal-transfers/internal/ratelimit"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// the router with the given authentication setting and handlers without
+// services, enough to walk its routes
+func testRouter(t *testing.T, authEnabled bool) (*config.Config, *chi.Mux) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limit{Rate: 1, Burst: 1})
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{MaxBodyBytes: 1 << 20},
+		Auth:   config.AuthConfig{Enabled: authEnabled},
+	}
+	deps := routerDeps{
+		healthHandler:      api.NewHealthHandler(health.NewChecker(time.Second), logger),
+		accountHandler:     api.NewAccountHandler(nil, logger),
+		transactionHandler: api.NewTransactionHandler(nil, limiter, logger),
+		apiKeyHandler:      api.NewAPIKeyHandler(nil, logger),
+		clientLimiter:      limiter,
+	}
+	return cfg, setupRouter(cfg, deps, logger)
+}
+
+func TestRoutesMatchSpec(t *testing.T) {
+	for _, authEnabled := range []bool{false, true} {
+		name := "auth disabled"
+		if authEnabled {
+			name = "auth enabled"
+		}
+		t.Run(name, func(t *testing.T) {
+			cfg, router := testRouter(t, authEnabled)
+			if err := api.CheckSpecCoverage(router, unmountedRoutes(cfg)...); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSpecCoverageDetectsDrift(t *testing.T) {
+	t.Run("route missing from spec", func(t *testing.T) {
+		cfg, router := testRouter(t, true)
+		router.Get("/undocumented", func(http.ResponseWriter, *http.Request) {})
+
+		err := api.CheckSpecCoverage(router, unmountedRoutes(cfg)...)
+		if err == nil || !strings.Contains(err.Error(), "missing from spec: GET /undocumented") {
+			t.Fatalf("CheckSpecCoverage() = %v, want the undocumented route reported", err)
+		}
+	})
+
+	t.Run("spec entry without route", func(t *testing.T) {
+		_, router := testRouter(t, false)
+
+		err := api.CheckSpecCoverage(router)
+		if err == nil || !strings.Contains(err.Error(), "no matching route: POST /admin/api-keys") {
+			t.Fatalf("CheckSpecCoverage() = %v, want the unmounted route reported", err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"internal-transfers/internal/auth"
+	"internal-transfers/internal/config"
+	"internal-transfers/internal/migrate"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/repository"
+	"internal-transfers/internal/service"
+	"internal-transfers/internal/tenant"
+	"internal-transfers/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// calls the services directly with the server's database configuration,
+// skipping authentication, ownership checks and rate limits
+type dbBackend struct {
+	pool      *pgxpool.Pool
+	accounts  service.AccountService
+	transfers service.TransferService
+	tenantID  string
+	principal *auth.Principal
+}
+
+func newDBBackend(ctx context.Context, configFile, tenantID string) (backend, error) {
+	if !tenant.ValidID(tenantID) {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidTenantID, tenantID)
+	}
+
+	// the same layers as the server, without its command line flags
+	flags := flag.NewFlagSet("transferctl", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	if configFile != "" {
+		if err := flags.Parse([]string{"-config", configFile}); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage.Backend != "postgres" {
+		return nil, fmt.Errorf("db mode needs the postgres storage backend, the configuration uses %q", cfg.Storage.Backend)
+	}
+
+	pool, err := pgxpool.New(ctx, cfg.Database.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// writing to a schema the services do not expect could corrupt data
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	migrator, err := migrate.New(pool, migrations.FS, logger)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if status.Dirty || status.Version != status.Latest {
+		pool.Close()
+		return nil, fmt.Errorf("database schema is at version %d (dirty %t), transferctl expects %d", status.Version, status.Dirty, status.Latest)
+	}
+
+	store := repository.NewPostgresStore(pool, repository.IsolationLevel(cfg.Database.Isolation))
+	authorizer := service.NewAllowAllAuthorizer()
+
+	// cross-tenant transfers stay subject to the configured policy
+	crossTenant, err := service.ParseCrossTenantPolicy(cfg.Tenancy.CrossTenantTransfers)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	retry := service.RetryPolicy{
+		Attempts:  cfg.Transfers.RetryAttempts,
+		BaseDelay: cfg.Transfers.RetryBaseDelay,
+		MaxDelay:  cfg.Transfers.RetryMaxDelay,
+	}
+
+	// service logs go to stderr so they end up in the operator's terminal
+	serviceLogger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	// one transfer at a time gains nothing from batching, it would only wait,
+	// and queued transfers are left to the server
+	return &dbBackend{
+		pool:      pool,
+		accounts:  service.NewAccountService(store.UnitOfWork, store.Accounts, authorizer, serviceLogger),
+		transfers: service.NewTransferService(store.UnitOfWork, store.Accounts, store.Transactions, authorizer, crossTenant, service.Concurrency(cfg.Transfers.Concurrency), cfg.Transfers.SingleStatement, retry, service.BatchPolicy{}, service.AsyncPolicy{}, serviceLogger),
+		tenantID:  tenantID,
+		principal: operator(tenantID),
+	}, nil
+}
+
+// principal recorded as the initiator of changes made in db mode
+func operator(tenantID string) *auth.Principal {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &auth.Principal{
+		ID:       "transferctl:" + name,
+		TenantID: tenantID,
+		Type:     "transferctl",
+		Name:     name,
+		Scopes:   auth.Scopes,
+		Roles:    []string{auth.RoleAdmin},
+	}
+}
+
+// context the services expect from the API middleware
+func (b *dbBackend) context(ctx context.Context) context.Context {
+	return auth.WithPrincipal(tenant.WithTenant(ctx, b.tenantID), b.principal)
+}
+
+func (b *dbBackend) CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (*models.AccountResponse, error) {
+	account, err := b.accounts.CreateAccount(b.context(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+	response := models.NewAccountResponse(account)
+	return &response, nil
+}
+
+func (b *dbBackend) GetAccount(ctx context.Context, accountID int64) (*models.AccountResponse, error) {
+	account, err := b.accounts.GetAccount(b.context(ctx), accountID)
+	if err != nil {
+		return nil, err
+	}
+	response := models.NewAccountResponse(account)
+	return &response, nil
+}
+
+func (b *dbBackend) Transfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.TransactionResponse, error) {
+	transaction, err := b.transfers.ExecuteTransfer(b.context(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+	response := models.NewTransactionResponse(transaction)
+	return &response, nil
+}
+
+func (b *dbBackend) Reverse(ctx context.Context, transactionID int64) (*models.TransactionResponse, error) {
+	transaction, err := b.transfers.ReverseTransfer(b.context(ctx), transactionID)
+	if err != nil {
+		return nil, err
+	}
+	response := models.NewTransactionResponse(transaction)
+	return &response, nil
+}
+
+func (b *dbBackend) History(ctx context.Context, accountID int64, limit int, before int64) (*models.TransactionListResponse, error) {
+	// fetch one extra row to know whether there is another page
+	transactions, err := b.transfers.ListTransactions(b.context(ctx), accountID, limit+1, before)
+	if err != nil {
+		return nil, err
+	}
+	page := models.NewTransactionListResponse(transactions, limit)
+	return &page, nil
+}
+
+func (b *dbBackend) SetShards(ctx context.Context, accountID int64, shards int) (*models.AccountResponse, error) {
+	account, err := b.accounts.SetShards(b.context(ctx), accountID, shards)
+	if err != nil {
+		return nil, err
+	}
+	response := models.NewAccountResponse(account)
+	return &response, nil
+}
+
+func (b *dbBackend) Reconcile(ctx context.Context) (*models.ReconciliationReport, error) {
+	return b.accounts.Reconcile(b.context(ctx))
+}
+
+func (b *dbBackend) Close() {
+	b.pool.Close()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"internal-transfers/internal/api"
+	"internal-transfers/internal/models"
+	"internal-transfers/internal/requestid"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// talks to the API as any other client would
+type httpBackend struct {
+	baseURL string
+	apiKey  string
+	token   string
+	client  *http.Client
+}
+
+func newHTTPBackend(baseURL, apiKey, token string) backend {
+	return &httpBackend{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		token:   token,
+		client:  &http.Client{},
+	}
+}
+
+// error response returned by the API
+type apiError struct {
+	status    int
+	body      api.ErrorResponse
+	requestID string
+}
+
+func (e *apiError) Error() string {
+	msg := e.body.Error
+	if e.body.Code != "" {
+		msg = e.body.Code + ": " + msg
+	}
+	if e.body.Details != "" && e.body.Details != e.body.Error {
+		msg += " (" + e.body.Details + ")"
+	}
+	for _, f := range e.body.Fields {
+		msg += fmt.Sprintf("\n  %s: failed %s", f.Field, f.Rule)
+	}
+	return fmt.Sprintf("%s [HTTP %d, request id %s]", msg, e.status, e.requestID)
+}
+
+func (b *httpBackend) CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (*models.AccountResponse, error) {
+	var account models.AccountResponse
+	err := b.do(ctx, http.MethodPost, "/accounts", req, &account)
+	return &account, err
+}
+
+func (b *httpBackend) GetAccount(ctx context.Context, accountID int64) (*models.AccountResponse, error) {
+	var account models.AccountResponse
+	err := b.do(ctx, http.MethodGet, fmt.Sprintf("/accounts/%d", accountID), nil, &account)
+	return &account, err
+}
+
+func (b *httpBackend) Transfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.TransactionResponse, error) {
+	var transaction models.TransactionResponse
+	err := b.do(ctx, http.MethodPost, "/transactions", req, &transaction)
+	return &transaction, err
+}
+
+func (b *httpBackend) Reverse(ctx context.Context, transactionID int64) (*models.TransactionResponse, error) {
+	var transaction models.TransactionResponse
+	err := b.do(ctx, http.MethodPost, fmt.Sprintf("/transactions/%d/reversal", transactionID), nil, &transaction)
+	return &transaction, err
+}
+
+func (b *httpBackend) History(ctx context.Context, accountID int64, limit int, before int64) (*models.TransactionListResponse, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if before > 0 {
+		query.Set("before", strconv.FormatInt(before, 10))
+	}
+
+	var page models.TransactionListResponse
+	err := b.do(ctx, http.MethodGet, fmt.Sprintf("/accounts/%d/transactions?%s", accountID, query.Encode()), nil, &page)
+	return &page, err
+}
+
+func (b *httpBackend) SetShards(ctx context.Context, accountID int64, shards int) (*models.AccountResponse, error) {
+	var account models.AccountResponse
+	err := b.do(ctx, http.MethodPut, fmt.Sprintf("/admin/accounts/%d/shards", accountID), &models.SetShardsRequest{Shards: shards}, &account)
+	return &account, err
+}
+
+func (b *httpBackend) Reconcile(ctx context.Context) (*models.ReconciliationReport, error) {
+	var report models.ReconciliationReport
+	err := b.do(ctx, http.MethodGet, "/admin/reconciliation", nil, &report)
+	return &report, err
+}
+
+func (b *httpBackend) Close() {}
+
+// send a request and decode the JSON response into out
+func (b *httpBackend) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// lets the operator find the request in the server logs
+	requestID := requestid.New()
+	req.Header.Set(requestid.Header, requestID)
+
+	if b.apiKey != "" {
+		req.Header.Set("X-API-Key", b.apiKey)
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &apiError{status: resp.StatusCode, requestID: requestID}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr.body); err != nil || apiErr.body.Error == "" {
+			apiErr.body.Error = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from %s %s: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// transferctl manages accounts and transfers through the HTTP API, or
+// directly against the database when the API is unavailable.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"internal-transfers/internal/models"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const usage = `usage: transferctl [global flags] <command> [arguments]
+
+commands:
+  account create [-owner id] <account_id> <initial_balance>
+  account get <account_id>
+  account shard <account_id> <shards>
+  transfer [-dest-tenant id] <source_account_id> <destination_account_id> <amount>
+  reverse <transaction_id>
+  history [-limit n] [-before transaction_id] <account_id>
+  reconcile
+
+global flags:
+`
+
+// error in the command line, reported with the usage
+var errUsage = errors.New("invalid usage")
+
+// operations available through either the API or the database
+type backend interface {
+	CreateAccount(ctx context.Context, req *models.CreateAccountRequest) (*models.AccountResponse, error)
+	GetAccount(ctx context.Context, accountID int64) (*models.AccountResponse, error)
+	Transfer(ctx context.Context, req *models.CreateTransactionRequest) (*models.TransactionResponse, error)
+	Reverse(ctx context.Context, transactionID int64) (*models.TransactionResponse, error)
+	History(ctx context.Context, accountID int64, limit int, before int64) (*models.TransactionListResponse, error)
+	SetShards(ctx context.Context, accountID int64, shards int) (*models.AccountResponse, error)
+	Reconcile(ctx context.Context) (*models.ReconciliationReport, error)
+	Close()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("transferctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	mode := flags.String("mode", "http", "http to use the API, db to use the database directly (break-glass)")
+	url := flags.String("url", envOr("TRANSFERCTL_URL", "http://localhost:8080"), "API base URL (env TRANSFERCTL_URL)")
+	apiKey := flags.String("api-key", os.Getenv("TRANSFERCTL_API_KEY"), "API key (env TRANSFERCTL_API_KEY)")
+	token := flags.String("token", os.Getenv("TRANSFERCTL_TOKEN"), "JWT bearer token (env TRANSFERCTL_TOKEN)")
+	configFile := flags.String("config", "", "server config file for db mode (env CONFIG_FILE)")
+	tenantID := flags.String("tenant", "default", "tenant to act in for db mode, the API takes it from the credentials")
+	output := flags.String("output", "table", "output format, table or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "time limit for the command")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var out printer
+	switch *output {
+	case "table":
+		out = tablePrinter{w: stdout}
+	case "json":
+		out = jsonPrinter{w: stdout}
+	default:
+		fmt.Fprintf(stderr, "unknown output format %q\n", *output)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var b backend
+	var err error
+	switch *mode {
+	case "http":
+		b = newHTTPBackend(*url, *apiKey, *token)
+	case "db":
+		fmt.Fprintln(stderr, "warning: db mode bypasses API authentication and rate limits, use it only when the API is unavailable")
+		b, err = newDBBackend(ctx, *configFile, *tenantID)
+	default:
+		err = fmt.Errorf("%w: unknown mode %q", errUsage, *mode)
+	}
+	if err == nil {
+		defer b.Close()
+		err = runCommand(ctx, b, out, flags.Args(), stderr)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// dispatch a command to the backend and print the result
+func runCommand(ctx context.Context, b backend, out printer, args []string, stderr io.Writer) error {
+	switch args[0] {
+	case "account":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: account needs a subcommand", errUsage)
+		}
+		switch args[1] {
+		case "create":
+			return createAccount(ctx, b, out, args[2:], stderr)
+		case "get":
+			accountID, err := parseArgs1(args[2:], "account_id")
+			if err != nil {
+				return err
+			}
+			account, err := b.GetAccount(ctx, accountID)
+			if err != nil {
+				return err
+			}
+			return out.Account(account)
+		case "shard":
+			if len(args) != 4 {
+				return fmt.Errorf("%w: account shard needs an account_id and a number of shards", errUsage)
+			}
+			accountID, err := parseID(args[2], "account_id")
+			if err != nil {
+				return err
+			}
+			shards, err := strconv.Atoi(args[3])
+			if err != nil || shards < 0 {
+				return fmt.Errorf("%w: shards must be 0 or a positive integer, got %q", errUsage, args[3])
+			}
+			account, err := b.SetShards(ctx, accountID, shards)
+			if err != nil {
+				return err
+			}
+			return out.Account(account)
+		}
+		return fmt.Errorf("%w: unknown account subcommand %q", errUsage, args[1])
+
+	case "transfer":
+		return transfer(ctx, b, out, args[1:], stderr)
+
+	case "reverse":
+		transactionID, err := parseArgs1(args[1:], "transaction_id")
+		if err != nil {
+			return err
+		}
+		reversal, err := b.Reverse(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+		return out.Transactions([]models.TransactionResponse{*reversal})
+
+	case "history":
+		return history(ctx, b, out, args[1:], stderr)
+
+	case "reconcile":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: reconcile takes no arguments", errUsage)
+		}
+		report, err := b.Reconcile(ctx)
+		if err != nil {
+			return err
+		}
+		if err := out.Reconciliation(report); err != nil {
+			return err
+		}
+		if len(report.Mismatches) > 0 {
+			return fmt.Errorf("%d accounts do not match their transfer history", len(report.Mismatches))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+}
+
+func createAccount(ctx context.Context, b backend, out printer, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("account create", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	owner := flags.String("owner", "", "owner of the account, defaults to the caller")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("%w: account create needs an account_id and an initial_balance", errUsage)
+	}
+
+	accountID, err := parseID(flags.Arg(0), "account_id")
+	if err != nil {
+		return err
+	}
+	balance, err := parseAmount(flags.Arg(1), "initial_balance")
+	if err != nil {
+		return err
+	}
+
+	account, err := b.CreateAccount(ctx, &models.CreateAccountRequest{
+		AccountID:      accountID,
+		InitialBalance: balance,
+		OwnerID:        *owner,
+	})
+	if err != nil {
+		return err
+	}
+	return out.Account(account)
+}
+
+func transfer(ctx context.Context, b backend, out printer, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("transfer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	destTenant := flags.String("dest-tenant", "", "tenant of the destination account, defaults to the caller's")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 3 {
+		return fmt.Errorf("%w: transfer needs a source_account_id, destination_account_id and amount", errUsage)
+	}
+
+	source, err := parseID(flags.Arg(0), "source_account_id")
+	if err != nil {
+		return err
+	}
+	destination, err := parseID(flags.Arg(1), "destination_account_id")
+	if err != nil {
+		return err
+	}
+	amount, err := parseAmount(flags.Arg(2), "amount")
+	if err != nil {
+		return err
+	}
+
+	transaction, err := b.Transfer(ctx, &models.CreateTransactionRequest{
+		SourceAccountID:      source,
+		DestinationAccountID: destination,
+		DestinationTenantID:  *destTenant,
+		Amount:               amount,
+	})
+	if err != nil {
+		return err
+	}
+	return out.Transactions([]models.TransactionResponse{*transaction})
+}
+
+func history(ctx context.Context, b backend, out printer, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	limit := flags.Int("limit", 50, "number of transfers to list, at most 200")
+	before := flags.Int64("before", 0, "only list transfers with a lower ID")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *limit < 1 || *limit > 200 {
+		return fmt.Errorf("%w: limit must be between 1 and 200", errUsage)
+	}
+	accountID, err := parseArgs1(flags.Args(), "account_id")
+	if err != nil {
+		return err
+	}
+
+	page, err := b.History(ctx, accountID, *limit, *before)
+	if err != nil {
+		return err
+	}
+	if err := out.Transactions(page.Transactions); err != nil {
+		return err
+	}
+	if page.NextBefore != nil {
+		fmt.Fprintf(stderr, "more transfers available, continue with -before %d\n", *page.NextBefore)
+	}
+	return nil
+}
+
+// parse a command taking a single ID argument
+func parseArgs1(args []string, name string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%w: expected a single %s", errUsage, name)
+	}
+	return parseID(args[0], name)
+}
+
+func parseID(s, name string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: %s must be a positive integer, got %q", errUsage, name, s)
+	}
+	return id, nil
+}
+
+func parseAmount(s, name string) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %s must be a decimal number, got %q", errUsage, name, s)
+	}
+	return amount, nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal-transfers/internal/models"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// renders command results
+type printer interface {
+	Account(account *models.AccountResponse) error
+	Transactions(transactions []models.TransactionResponse) error
+	Reconciliation(report *models.ReconciliationReport) error
+}
+
+// prints the API's JSON representation, for scripts
+type jsonPrinter struct {
+	w io.Writer
+}
+
+func (p jsonPrinter) Account(account *models.AccountResponse) error {
+	return p.print(account)
+}
+
+func (p jsonPrinter) Transactions(transactions []models.TransactionResponse) error {
+	return p.print(transactions)
+}
+
+func (p jsonPrinter) Reconciliation(report *models.ReconciliationReport) error {
+	return p.print(report)
+}
+
+func (p jsonPrinter) print(v any) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// prints aligned columns, for people
+type tablePrinter struct {
+	w io.Writer
+}
+
+func (p tablePrinter) Account(account *models.AccountResponse) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tACCOUNT\tBALANCE\tOWNER\tSHARDS")
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\n", account.TenantID, account.AccountID, account.Balance, orDash(account.OwnerID), account.Shards)
+	return tw.Flush()
+}
+
+func (p tablePrinter) Transactions(transactions []models.TransactionResponse) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tSOURCE\tDESTINATION\tAMOUNT\tSTATUS\tREVERSAL OF\tERROR")
+	for _, t := range transactions {
+		reversalOf := "-"
+		if t.ReversalOf != nil {
+			reversalOf = strconv.FormatInt(*t.ReversalOf, 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.TransactionID,
+			t.CreatedAt.Format(time.RFC3339),
+			accountRef(t.TenantID, t.SourceAccountID),
+			accountRef(t.DestinationTenantID, t.DestinationAccountID),
+			t.Amount,
+			t.Status,
+			reversalOf,
+			orDash(t.ErrorMessage),
+		)
+	}
+	return tw.Flush()
+}
+
+func (p tablePrinter) Reconciliation(report *models.ReconciliationReport) error {
+	fmt.Fprintf(p.w, "tenant:           %s\n", report.TenantID)
+	fmt.Fprintf(p.w, "accounts checked: %d\n", report.AccountsChecked)
+	fmt.Fprintf(p.w, "total balance:    %s\n", report.TotalBalance)
+	fmt.Fprintf(p.w, "mismatches:       %d\n", len(report.Mismatches))
+	if len(report.Mismatches) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(p.w)
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tBALANCE\tEXPECTED")
+	for _, m := range report.Mismatches {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", m.AccountID, m.Balance, m.Expected)
+	}
+	return tw.Flush()
+}
+
+// tenant/account, so cross-tenant transfers are easy to spot
+func accountRef(tenantID string, accountID int64) string {
+	return fmt.Sprintf("%s/%d", tenantID, accountID)
+}
+
+func orDash(s *string) string {
+	if s == nil || *s == "" {
+		return "-"
+	}
+	return *s
+}